@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"resolve/compression"
+	"resolve/emitters"
+	"resolve/metrics"
+	"resolve/models"
+	"resolve/tailer"
+)
+
+// AgentConfig holds the configuration of the file-tailing agent
+type AgentConfig struct {
+	AgentID          string             `json:"agent_id"`
+	Port             int                `json:"port"` // health, stats and metrics
+	DistributorURLs  []string           `json:"distributor_urls"`
+	DeliveryMode     string             `json:"delivery_mode"`     // round_robin, least_loaded or failover
+	FailoverCooldown int                `json:"failover_cooldown"` // milliseconds a failed distributor is skipped
+	Compression      string             `json:"compression"`       // gzip, snappy, deflate or identity, once a distributor advertises it
+	WireFormat       string             `json:"wire_format"`       // json or binary packets
+	Spool            models.SpoolConfig `json:"spool"`
+	Tail             models.TailConfig  `json:"tail"`
+}
+
+// Agent ships lines appended to local files to the distributors
+type Agent struct {
+	config   AgentConfig
+	tailer   *tailer.Tailer
+	balancer *emitters.BalancingEmitter
+	spool    *emitters.SpoolingEmitter
+	metrics  *metrics.Registry
+	server   *http.Server
+}
+
+// NewAgent builds the emitter chain and the tailer
+func NewAgent(config AgentConfig) (*Agent, error) {
+	agent := &Agent{
+		config:  config,
+		metrics: metrics.NewRegistry(),
+	}
+
+	targets := make([]models.Emitter, len(config.DistributorURLs))
+	for i, distributorURL := range config.DistributorURLs {
+		targets[i] = emitters.NewHTTPEmitter(models.EmitterConfig{
+			ID:            fmt.Sprintf("distributor-%d", i+1),
+			Endpoint:      distributorURL,
+			Timeout:       30 * time.Second,
+			RetryCount:    3,
+			RetryDelay:    1 * time.Second,
+			MaxRetryDelay: 10 * time.Second,
+			RetryJitter:   0.2,
+			Compression:   config.Compression,
+			WireFormat:    config.WireFormat,
+		})
+	}
+	balancer, err := emitters.NewBalancingEmitter(config.AgentID, config.DeliveryMode, targets,
+		time.Duration(config.FailoverCooldown)*time.Millisecond)
+	if err != nil {
+		return nil, err
+	}
+	agent.balancer = balancer
+
+	// Lines are only checkpointed once sent or spooled, so the spool lets the agent keep
+	// reading while the distributors are down
+	var emitter models.Emitter = balancer
+	if config.Spool.Dir != "" {
+		spool, err := emitters.NewSpoolingEmitter(config.Spool, balancer)
+		if err != nil {
+			return nil, err
+		}
+		agent.spool = spool
+		emitter = spool
+	}
+
+	agent.tailer, err = tailer.New(config.AgentID, config.Tail, emitter)
+	if err != nil {
+		return nil, err
+	}
+
+	agent.metrics.GaugeFunc("agent_files_tailed", "Files currently being followed.", func() float64 {
+		return float64(len(agent.tailer.Stats().Files))
+	})
+	agent.metrics.GaugeFunc("agent_lines_read", "Lines read and handed to the distributors or the spool.", func() float64 {
+		return float64(agent.tailer.Stats().LinesRead)
+	})
+	agent.metrics.GaugeFunc("agent_spool_packets", "Log packets waiting in the spool.", func() float64 {
+		if agent.spool == nil {
+			return 0
+		}
+		return float64(agent.spool.Stats().Packets)
+	})
+	return agent, nil
+}
+
+// Start serves /health, /stats and /metrics
+func (a *Agent) Start() error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", a.handleHealth)
+	mux.HandleFunc("/stats", a.handleStats)
+	mux.Handle("/metrics", a.metrics.Handler())
+
+	a.server = &http.Server{
+		Addr:    fmt.Sprintf(":%d", a.config.Port),
+		Handler: mux,
+	}
+
+	log.Printf("Agent %s starting on port %d", a.config.AgentID, a.config.Port)
+	log.Printf("Stats endpoint available at http://localhost:%d/stats", a.config.Port)
+	return a.server.ListenAndServe()
+}
+
+func (a *Agent) handleHealth(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	response := map[string]interface{}{
+		"status":    "healthy",
+		"service":   "agent",
+		"agent_id":  a.config.AgentID,
+		"timestamp": time.Now().Format(time.RFC3339),
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
+
+func (a *Agent) handleStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	response := map[string]interface{}{
+		"tail":         a.tailer.Stats(),
+		"distributors": a.balancer.Stats(),
+		"timestamp":    time.Now().Format(time.RFC3339),
+	}
+	if a.spool != nil {
+		response["spool"] = a.spool.Stats()
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
+
+// loadConfig loads agent configuration from a JSON file
+func loadConfig(configPath string) (*AgentConfig, error) {
+	// Read the config file
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file %s: %w", configPath, err)
+	}
+
+	// Parse the JSON configuration
+	var config AgentConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse config file: %w", err)
+	}
+
+	// Set default values if not provided
+	if config.AgentID == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, fmt.Errorf("no agent_id configured and the hostname is unavailable: %w", err)
+		}
+		config.AgentID = "agent-" + hostname
+	}
+	if config.DeliveryMode == "" {
+		config.DeliveryMode = models.DeliveryFailover
+	}
+	if config.FailoverCooldown <= 0 {
+		config.FailoverCooldown = 10000
+	}
+	if config.Compression == "" {
+		config.Compression = compression.Gzip
+	}
+	if config.WireFormat == "" {
+		config.WireFormat = models.WireFormatJSON
+	}
+
+	// Validate configuration
+	if config.Port <= 0 {
+		return nil, fmt.Errorf("invalid port number: %d", config.Port)
+	}
+	if len(config.DistributorURLs) == 0 {
+		return nil, fmt.Errorf("no distributor URLs configured")
+	}
+	if config.DeliveryMode == models.DeliveryBroadcast {
+		return nil, fmt.Errorf("delivery mode %s would send every line to every distributor", config.DeliveryMode)
+	}
+	if !compression.Valid(config.Compression) {
+		return nil, fmt.Errorf("invalid compression: %s", config.Compression)
+	}
+	switch config.WireFormat {
+	case models.WireFormatJSON, models.WireFormatBinary:
+	default:
+		return nil, fmt.Errorf("invalid wire format: %s", config.WireFormat)
+	}
+
+	log.Printf("Loaded configuration:")
+	log.Printf("  Agent ID: %s", config.AgentID)
+	log.Printf("  Port: %d", config.Port)
+	log.Printf("  Distributor URLs: %v (%s)", config.DistributorURLs, config.DeliveryMode)
+	log.Printf("  Inputs: %d, checkpoint: %s", len(config.Tail.Inputs), config.Tail.Checkpoint)
+
+	return &config, nil
+}
+
+func main() {
+	// Load configuration from JSON file
+	configPath := "config.json"
+	if len(os.Args) > 1 {
+		configPath = os.Args[1]
+	}
+
+	config, err := loadConfig(configPath)
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	agent, err := NewAgent(*config)
+	if err != nil {
+		log.Fatalf("Failed to create agent: %v", err)
+	}
+
+	go func() {
+		if err := agent.Start(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("Failed to start agent: %v", err)
+		}
+	}()
+
+	// Tail until SIGINT or SIGTERM, then save the checkpoint and close the spool
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if err := agent.tailer.Run(ctx); err != nil {
+		log.Printf("Failed to save checkpoint: %v", err)
+	}
+	log.Printf("Shutting down agent %s", config.AgentID)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if agent.spool != nil {
+		agent.spool.Close(shutdownCtx)
+	}
+	agent.server.Shutdown(shutdownCtx)
+}
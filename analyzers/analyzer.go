@@ -1,15 +1,23 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
+	"resolve/compression"
+	"resolve/metrics"
 	"resolve/models"
 )
 
@@ -98,14 +106,50 @@ type AnalyzerServer struct {
 	analyzer *BasicAnalyzer
 	port     int
 	server   *http.Server
+	shutdown models.ShutdownConfig
+	draining atomic.Bool
+
+	// Largest size a compressed request body may decode to
+	maxDecodedSize int64
+
+	// Series served on /metrics
+	metrics         *metrics.Registry
+	processed       *metrics.CounterVec
+	errors          *metrics.CounterVec
+	analyzeDuration *metrics.HistogramVec
 }
 
 // NewAnalyzerServer creates a new analyzer server
 func NewAnalyzerServer(analyzer *BasicAnalyzer, port int) *AnalyzerServer {
+	registry := metrics.NewRegistry()
 	return &AnalyzerServer{
-		analyzer: analyzer,
-		port:     port,
+		analyzer:       analyzer,
+		port:           port,
+		maxDecodedSize: compression.DefaultMaxDecodedSize,
+		metrics:        registry,
+		processed: registry.Counter("analyzer_messages_processed_total",
+			"Log messages analyzed successfully.", "analyzer"),
+		errors: registry.Counter("analyzer_errors_total",
+			"Log messages that failed analysis.", "analyzer"),
+		analyzeDuration: registry.Histogram("analyzer_analyze_duration_seconds",
+			"Time spent analyzing one log message.", nil, "analyzer"),
+	}
+}
+
+// analyze runs the analyzer on one message and records its outcome and duration
+func (as *AnalyzerServer) analyze(logMessage models.LogMessage) (time.Duration, error) {
+	start := time.Now()
+	err := as.analyzer.Analyze(logMessage)
+	duration := time.Since(start)
+
+	id := as.analyzer.GetID()
+	as.analyzeDuration.With(id).ObserveDuration(duration)
+	if err != nil {
+		as.errors.With(id).Inc()
+	} else {
+		as.processed.With(id).Inc()
 	}
+	return duration, err
 }
 
 // Start starts the HTTP server
@@ -113,11 +157,13 @@ func (as *AnalyzerServer) Start() error {
 	// Set up routes
 	mux := http.NewServeMux()
 	mux.HandleFunc("/analyze", as.handleAnalyze)
+	mux.HandleFunc("/analyze/batch", as.handleAnalyzeBatch)
 	mux.HandleFunc("/health", as.handleHealth)
 	mux.HandleFunc("/status", as.handleStatus)
 	mux.HandleFunc("/processed", as.handleProcessed)
 	mux.HandleFunc("/disable", as.handleDisable)
 	mux.HandleFunc("/enable", as.handleEnable)
+	mux.Handle("/metrics", as.metrics.Handler())
 
 	// Create server
 	as.server = &http.Server{
@@ -129,15 +175,31 @@ func (as *AnalyzerServer) Start() error {
 	log.Printf("Health check available at http://localhost:%d/health", as.port)
 	log.Printf("Status endpoint available at http://localhost:%d/status", as.port)
 	log.Printf("Analyze endpoint available at http://localhost:%d/analyze", as.port)
+	log.Printf("Batch analyze endpoint available at http://localhost:%d/analyze/batch", as.port)
 	log.Printf("Processed count endpoint available at http://localhost:%d/processed", as.port)
+	log.Printf("Metrics available at http://localhost:%d/metrics", as.port)
 
 	return as.server.ListenAndServe()
 }
 
-// Stop gracefully stops the server
+// Stop gracefully stops the server. /health reports draining and new messages are
+// refused for the drain delay, then in-flight analyses get until the shutdown timeout
+// to finish before remaining connections are closed.
 func (as *AnalyzerServer) Stop() error {
-	if as.server != nil {
-		log.Printf("Stopping analyzer server %s", as.analyzer.GetID())
+	if as.server == nil {
+		return nil
+	}
+
+	as.draining.Store(true)
+	log.Printf("Draining analyzer server %s for %d ms", as.analyzer.GetID(), as.shutdown.DrainDelay)
+	time.Sleep(time.Duration(as.shutdown.DrainDelay) * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(as.shutdown.Timeout)*time.Millisecond)
+	defer cancel()
+
+	log.Printf("Stopping analyzer server %s", as.analyzer.GetID())
+	if err := as.server.Shutdown(ctx); err != nil {
+		log.Printf("In-flight analyses did not finish in time, closing connections: %v", err)
 		return as.server.Close()
 	}
 	return nil
@@ -150,10 +212,23 @@ func (as *AnalyzerServer) handleAnalyze(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	// Parse the log message
+	// A draining analyzer takes no new work so the distributor reroutes it
+	if as.draining.Load() {
+		http.Error(w, "Analyzer is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+
+	// Parse the log message, decoding a compressed body first
 	var logMessage models.LogMessage
+	if !as.decodeRequestBody(w, r) {
+		return
+	}
 	if err := json.NewDecoder(r.Body).Decode(&logMessage); err != nil {
 		log.Printf("Error decoding log message: %v", err)
+		if errors.Is(err, compression.ErrTooLarge) {
+			compression.WriteError(w, err)
+			return
+		}
 		http.Error(w, "Invalid JSON", http.StatusBadRequest)
 		return
 	}
@@ -162,9 +237,7 @@ func (as *AnalyzerServer) handleAnalyze(w http.ResponseWriter, r *http.Request)
 	log.Printf("Received log message %s from %s for analysis", logMessage.ID, r.Header.Get("User-Agent"))
 
 	// Analyze the log message
-	start := time.Now()
-	err := as.analyzer.Analyze(logMessage)
-	duration := time.Since(start)
+	duration, err := as.analyze(logMessage)
 
 	if err != nil {
 		log.Printf("Analysis failed for log message %s: %v (took %v)", logMessage.ID, err, duration)
@@ -187,20 +260,89 @@ func (as *AnalyzerServer) handleAnalyze(w http.ResponseWriter, r *http.Request)
 	log.Printf("Successfully analyzed log message %s in %v", logMessage.ID, duration)
 }
 
-// handleHealth provides a health check endpoint
+// handleAnalyzeBatch analyzes many log messages in one request and reports a result per message
+func (as *AnalyzerServer) handleAnalyzeBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if as.draining.Load() {
+		http.Error(w, "Analyzer is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+
+	// Parse the batch, decoding a compressed body first
+	var batch models.AnalyzeBatchRequest
+	if !as.decodeRequestBody(w, r) {
+		return
+	}
+	if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+		log.Printf("Error decoding log message batch: %v", err)
+		if errors.Is(err, compression.ErrTooLarge) {
+			compression.WriteError(w, err)
+			return
+		}
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	log.Printf("Received batch of %d log messages from %s for analysis", len(batch.Messages), r.Header.Get("User-Agent"))
+
+	// Analyze each message, recording its outcome in request order
+	start := time.Now()
+	results := make([]models.AnalyzeResult, len(batch.Messages))
+	failed := 0
+	for i, logMessage := range batch.Messages {
+		results[i] = models.AnalyzeResult{LogID: logMessage.ID, Success: true}
+		if _, err := as.analyze(logMessage); err != nil {
+			results[i].Success = false
+			results[i].Error = err.Error()
+			failed++
+		}
+	}
+	duration := time.Since(start)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.AnalyzeBatchResponse{
+		Analyzer: as.analyzer.GetID(),
+		Results:  results,
+	})
+	log.Printf("Analyzed batch of %d log messages (%d failed) in %v", len(batch.Messages), failed, duration)
+}
+
+// decodeRequestBody decodes a compressed request body, answering the request itself and
+// returning false when it cannot
+func (as *AnalyzerServer) decodeRequestBody(w http.ResponseWriter, r *http.Request) bool {
+	compression.Advertise(w)
+	if err := compression.DecodeRequest(r, as.maxDecodedSize); err != nil {
+		log.Printf("Error decoding %s request body: %v", r.Header.Get("Content-Encoding"), err)
+		compression.WriteError(w, err)
+		return false
+	}
+	return true
+}
+
+// handleHealth provides a health check endpoint. The Accept-Encoding header tells the
+// distributor which request encodings are accepted.
 func (as *AnalyzerServer) handleHealth(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
+	compression.Advertise(w)
 
 	status := "healthy"
-	if !as.analyzer.IsHealthy() {
+	if as.draining.Load() {
+		status = "draining"
+		w.WriteHeader(http.StatusServiceUnavailable)
+	} else if !as.analyzer.IsHealthy() {
 		status = "unhealthy"
 		w.WriteHeader(http.StatusServiceUnavailable)
 	}
 
 	response := map[string]interface{}{
-		"status":    status,
-		"analyzer":  as.analyzer.GetID(),
-		"timestamp": time.Now().Format(time.RFC3339),
+		"status":       status,
+		"analyzer":     as.analyzer.GetID(),
+		"capabilities": []string{models.CapabilityBatch},
+		"timestamp":    time.Now().Format(time.RFC3339),
 	}
 
 	json.NewEncoder(w).Encode(response)
@@ -287,6 +429,41 @@ func (as *AnalyzerServer) handleEnable(w http.ResponseWriter, r *http.Request) {
 	log.Printf("Analyzer %s enabled via HTTP request", as.analyzer.GetID())
 }
 
+// registrationFromEnv describes this analyzer to the distributors. ANALYZER_ENDPOINT sets
+// the advertised analyze URL (default http://<hostname>:<port>/analyze); ANALYZER_WEIGHT,
+// ANALYZER_CAPACITY and ANALYZER_GROUPS are optional.
+func registrationFromEnv(analyzerID string, port int) models.MemberRegistration {
+	endpoint := os.Getenv("ANALYZER_ENDPOINT")
+	if endpoint == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			hostname = "localhost"
+		}
+		endpoint = fmt.Sprintf("http://%s:%d/analyze", hostname, port)
+	}
+
+	reg := models.MemberRegistration{
+		ID:       analyzerID,
+		Endpoint: endpoint,
+		Capacity: envInt("ANALYZER_CAPACITY", 0),
+	}
+	if weight, err := strconv.ParseFloat(os.Getenv("ANALYZER_WEIGHT"), 64); err == nil {
+		reg.Weight = weight
+	}
+	if groups := os.Getenv("ANALYZER_GROUPS"); groups != "" {
+		reg.Groups = strings.Split(groups, ",")
+	}
+	return reg
+}
+
+// envInt reads an integer environment variable, falling back to def
+func envInt(name string, def int) int {
+	if value, err := strconv.Atoi(os.Getenv(name)); err == nil {
+		return value
+	}
+	return def
+}
+
 func main() {
 	// Default configuration
 	analyzerID := "analyzer-1"
@@ -302,17 +479,50 @@ func main() {
 		}
 	}
 
+	// Scaled instances share one command line, so registered analyzers default to the hostname
+	distributorURLs := os.Getenv("DISTRIBUTOR_URLS")
+	if len(os.Args) <= 1 && distributorURLs != "" {
+		if hostname, err := os.Hostname(); err == nil {
+			analyzerID = "analyzer-" + hostname
+		}
+	}
+
 	// Create analyzer
 	analyzer := NewBasicAnalyzer(analyzerID)
 
 	// Create and start analyzer server
 	server := NewAnalyzerServer(analyzer, port)
+	server.shutdown = models.ShutdownConfig{
+		DrainDelay: envInt("SHUTDOWN_DRAIN_DELAY", 0),
+		Timeout:    envInt("SHUTDOWN_TIMEOUT", 10000),
+	}
+	server.maxDecodedSize = int64(envInt("MAX_DECODED_SIZE", compression.DefaultMaxDecodedSize))
 
 	log.Printf("Starting analyzer server with ID: %s, Port: %d",
 		analyzerID, port)
 
+	// Register with the distributors, if any
+	var reg *registrar
+	if distributorURLs != "" {
+		reg = newRegistrar(registrationFromEnv(analyzerID, port), strings.Split(distributorURLs, ","),
+			time.Duration(envInt("HEARTBEAT_INTERVAL", 5000))*time.Millisecond)
+		reg.start()
+	}
+
 	// Start the server
-	if err := server.Start(); err != nil && err != http.ErrServerClosed {
-		log.Fatalf("Failed to start analyzer server: %v", err)
+	go func() {
+		if err := server.Start(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("Failed to start analyzer server: %v", err)
+		}
+	}()
+
+	// On SIGINT or SIGTERM leave the distributors first so they stop routing here, then drain
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-signals
+	log.Printf("%v received, shutting down analyzer %s", sig, analyzerID)
+	if reg != nil {
+		reg.shutdown()
 	}
+	server.Stop()
 }
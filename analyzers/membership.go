@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"resolve/models"
+)
+
+// registrar keeps an analyzer registered with one or more distributors by
+// registering on startup, sending heartbeats and deregistering on shutdown
+type registrar struct {
+	registration models.MemberRegistration
+	distributors []string // distributor base URLs, e.g. "http://distributor:8080"
+	interval     time.Duration
+	client       *http.Client
+	stop         chan struct{}
+	wg           sync.WaitGroup
+}
+
+// newRegistrar creates a registrar for the given distributors
+func newRegistrar(registration models.MemberRegistration, distributors []string, interval time.Duration) *registrar {
+	return &registrar{
+		registration: registration,
+		distributors: distributors,
+		interval:     interval,
+		client:       &http.Client{Timeout: 5 * time.Second},
+		stop:         make(chan struct{}),
+	}
+}
+
+// start begins registering and heartbeating against every distributor
+func (r *registrar) start() {
+	for _, distributor := range r.distributors {
+		r.wg.Add(1)
+		go r.run(strings.TrimSuffix(distributor, "/"))
+	}
+}
+
+// shutdown stops the heartbeats and deregisters from every distributor
+func (r *registrar) shutdown() {
+	close(r.stop)
+	r.wg.Wait()
+
+	for _, distributor := range r.distributors {
+		url := fmt.Sprintf("%s/members/%s", strings.TrimSuffix(distributor, "/"), r.registration.ID)
+		req, err := http.NewRequest("DELETE", url, nil)
+		if err != nil {
+			continue
+		}
+		resp, err := r.client.Do(req)
+		if err != nil {
+			log.Printf("[MEMBERS] Failed to deregister from %s: %v", distributor, err)
+			continue
+		}
+		resp.Body.Close()
+		log.Printf("[MEMBERS] Deregistered from %s", distributor)
+	}
+}
+
+// run keeps the analyzer registered with one distributor until shutdown. A failed
+// registration is retried on the next tick, and a heartbeat the distributor does not
+// recognize (after it restarted or expired this analyzer) triggers a new registration.
+func (r *registrar) run(distributor string) {
+	defer r.wg.Done()
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	registered := r.register(distributor)
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+		}
+
+		if !registered {
+			registered = r.register(distributor)
+			continue
+		}
+		if err := r.heartbeat(distributor); err != nil {
+			log.Printf("[MEMBERS] Heartbeat to %s failed: %v", distributor, err)
+			if isNotRegistered(err) {
+				registered = r.register(distributor)
+			}
+		}
+	}
+}
+
+// register announces the analyzer to a distributor
+func (r *registrar) register(distributor string) bool {
+	body, err := json.Marshal(r.registration)
+	if err != nil {
+		log.Printf("[MEMBERS] Failed to marshal registration: %v", err)
+		return false
+	}
+
+	resp, err := r.client.Post(distributor+"/members", "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Printf("[MEMBERS] Failed to register with %s: %v", distributor, err)
+		return false
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		log.Printf("[MEMBERS] Distributor %s rejected registration with status %d", distributor, resp.StatusCode)
+		return false
+	}
+	log.Printf("[MEMBERS] Registered with %s as %s (%s)", distributor, r.registration.ID, r.registration.Endpoint)
+	return true
+}
+
+// errNotRegistered is returned when the distributor no longer knows this analyzer
+type errNotRegistered struct{ distributor string }
+
+func (e errNotRegistered) Error() string {
+	return fmt.Sprintf("not registered with %s", e.distributor)
+}
+
+func isNotRegistered(err error) bool {
+	_, ok := err.(errNotRegistered)
+	return ok
+}
+
+// heartbeat tells a distributor the analyzer is still alive
+func (r *registrar) heartbeat(distributor string) error {
+	url := fmt.Sprintf("%s/members/%s/heartbeat", distributor, r.registration.ID)
+	resp, err := r.client.Post(url, "application/json", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNoContent, http.StatusOK:
+		return nil
+	case http.StatusNotFound:
+		return errNotRegistered{distributor}
+	default:
+		return fmt.Errorf("distributor returned status code: %d", resp.StatusCode)
+	}
+}
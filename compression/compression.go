@@ -0,0 +1,248 @@
+package compression
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Content encodings for request bodies
+const (
+	Identity = "identity" // not encoded
+	Gzip     = "gzip"
+	Deflate  = "deflate" // zlib format, as HTTP defines it
+	Snappy   = "snappy"  // Snappy block format; fast, with a lower ratio than gzip
+)
+
+// Supported lists the encodings this package decodes, in order of preference
+var Supported = []string{Gzip, Snappy, Deflate}
+
+// MinSize is the smallest body worth encoding; smaller ones are sent as they are
+const MinSize = 1024
+
+// DefaultMaxDecodedSize bounds a decoded body unless a server configures another limit
+const DefaultMaxDecodedSize = 64 * 1024 * 1024
+
+var (
+	// ErrUnsupported is returned for a Content-Encoding this package does not know
+	ErrUnsupported = errors.New("unsupported content encoding")
+
+	// ErrTooLarge is returned once a decoded body grows past its limit
+	ErrTooLarge = errors.New("decoded body exceeds the size limit")
+)
+
+// Valid reports whether encoding is one that can be configured: a supported encoding,
+// identity, or empty for identity
+func Valid(encoding string) bool {
+	return encoding == "" || encoding == Identity || isSupported(encoding)
+}
+
+// isSupported reports whether encoding is in Supported
+func isSupported(encoding string) bool {
+	for _, supported := range Supported {
+		if encoding == supported {
+			return true
+		}
+	}
+	return false
+}
+
+// Encode compresses data with encoding
+func Encode(encoding string, data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch encoding {
+	case "", Identity:
+		return data, nil
+	case Snappy:
+		return encodeSnappy(data), nil
+	case Gzip:
+		w, _ = gzip.NewWriterLevel(&buf, gzip.BestSpeed)
+	case Deflate:
+		w, _ = zlib.NewWriterLevel(&buf, flate.BestSpeed)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupported, encoding)
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// NewReader decodes body, failing with ErrTooLarge once more than limit bytes have
+// been decoded. A limit of 0 leaves the decoded size unbounded.
+func NewReader(encoding string, body io.Reader, limit int64) (io.ReadCloser, error) {
+	var decoded io.ReadCloser
+	switch encoding {
+	case "", Identity:
+		return io.NopCloser(body), nil
+	case Gzip:
+		r, err := gzip.NewReader(body)
+		if err != nil {
+			return nil, fmt.Errorf("invalid gzip body: %w", err)
+		}
+		decoded = r
+	case Deflate:
+		r, err := zlib.NewReader(body)
+		if err != nil {
+			return nil, fmt.Errorf("invalid deflate body: %w", err)
+		}
+		decoded = r
+	case Snappy:
+		// The block format is decoded in one piece; its header states the decoded size
+		data, err := decodeSnappy(body, limit)
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(bytes.NewReader(data)), nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupported, encoding)
+	}
+	if limit <= 0 {
+		return decoded, nil
+	}
+	return &limitedReader{r: decoded, remaining: limit}, nil
+}
+
+// limitedReader fails with ErrTooLarge instead of silently truncating like io.LimitReader
+type limitedReader struct {
+	r         io.ReadCloser
+	remaining int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.remaining <= 0 {
+		// Only fail if there is more to read
+		var probe [1]byte
+		if n, _ := l.r.Read(probe[:]); n > 0 {
+			return 0, ErrTooLarge
+		}
+		return 0, io.EOF
+	}
+	if int64(len(p)) > l.remaining {
+		p = p[:l.remaining]
+	}
+	n, err := l.r.Read(p)
+	l.remaining -= int64(n)
+	return n, err
+}
+
+func (l *limitedReader) Close() error {
+	return l.r.Close()
+}
+
+// DecodeRequest replaces the body of a request sent with a Content-Encoding by its
+// decoded form, limited to limit bytes. Reads past the limit fail with ErrTooLarge.
+func DecodeRequest(r *http.Request, limit int64) error {
+	encoding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding")))
+	if encoding == "" || encoding == Identity {
+		return nil
+	}
+	body, err := NewReader(encoding, r.Body, limit)
+	if err != nil {
+		return err
+	}
+	r.Body = body
+	r.Header.Del("Content-Encoding")
+	r.ContentLength = -1
+	return nil
+}
+
+// Advertise lists the supported encodings in the Accept-Encoding header of a response,
+// telling the client which encodings it may use for request bodies (RFC 7694)
+func Advertise(w http.ResponseWriter) {
+	w.Header().Set("Accept-Encoding", strings.Join(Supported, ", "))
+}
+
+// WriteError answers a request whose body could not be decoded: 415 for an unknown
+// encoding, 413 for a body past the limit and 400 otherwise
+func WriteError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrUnsupported):
+		Advertise(w)
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+	case errors.Is(err, ErrTooLarge):
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+	default:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+}
+
+// Peer picks the encoding for request bodies sent to one server. Requests start out
+// unencoded; once a response advertises the preferred encoding in Accept-Encoding
+// (RFC 7694) bodies are encoded with it, and a 415 response turns encoding off again
+// until the server advertises it anew.
+type Peer struct {
+	preferred string
+
+	mu       sync.Mutex
+	encoding string
+}
+
+// NewPeer creates the encoding state for one server. An empty or identity preference
+// never encodes.
+func NewPeer(preferred string) *Peer {
+	if preferred == "" {
+		preferred = Identity
+	}
+	return &Peer{preferred: preferred, encoding: Identity}
+}
+
+// Preferred returns the encoding the peer was created to use
+func (p *Peer) Preferred() string {
+	return p.preferred
+}
+
+// Encoding returns the encoding the next request body should use
+func (p *Peer) Encoding() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.encoding
+}
+
+// Encode encodes a request body with the peer's current encoding, returning the body
+// and the Content-Encoding to send, empty when the body is sent as it is. Bodies under
+// MinSize are not worth encoding.
+func (p *Peer) Encode(data []byte) ([]byte, string, error) {
+	encoding := p.Encoding()
+	if encoding == Identity || len(data) < MinSize {
+		return data, "", nil
+	}
+	encoded, err := Encode(encoding, data)
+	if err != nil {
+		return nil, "", err
+	}
+	return encoded, encoding, nil
+}
+
+// Update learns from a response to a request sent with the given Content-Encoding. It
+// reports whether the request was refused for its encoding and should be sent again
+// with the new one.
+func (p *Peer) Update(resp *http.Response, sent string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if resp.StatusCode == http.StatusUnsupportedMediaType && sent != "" {
+		p.encoding = Identity
+		return true
+	}
+	if p.preferred == Identity {
+		return false
+	}
+	for _, accepted := range strings.Split(resp.Header.Get("Accept-Encoding"), ",") {
+		if name, _, _ := strings.Cut(accepted, ";"); strings.TrimSpace(name) == p.preferred {
+			p.encoding = p.preferred
+		}
+	}
+	return false
+}
@@ -0,0 +1,199 @@
+package compression
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// The Snappy block format is the decoded length as a varint followed by elements, each
+// a literal run or a copy of earlier output. See
+// https://github.com/google/snappy/blob/main/format_description.txt
+
+// Element tags, in the two low bits of an element's first byte
+const (
+	tagLiteral = 0x00
+	tagCopy1   = 0x01 // 1-byte offset
+	tagCopy2   = 0x02 // 2-byte offset
+	tagCopy4   = 0x03 // 4-byte offset
+)
+
+// snappyBlockSize bounds the input matched in one go, so every copy fits a 2-byte offset
+const snappyBlockSize = 1 << 16
+
+// snappyTableBits sizes the hash table of recent positions
+const snappyTableBits = 14
+
+var errCorruptSnappy = errors.New("invalid snappy body")
+
+// encodeSnappy compresses src into the Snappy block format
+func encodeSnappy(src []byte) []byte {
+	dst := binary.AppendUvarint(make([]byte, 0, len(src)/2+16), uint64(len(src)))
+	var table [1 << snappyTableBits]int32
+	for len(src) > 0 {
+		block := src
+		if len(block) > snappyBlockSize {
+			block = block[:snappyBlockSize]
+		}
+		dst = encodeSnappyBlock(dst, block, &table)
+		src = src[len(block):]
+	}
+	return dst
+}
+
+// encodeSnappyBlock appends the elements for one block. Matches are found through a
+// hash table of 4-byte sequences; the step grows while no match is found so that
+// incompressible input passes quickly.
+func encodeSnappyBlock(dst, block []byte, table *[1 << snappyTableBits]int32) []byte {
+	for i := range table {
+		table[i] = -1
+	}
+
+	literal, s := 0, 0
+	for s+4 <= len(block) {
+		word := binary.LittleEndian.Uint32(block[s:])
+		h := (word * 0x1e35a7bd) >> (32 - snappyTableBits)
+		candidate := int(table[h])
+		table[h] = int32(s)
+
+		if candidate < 0 || binary.LittleEndian.Uint32(block[candidate:]) != word {
+			s += 1 + (s-literal)>>5
+			continue
+		}
+
+		dst = appendSnappyLiteral(dst, block[literal:s])
+		start := s
+		s += 4
+		for c := candidate + 4; s < len(block) && block[s] == block[c]; c++ {
+			s++
+		}
+		dst = appendSnappyCopy(dst, start-candidate, s-start)
+		literal = s
+	}
+	return appendSnappyLiteral(dst, block[literal:])
+}
+
+// appendSnappyLiteral appends a literal run, which is never longer than a block
+func appendSnappyLiteral(dst, literal []byte) []byte {
+	n := len(literal) - 1
+	switch {
+	case n < 0:
+		return dst
+	case n < 60:
+		dst = append(dst, byte(n)<<2|tagLiteral)
+	case n < 1<<8:
+		dst = append(dst, 60<<2|tagLiteral, byte(n))
+	default:
+		dst = append(dst, 61<<2|tagLiteral, byte(n), byte(n>>8))
+	}
+	return append(dst, literal...)
+}
+
+// appendSnappyCopy appends copies of up to 64 bytes with a 2-byte offset
+func appendSnappyCopy(dst []byte, offset, length int) []byte {
+	for length > 0 {
+		n := length
+		if n > 64 {
+			n = 64
+		}
+		dst = append(dst, byte(n-1)<<2|tagCopy2, byte(offset), byte(offset>>8))
+		length -= n
+	}
+	return dst
+}
+
+// decodeSnappy reads and decompresses a Snappy block-format body. The decoded length is
+// checked against limit before any of it is allocated.
+func decodeSnappy(body io.Reader, limit int64) ([]byte, error) {
+	// Snappy output is at most a little larger than its input
+	var src []byte
+	var err error
+	if limit > 0 {
+		maxEncoded := limit + limit/6 + 32
+		src, err = io.ReadAll(io.LimitReader(body, maxEncoded+1))
+		if int64(len(src)) > maxEncoded {
+			return nil, ErrTooLarge
+		}
+	} else {
+		src, err = io.ReadAll(body)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	length, n := binary.Uvarint(src)
+	if n <= 0 {
+		return nil, errCorruptSnappy
+	}
+	if limit > 0 && length > uint64(limit) {
+		return nil, ErrTooLarge
+	}
+	if length > uint64(len(src))*64 {
+		// More than any valid encoding of src could produce
+		return nil, errCorruptSnappy
+	}
+	src = src[n:]
+
+	dst := make([]byte, 0, length)
+	for len(src) > 0 {
+		var offset, size int
+		switch src[0] & 3 {
+		case tagLiteral:
+			size = int(src[0] >> 2)
+			src = src[1:]
+			if size >= 60 {
+				bytes := size - 59
+				if len(src) < bytes {
+					return nil, errCorruptSnappy
+				}
+				size = 0
+				for i := bytes - 1; i >= 0; i-- {
+					size = size<<8 | int(src[i])
+				}
+				src = src[bytes:]
+			}
+			size++
+			if size > len(src) || uint64(len(dst)+size) > length {
+				return nil, errCorruptSnappy
+			}
+			dst = append(dst, src[:size]...)
+			src = src[size:]
+			continue
+		case tagCopy1:
+			if len(src) < 2 {
+				return nil, errCorruptSnappy
+			}
+			size = 4 + int(src[0]>>2)&7
+			offset = int(src[0]&0xe0)<<3 | int(src[1])
+			src = src[2:]
+		case tagCopy2:
+			if len(src) < 3 {
+				return nil, errCorruptSnappy
+			}
+			size = 1 + int(src[0]>>2)
+			offset = int(binary.LittleEndian.Uint16(src[1:]))
+			src = src[3:]
+		case tagCopy4:
+			if len(src) < 5 {
+				return nil, errCorruptSnappy
+			}
+			size = 1 + int(src[0]>>2)
+			offset = int(binary.LittleEndian.Uint32(src[1:]))
+			src = src[5:]
+		}
+
+		if offset <= 0 || offset > len(dst) || uint64(len(dst)+size) > length {
+			return nil, fmt.Errorf("%w: copy out of range", errCorruptSnappy)
+		}
+		// Copies may overlap their own output, so go byte by byte
+		for i := 0; i < size; i++ {
+			dst = append(dst, dst[len(dst)-offset])
+		}
+	}
+
+	if uint64(len(dst)) != length {
+		return nil, errCorruptSnappy
+	}
+	return dst, nil
+}
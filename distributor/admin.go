@@ -0,0 +1,219 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"resolve/models"
+)
+
+// addAnalyzerRequest is the body of POST /admin/analyzers
+type addAnalyzerRequest struct {
+	models.AnalyzerConfig
+	Groups []string `json:"groups,omitempty"` // named routing groups to join
+}
+
+// errNotFound marks admin changes that refer to an unknown analyzer
+type errNotFound struct{ id string }
+
+func (e errNotFound) Error() string { return fmt.Sprintf("analyzer %s not found", e.id) }
+
+// handleAnalyzers lists analyzers with their health (GET) or adds one (POST)
+func (d *DistributorServer) handleAnalyzers(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		d.handleAnalyzerStatus(w, r)
+	case "POST":
+		var req addAnalyzerRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		if req.ID == "" || req.Endpoint == "" {
+			http.Error(w, "Analyzer id and endpoint are required", http.StatusBadRequest)
+			return
+		}
+		if req.Weight <= 0 {
+			http.Error(w, fmt.Sprintf("Invalid weight: %.2f", req.Weight), http.StatusBadRequest)
+			return
+		}
+
+		err := d.updateConfig(func(config *models.DistributorConfig) error {
+			if findAnalyzer(config, req.ID) >= 0 {
+				return fmt.Errorf("analyzer %s already exists", req.ID)
+			}
+			config.Analyzers = append(config.Analyzers, req.AnalyzerConfig)
+			for _, name := range req.Groups {
+				if !addGroupMember(config, name, req.ID) {
+					return fmt.Errorf("unknown analyzer group %s", name)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		log.Printf("[ADMIN] Added analyzer %s (%s, weight %.2f)", req.ID, req.Endpoint, req.Weight)
+		d.writeAdminResponse(w, http.StatusCreated, "added", req.ID)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleAnalyzer changes a single analyzer at runtime:
+//
+//	PUT    /admin/analyzers/{id}         re-weight, body {"weight": 2.0}
+//	DELETE /admin/analyzers/{id}         remove the analyzer and reroute its traffic
+//	POST   /admin/analyzers/{id}/drain   stop sending new traffic to the analyzer
+//	POST   /admin/analyzers/{id}/resume  send traffic to a drained analyzer again
+func (d *DistributorServer) handleAnalyzer(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/analyzers/"), "/")
+	parts := strings.Split(path, "/")
+	id := parts[0]
+
+	var status string
+	var err error
+	switch {
+	case len(parts) == 1 && r.Method == "PUT":
+		var body struct {
+			Weight float64 `json:"weight"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		if body.Weight <= 0 {
+			http.Error(w, fmt.Sprintf("Invalid weight: %.2f", body.Weight), http.StatusBadRequest)
+			return
+		}
+		status = "reweighted"
+		err = d.changeAnalyzer(id, func(analyzer *models.AnalyzerConfig) {
+			analyzer.Weight = body.Weight
+		})
+	case len(parts) == 1 && r.Method == "DELETE":
+		status = "removed"
+		err = d.updateConfig(func(config *models.DistributorConfig) error {
+			i := findAnalyzer(config, id)
+			if i < 0 {
+				return errNotFound{id}
+			}
+			config.Analyzers = append(config.Analyzers[:i], config.Analyzers[i+1:]...)
+			return removeGroupMember(config, id)
+		})
+		if _, ok := err.(errNotFound); ok && d.members.remove(id) {
+			d.refreshMembers()
+			err = nil
+		}
+	case len(parts) == 2 && parts[1] == "drain" && r.Method == "POST":
+		status = "draining"
+		err = d.changeAnalyzer(id, func(analyzer *models.AnalyzerConfig) {
+			analyzer.Draining = true
+		})
+	case len(parts) == 2 && parts[1] == "resume" && r.Method == "POST":
+		status = "resumed"
+		err = d.changeAnalyzer(id, func(analyzer *models.AnalyzerConfig) {
+			analyzer.Draining = false
+		})
+	case len(parts) == 1, len(parts) == 2 && (parts[1] == "drain" || parts[1] == "resume"):
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	if _, ok := err.(errNotFound); ok {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	log.Printf("[ADMIN] Analyzer %s %s", id, status)
+	d.writeAdminResponse(w, http.StatusOK, status, id)
+}
+
+// handleReload re-reads the configuration file
+func (d *DistributorServer) handleReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := d.reloadConfig(); err != nil {
+		http.Error(w, fmt.Sprintf("Reload failed: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	response := map[string]interface{}{
+		"status":    "reloaded",
+		"analyzers": len(d.current().config.Analyzers),
+		"timestamp": time.Now().Format(time.RFC3339),
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// changeAnalyzer applies a change to one analyzer's configuration, whether it is
+// statically configured or a registered member
+func (d *DistributorServer) changeAnalyzer(id string, change func(*models.AnalyzerConfig)) error {
+	err := d.updateConfig(func(config *models.DistributorConfig) error {
+		i := findAnalyzer(config, id)
+		if i < 0 {
+			return errNotFound{id}
+		}
+		change(&config.Analyzers[i])
+		return nil
+	})
+	if _, ok := err.(errNotFound); ok && d.members.change(id, change) {
+		d.refreshMembers()
+		return nil
+	}
+	return err
+}
+
+func (d *DistributorServer) writeAdminResponse(w http.ResponseWriter, statusCode int, status, id string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	response := map[string]interface{}{
+		"status":    status,
+		"analyzer":  id,
+		"timestamp": time.Now().Format(time.RFC3339),
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// addGroupMember adds an analyzer to a named routing group
+func addGroupMember(config *models.DistributorConfig, group, id string) bool {
+	for i := range config.Routing.Groups {
+		if config.Routing.Groups[i].Name == group {
+			config.Routing.Groups[i].Members = append(config.Routing.Groups[i].Members, models.GroupMember{ID: id})
+			return true
+		}
+	}
+	return false
+}
+
+// removeGroupMember takes an analyzer out of every routing group. A group left without
+// members would strand its messages, so that is refused.
+func removeGroupMember(config *models.DistributorConfig, id string) error {
+	for i := range config.Routing.Groups {
+		group := &config.Routing.Groups[i]
+		members := group.Members[:0]
+		for _, member := range group.Members {
+			if member.ID != id {
+				members = append(members, member)
+			}
+		}
+		if len(members) == 0 {
+			return fmt.Errorf("analyzer %s is the last member of group %s", id, group.Name)
+		}
+		group.Members = members
+	}
+	return nil
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"resolve/models"
+)
+
+// batchOutcome is the delivery result for one message of a batch
+type batchOutcome struct {
+	statusCode int
+	duration   time.Duration
+	err        error
+}
+
+// batchItem is a message waiting in a batch accumulator for its delivery outcome
+type batchItem struct {
+	message models.LogMessage
+	result  chan batchOutcome
+}
+
+// analyzerBatcher accumulates messages for one analyzer and flushes them to its
+// /analyze/batch endpoint once the batch is full or the linger time has passed
+type analyzerBatcher struct {
+	d        *DistributorServer
+	analyzer models.AnalyzerConfig
+	class    trafficClass
+	maxSize  int
+	linger   time.Duration
+	items    chan batchItem
+	done     chan struct{}
+}
+
+// batcherFor returns the batch accumulator for an analyzer and traffic class, starting
+// it on first use. Live and retry messages are batched separately so each batch is
+// sent on a worker slot of its own class.
+func (d *DistributorServer) batcherFor(analyzer models.AnalyzerConfig, class trafficClass) *analyzerBatcher {
+	d.batchersMu.Lock()
+	defer d.batchersMu.Unlock()
+
+	key := analyzer.ID + "/" + class.String()
+	if b, ok := d.batchers[key]; ok {
+		return b
+	}
+
+	batching := d.current().config.Batching
+	b := &analyzerBatcher{
+		d:        d,
+		analyzer: analyzer,
+		class:    class,
+		maxSize:  batching.MaxSize,
+		linger:   time.Duration(batching.Linger) * time.Millisecond,
+		items:    make(chan batchItem),
+		done:     make(chan struct{}),
+	}
+	d.batchers[key] = b
+	go b.run()
+	return b
+}
+
+// submit adds a message to the next batch and waits for its outcome
+func (b *analyzerBatcher) submit(logMessage models.LogMessage) (int, time.Duration, error) {
+	item := batchItem{
+		message: logMessage,
+		result:  make(chan batchOutcome, 1),
+	}
+	select {
+	case b.items <- item:
+	case <-b.done:
+		// The batcher was retired while this message waited, send it on its own
+		return b.d.postMessage(b.analyzer, logMessage, b.class)
+	}
+	outcome := <-item.result
+	return outcome.statusCode, outcome.duration, outcome.err
+}
+
+// run collects submitted messages into batches bounded by size and linger time
+func (b *analyzerBatcher) run() {
+	var batch []batchItem
+	var timer *time.Timer
+	var lingerC <-chan time.Time
+
+	for {
+		select {
+		case item := <-b.items:
+			batch = append(batch, item)
+			if len(batch) == 1 {
+				timer = time.NewTimer(b.linger)
+				lingerC = timer.C
+			}
+			if len(batch) >= b.maxSize {
+				timer.Stop()
+				lingerC = nil
+				go b.flush(batch)
+				batch = nil
+			}
+		case <-lingerC:
+			lingerC = nil
+			go b.flush(batch)
+			batch = nil
+		case <-b.done:
+			if timer != nil {
+				timer.Stop()
+			}
+			if len(batch) > 0 {
+				go b.flush(batch)
+			}
+			return
+		}
+	}
+}
+
+// retireBatchers stops the batch accumulators of an analyzer after it was changed or
+// removed. Messages already collected are still sent; later ones start a new batcher.
+func (d *DistributorServer) retireBatchers(analyzerID string) {
+	d.batchersMu.Lock()
+	defer d.batchersMu.Unlock()
+
+	for _, class := range []trafficClass{classLive, classRetry} {
+		key := analyzerID + "/" + class.String()
+		if b, ok := d.batchers[key]; ok {
+			close(b.done)
+			delete(d.batchers, key)
+		}
+	}
+}
+
+// flush delivers a batch and hands every message its own result
+func (b *analyzerBatcher) flush(batch []batchItem) {
+	messages := make([]models.LogMessage, len(batch))
+	for i, item := range batch {
+		messages[i] = item.message
+	}
+
+	statusCode, body, duration, err := b.postBatch(messages)
+	if err != nil {
+		log.Printf("Network error sending batch of %d log messages to analyzer %s: %v",
+			len(batch), b.analyzer.ID, err)
+		for _, item := range batch {
+			item.result <- batchOutcome{duration: duration, err: err}
+		}
+		return
+	}
+
+	// The analyzer does not serve the batch endpoint after all, fall back to single messages
+	if statusCode == http.StatusNotFound || statusCode == http.StatusMethodNotAllowed {
+		log.Printf("Analyzer %s does not accept batches (status %d), falling back to single-message delivery",
+			b.analyzer.ID, statusCode)
+		b.d.health.disableBatch(b.analyzer.ID)
+		for _, item := range batch {
+			go func(item batchItem) {
+				statusCode, duration, err := b.d.postMessage(b.analyzer, item.message, b.class)
+				item.result <- batchOutcome{statusCode: statusCode, duration: duration, err: err}
+			}(item)
+		}
+		return
+	}
+
+	if statusCode != http.StatusOK {
+		for _, item := range batch {
+			item.result <- batchOutcome{statusCode: statusCode, duration: duration}
+		}
+		return
+	}
+
+	var response models.AnalyzeBatchResponse
+	if err := json.Unmarshal(body, &response); err != nil || len(response.Results) != len(batch) {
+		if err == nil {
+			err = fmt.Errorf("analyzer returned %d results for %d messages", len(response.Results), len(batch))
+		}
+		log.Printf("Invalid batch response from analyzer %s: %v", b.analyzer.ID, err)
+		for _, item := range batch {
+			item.result <- batchOutcome{statusCode: http.StatusBadGateway, duration: duration}
+		}
+		return
+	}
+
+	log.Printf("Delivered batch of %d log messages to analyzer %s in %v", len(batch), b.analyzer.ID, duration)
+	for i, item := range batch {
+		outcome := batchOutcome{statusCode: http.StatusOK, duration: duration}
+		if !response.Results[i].Success {
+			outcome.statusCode = http.StatusInternalServerError
+		}
+		item.result <- outcome
+	}
+}
+
+// postBatch sends messages to the analyzer's batch endpoint and returns the raw response
+func (b *analyzerBatcher) postBatch(messages []models.LogMessage) (int, []byte, time.Duration, error) {
+	jsonData, err := json.Marshal(models.AnalyzeBatchRequest{Messages: messages})
+	if err != nil {
+		return 0, nil, 0, fmt.Errorf("failed to marshal batch: %w", err)
+	}
+
+	// Acquire worker slot (backpressure mechanism)
+	b.d.workerPool.acquire(b.class)
+	defer b.d.workerPool.release(b.class)
+
+	ctx, cancel := context.WithTimeout(b.d.ctx, analyzerTimeout(b.analyzer))
+	defer cancel()
+
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	header.Set("User-Agent", "log-distributor/1.0")
+	header.Set("X-Analyzer-ID", b.analyzer.ID)
+
+	start := time.Now()
+	resp, err := b.d.postToAnalyzer(ctx, b.analyzer.ID, batchURL(b.analyzer), jsonData, header)
+	duration := time.Since(start)
+	if err != nil {
+		return 0, nil, duration, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, duration, fmt.Errorf("failed to read batch response: %w", err)
+	}
+	return resp.StatusCode, body, duration, nil
+}
+
+// batchURL returns the batch endpoint for an analyzer
+func batchURL(analyzer models.AnalyzerConfig) string {
+	if analyzer.BatchEndpoint != "" {
+		return analyzer.BatchEndpoint
+	}
+	return analyzer.Endpoint + "/batch"
+}
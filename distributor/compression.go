@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+
+	"resolve/compression"
+)
+
+// peerFor returns the request body encoding state of an analyzer, starting over when the
+// configured encoding changed
+func (d *DistributorServer) peerFor(analyzerID string) *compression.Peer {
+	preferred := d.current().config.Compression.Encoding
+
+	d.peersMu.Lock()
+	defer d.peersMu.Unlock()
+	peer, ok := d.peers[analyzerID]
+	if !ok || peer.Preferred() != preferred {
+		peer = compression.NewPeer(preferred)
+		d.peers[analyzerID] = peer
+	}
+	return peer
+}
+
+// postToAnalyzer posts a JSON body to an analyzer, encoded as negotiated with it. A body
+// the analyzer refuses for its encoding is sent once more without it.
+func (d *DistributorServer) postToAnalyzer(ctx context.Context, analyzerID, url string, jsonData []byte, header http.Header) (*http.Response, error) {
+	peer := d.peerFor(analyzerID)
+	for attempt := 1; ; attempt++ {
+		body, encoding, err := peer.Encode(jsonData)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode request: %w", err)
+		}
+
+		req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request: %w", err)
+		}
+		for key, values := range header {
+			req.Header[key] = values
+		}
+		if encoding != "" {
+			req.Header.Set("Content-Encoding", encoding)
+		}
+
+		resp, err := d.client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if !peer.Update(resp, encoding) || attempt > 1 {
+			return resp, nil
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+}
+
+// decodeRequestBody decodes a compressed request body, answering the request itself and
+// returning false when it cannot
+func (d *DistributorServer) decodeRequestBody(w http.ResponseWriter, r *http.Request, limit int64) bool {
+	compression.Advertise(w)
+	if err := compression.DecodeRequest(r, limit); err != nil {
+		compression.WriteError(w, err)
+		return false
+	}
+	return true
+}
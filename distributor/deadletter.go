@@ -0,0 +1,342 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"resolve/models"
+)
+
+// Reasons a queued message is moved to the dead-letter store
+const (
+	deadLetterMaxAttempts = "max_attempts"
+	deadLetterMaxAge      = "max_age"
+)
+
+// DeadLetter is a message that exhausted its delivery attempts or outlived its maximum age
+type DeadLetter struct {
+	ID             string            `json:"id"`
+	LogMessage     models.LogMessage `json:"log_message"`
+	Reason         string            `json:"reason"` // max_attempts or max_age
+	LastError      string            `json:"last_error"`
+	Attempts       int               `json:"attempts"`
+	TriedAnalyzers []string          `json:"tried_analyzers"`
+	QueuedAt       time.Time         `json:"queued_at"`
+	DeadAt         time.Time         `json:"dead_at"`
+	seq            uint64
+}
+
+// deadLetterQueue holds dead letters in memory, backed by a durable log
+type deadLetterQueue struct {
+	store   *durableLog
+	mu      sync.Mutex
+	letters map[string]*DeadLetter
+}
+
+// openDeadLetterQueue opens the dead-letter log and reloads every stored letter
+func openDeadLetterQueue(cfg models.WALConfig) (*deadLetterQueue, error) {
+	store, entries, err := openDurableLog("DEADLETTER", cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	dlq := &deadLetterQueue{
+		store:   store,
+		letters: make(map[string]*DeadLetter),
+	}
+	for _, entry := range entries {
+		var letter DeadLetter
+		if err := json.Unmarshal(entry.Data, &letter); err != nil {
+			log.Printf("[DEADLETTER] Skipping unreadable dead letter %d: %v", entry.Seq, err)
+			continue
+		}
+		letter.seq = entry.Seq
+		letter.ID = deadLetterID(entry.Seq)
+		dlq.letters[letter.ID] = &letter
+	}
+	return dlq, nil
+}
+
+// add stores a dead letter and assigns its ID
+func (q *deadLetterQueue) add(letter DeadLetter) (string, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	seq, err := q.store.add(letter)
+	if err != nil {
+		return "", err
+	}
+	letter.seq = seq
+	letter.ID = deadLetterID(seq)
+	q.letters[letter.ID] = &letter
+	return letter.ID, nil
+}
+
+// get returns a copy of a dead letter
+func (q *deadLetterQueue) get(id string) (DeadLetter, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	letter, ok := q.letters[id]
+	if !ok {
+		return DeadLetter{}, false
+	}
+	return *letter, true
+}
+
+// list returns every dead letter, oldest first
+func (q *deadLetterQueue) list() []DeadLetter {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	letters := make([]DeadLetter, 0, len(q.letters))
+	for _, letter := range q.letters {
+		letters = append(letters, *letter)
+	}
+	sort.Slice(letters, func(i, j int) bool {
+		return letters[i].seq < letters[j].seq
+	})
+	return letters
+}
+
+// remove deletes a dead letter and returns it
+func (q *deadLetterQueue) remove(id string) (DeadLetter, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	letter, ok := q.letters[id]
+	if !ok {
+		return DeadLetter{}, false
+	}
+	if err := q.store.ack(letter.seq); err != nil {
+		log.Printf("[DEADLETTER] Failed to record removal of %s: %v", id, err)
+	}
+	delete(q.letters, id)
+	return *letter, true
+}
+
+// removeAll deletes every dead letter and returns them, oldest first
+func (q *deadLetterQueue) removeAll() []DeadLetter {
+	letters := q.list()
+	removed := make([]DeadLetter, 0, len(letters))
+	for _, letter := range letters {
+		if l, ok := q.remove(letter.ID); ok {
+			removed = append(removed, l)
+		}
+	}
+	return removed
+}
+
+// count returns the number of dead letters
+func (q *deadLetterQueue) count() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.letters)
+}
+
+func deadLetterID(seq uint64) string {
+	return "dl-" + strconv.FormatUint(seq, 10)
+}
+
+// deadLetterReason returns why a queued message should be dead-lettered, or "" to keep retrying it
+func (d *DistributorServer) deadLetterReason(qm QueuedMessage) string {
+	limits := d.current().config.DeadLetter
+	if limits.MaxAttempts > 0 && qm.Attempts >= limits.MaxAttempts {
+		return deadLetterMaxAttempts
+	}
+	if limits.MaxAge > 0 && time.Since(qm.QueuedAt) >= time.Duration(limits.MaxAge)*time.Millisecond {
+		return deadLetterMaxAge
+	}
+	return ""
+}
+
+// moveToDeadLetters stores a message taken off the retry queue as a dead letter. The
+// message only leaves the queue write-ahead log once the dead letter is persisted.
+func (d *DistributorServer) moveToDeadLetters(qm QueuedMessage, reason string) error {
+	tried := make([]string, 0, len(qm.TriedAnalyzers))
+	for id := range qm.TriedAnalyzers {
+		if id != "" {
+			tried = append(tried, id)
+		}
+	}
+	sort.Strings(tried)
+
+	id, err := d.deadLetters.add(DeadLetter{
+		LogMessage:     qm.LogMessage,
+		Reason:         reason,
+		LastError:      qm.LastError,
+		Attempts:       qm.Attempts,
+		TriedAnalyzers: tried,
+		QueuedAt:       qm.QueuedAt,
+		DeadAt:         time.Now(),
+	})
+	if err != nil {
+		log.Printf("[DEADLETTER] Failed to persist dead letter for log message %s, keeping it queued: %v", qm.LogMessage.ID, err)
+		return err
+	}
+	if err := d.store.ack(qm.Seq); err != nil {
+		log.Printf("[QUEUE] Failed to record removal of log message %s: %v", qm.LogMessage.ID, err)
+	}
+
+	d.metrics.deadLettered.With(reason).Inc()
+	log.Printf("[DEADLETTER] Log message %s dead-lettered as %s (%s after %d attempts). Last error: %s",
+		qm.LogMessage.ID, id, reason, qm.Attempts, qm.LastError)
+	return nil
+}
+
+// redrive puts dead letters back on the retry queue for immediate delivery. Each letter
+// is written to the queue write-ahead log before it is removed from the dead-letter
+// store, so a crash in between cannot lose it. It returns how many letters were queued.
+func (d *DistributorServer) redrive(letters []DeadLetter) (int, error) {
+	redriven := 0
+	for _, letter := range letters {
+		now := time.Now()
+		qm := &QueuedMessage{
+			LogMessage:     letter.LogMessage,
+			TriedAnalyzers: make(map[string]bool),
+			NextAttempt:    now,
+			QueuedAt:       now,
+		}
+		seq, err := d.store.add(qm)
+		if err != nil {
+			log.Printf("[DEADLETTER] Failed to queue %s (log message %s), keeping it: %v", letter.ID, letter.LogMessage.ID, err)
+			return redriven, fmt.Errorf("failed to queue %s: %w", letter.ID, err)
+		}
+		if _, ok := d.deadLetters.remove(letter.ID); !ok {
+			// Purged or re-driven by another request in the meantime
+			if err := d.store.ack(seq); err != nil {
+				log.Printf("[QUEUE] Failed to record removal of log message %s: %v", letter.LogMessage.ID, err)
+			}
+			continue
+		}
+
+		qm.Seq = seq
+		d.queueMu.Lock()
+		d.schedule(qm)
+		d.queueMu.Unlock()
+		d.metrics.queued.With().Inc()
+		redriven++
+		log.Printf("[DEADLETTER] Re-driving %s (log message %s)", letter.ID, letter.LogMessage.ID)
+	}
+	return redriven, nil
+}
+
+// handleDeadLetters lists (GET) or purges (DELETE) all dead letters
+func (d *DistributorServer) handleDeadLetters(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case "GET":
+		letters := d.deadLetters.list()
+		if limit, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && limit >= 0 && limit < len(letters) {
+			letters = letters[:limit]
+		}
+		response := map[string]interface{}{
+			"count":        d.deadLetters.count(),
+			"dead_letters": letters,
+			"timestamp":    time.Now().Format(time.RFC3339),
+		}
+		json.NewEncoder(w).Encode(response)
+	case "DELETE":
+		purged := d.deadLetters.removeAll()
+		log.Printf("[DEADLETTER] Purged %d dead letters", len(purged))
+		response := map[string]interface{}{
+			"status":    "purged",
+			"purged":    len(purged),
+			"timestamp": time.Now().Format(time.RFC3339),
+		}
+		json.NewEncoder(w).Encode(response)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleDeadLetter serves a single dead letter and the re-drive actions:
+//
+//	GET    /deadletters/{id}          inspect a dead letter
+//	DELETE /deadletters/{id}          purge a dead letter
+//	POST   /deadletters/{id}/redrive  re-drive a dead letter
+//	POST   /deadletters/redrive       re-drive every dead letter
+func (d *DistributorServer) handleDeadLetter(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/deadletters/"), "/")
+	parts := strings.Split(path, "/")
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if path == "redrive" {
+		if r.Method != "POST" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		redriven, err := d.redrive(d.deadLetters.list())
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Re-drove %d dead letters, then: %v", redriven, err), http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+		response := map[string]interface{}{
+			"status":    "redriving",
+			"redriven":  redriven,
+			"timestamp": time.Now().Format(time.RFC3339),
+		}
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	id := parts[0]
+	switch {
+	case len(parts) == 1 && r.Method == "GET":
+		letter, ok := d.deadLetters.get(id)
+		if !ok {
+			http.Error(w, fmt.Sprintf("Dead letter %s not found", id), http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(letter)
+	case len(parts) == 1 && r.Method == "DELETE":
+		if _, ok := d.deadLetters.remove(id); !ok {
+			http.Error(w, fmt.Sprintf("Dead letter %s not found", id), http.StatusNotFound)
+			return
+		}
+		log.Printf("[DEADLETTER] Purged %s", id)
+		response := map[string]interface{}{
+			"status":    "purged",
+			"id":        id,
+			"timestamp": time.Now().Format(time.RFC3339),
+		}
+		json.NewEncoder(w).Encode(response)
+	case len(parts) == 2 && parts[1] == "redrive" && r.Method == "POST":
+		letter, ok := d.deadLetters.get(id)
+		if !ok {
+			http.Error(w, fmt.Sprintf("Dead letter %s not found", id), http.StatusNotFound)
+			return
+		}
+		redriven, err := d.redrive([]DeadLetter{letter})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		if redriven == 0 {
+			http.Error(w, fmt.Sprintf("Dead letter %s not found", id), http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+		response := map[string]interface{}{
+			"status":    "redriving",
+			"id":        id,
+			"log_id":    letter.LogMessage.ID,
+			"timestamp": time.Now().Format(time.RFC3339),
+		}
+		json.NewEncoder(w).Encode(response)
+	case len(parts) == 1, len(parts) == 2 && parts[1] == "redrive":
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	default:
+		http.NotFound(w, r)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"resolve/models"
+)
+
+// deliver sends a log message to an analyzer, batching it when the analyzer supports it.
+// It returns the HTTP status code that applies to this message.
+func (d *DistributorServer) deliver(analyzer models.AnalyzerConfig, logMessage models.LogMessage, class trafficClass) (int, time.Duration, error) {
+	var statusCode int
+	var duration time.Duration
+	var err error
+	if d.current().config.Batching.Enabled && d.health.supportsBatch(analyzer.ID) {
+		statusCode, duration, err = d.batcherFor(analyzer, class).submit(logMessage)
+	} else {
+		statusCode, duration, err = d.postMessage(analyzer, logMessage, class)
+	}
+	d.metrics.observeDelivery(analyzer.ID, class, statusCode, duration, err)
+	return statusCode, duration, err
+}
+
+// postMessage sends a single log message to an analyzer's /analyze endpoint
+func (d *DistributorServer) postMessage(analyzer models.AnalyzerConfig, logMessage models.LogMessage, class trafficClass) (int, time.Duration, error) {
+	jsonData, err := json.Marshal(logMessage)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to marshal log message: %w", err)
+	}
+
+	// Acquire worker slot (backpressure mechanism)
+	d.workerPool.acquire(class)
+	defer d.workerPool.release(class)
+
+	ctx, cancel := context.WithTimeout(d.ctx, analyzerTimeout(analyzer))
+	defer cancel()
+
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	header.Set("User-Agent", "log-distributor/1.0")
+	header.Set("X-Log-ID", logMessage.ID)
+	header.Set("X-Analyzer-ID", analyzer.ID)
+
+	start := time.Now()
+	resp, err := d.postToAnalyzer(ctx, analyzer.ID, analyzer.Endpoint, jsonData, header)
+	duration := time.Since(start)
+	if err != nil {
+		return 0, duration, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	return resp.StatusCode, duration, nil
+}
+
+// analyzerTimeout returns the per-request timeout for an analyzer
+func analyzerTimeout(analyzer models.AnalyzerConfig) time.Duration {
+	timeout := time.Duration(analyzer.Timeout) * time.Millisecond
+	if timeout == 0 {
+		timeout = 10 * time.Second
+	}
+	return timeout
+}
@@ -1,87 +1,229 @@
 package main
 
 import (
-	"bytes"
+	"container/heap"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
-	"math/rand"
 	"net/http"
 	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"resolve/compression"
 	"resolve/models"
+	"resolve/parser"
+	"resolve/wire"
 )
 
 // QueuedMessage represents a message that failed to be sent and is queued for retry
-// It tracks which analyzers have already been tried
-// and how many attempts have been made
+// It tracks which analyzers have already been tried,
+// how many attempts have been made and when the next one is due
 type QueuedMessage struct {
 	LogMessage     models.LogMessage
 	TriedAnalyzers map[string]bool
 	Attempts       int
 	LastAttempt    time.Time
+	NextAttempt    time.Time
 	QueuedAt       time.Time
+	LastError      string
+	Seq            uint64 // position in the queue write-ahead log
 }
 
 // DistributorServer handles incoming log packets from emitters
 type DistributorServer struct {
+	// Startup configuration; settings that can change at runtime are read through current()
 	config     models.DistributorConfig
+	configPath string
+	live       atomic.Pointer[liveConfig]
+	configMu   sync.Mutex
+
 	client     *http.Client
-	workerPool chan struct{}
+	server     *http.Server
+	workerPool *workerPool
+
+	// Graceful shutdown: stopping closes when draining starts, ctx is cancelled to abort
+	// deliveries that outlive the shutdown timeout, and stopped closes once it is done
+	ctx          context.Context
+	abort        context.CancelFunc
+	stopping     chan struct{}
+	stopped      chan struct{}
+	shutdownOnce sync.Once
+	work         inFlight
+
+	// Bounds how many queued messages are being redelivered at once
+	retryInFlight chan struct{}
+
+	// Message queue for failed deliveries ordered by next attempt time, backed by a write-ahead log
+	queue     retryHeap
+	queueMu   sync.Mutex
+	queueWake chan struct{}
+	store     *durableLog
+
+	// Messages that exhausted their attempts or outlived their maximum age
+	deadLetters *deadLetterQueue
+
+	// Journal of accepted packets when running in durable ingest mode
+	journal *durableLog
+
+	// Analyzers that registered themselves and keep sending heartbeats
+	members *memberRegistry
+
+	// Probe results and circuit breaker state per analyzer
+	health *healthTracker
 
-	// Message queue for failed deliveries
-	queue   []QueuedMessage
-	queueMu sync.Mutex
+	// Batch accumulators for analyzers that accept batches
+	batchers   map[string]*analyzerBatcher
+	batchersMu sync.Mutex
+
+	// Series served on /metrics
+	metrics *distributorMetrics
+
+	// Syslog listeners, when configured
+	syslog *syslogServer
+
+	// NDJSON streams being read
+	streams atomic.Int64
+
+	// Request body encoding negotiated with each analyzer
+	peers   map[string]*compression.Peer
+	peersMu sync.Mutex
 }
 
 // NewDistributorServer creates a new distributor server
 func NewDistributorServer(config models.DistributorConfig) *DistributorServer {
-	// Create worker pool with reasonable concurrency limit
-	maxWorkers := 10 // Adjust based on your needs
-	if maxWorkers <= 0 {
-		maxWorkers = 10 // Default fallback
+	// Create worker pool, splitting its slots between live traffic and retries
+	workers := newWorkerPool(config.Workers.Size, config.Workers.RetryShare)
+
+	// With batching every retry slot can carry a full batch of queued messages
+	maxRetries := workers.capacity(classRetry)
+	if config.Batching.Enabled && config.Batching.MaxSize > 1 {
+		maxRetries *= config.Batching.MaxSize
+	}
+
+	router, err := newRouter(&config)
+	if err != nil {
+		log.Printf("Invalid routing configuration, routing every message to all analyzers: %v", err)
+		router, _ = newRouter(&models.DistributorConfig{Analyzers: config.Analyzers})
+	}
+
+	rawParser, err := parser.New(config.RawIngest.Parser)
+	if err != nil {
+		log.Printf("Invalid raw ingest parser, keeping plain-text lines as they are: %v", err)
+		rawParser, _ = parser.New(models.ParserConfig{})
+	}
+	rawMultiline, err := parser.NewMultiline(config.RawIngest.Multiline)
+	if err != nil {
+		log.Printf("Invalid raw ingest multiline settings, keeping every plain-text line apart: %v", err)
 	}
 
-	return &DistributorServer{
+	ctx, abort := context.WithCancel(context.Background())
+	d := &DistributorServer{
 		config: config,
+		ctx:    ctx,
+		abort:  abort,
 		client: &http.Client{
 			Timeout: 30 * time.Second, // Default timeout
 		},
-		workerPool: make(chan struct{}, maxWorkers),
-	}
+		workerPool:    workers,
+		retryInFlight: make(chan struct{}, maxRetries),
+		health:        newHealthTracker(config.HealthCheck),
+		members:       newMemberRegistry(),
+		batchers:      make(map[string]*analyzerBatcher),
+		peers:         make(map[string]*compression.Peer),
+		queueWake:     make(chan struct{}, 1),
+		stopping:      make(chan struct{}),
+		stopped:       make(chan struct{}),
+	}
+	d.live.Store(&liveConfig{config: config, static: config, router: router,
+		rawParser: rawParser, rawMultiline: rawMultiline})
+	d.metrics = newDistributorMetrics(d)
+	return d
 }
 
-// // AnalyzerHealth tracks the health status of an analyzer
-// type AnalyzerHealth struct {
-// 	ID              string
-// 	LastSuccess     time.Time
-// 	LastFailure     time.Time
-// 	FailureCount    int
-// 	SuccessCount    int
-// 	IsHealthy       bool
-// 	LastHealthCheck time.Time
-// }
-
-// Start starts the HTTP server
+// Start starts the HTTP server. After a graceful shutdown it returns nil once
+// draining has finished.
 func (d *DistributorServer) Start() error {
-	// Set up routes
-	http.HandleFunc("/logs", d.handleLogPacket)
-	http.HandleFunc("/health", d.handleHealth)
-	http.HandleFunc("/queue", d.handleQueueStatus)
+	// Restore messages that were still queued when the distributor last stopped
+	if err := d.restoreQueue(); err != nil {
+		return err
+	}
+
+	// Reload dead letters from previous runs
+	deadLetters, err := openDeadLetterQueue(d.config.DeadLetter.WAL)
+	if err != nil {
+		return err
+	}
+	d.deadLetters = deadLetters
+
+	// Open the ingest journal and collect packets that were accepted but never fully distributed
+	unfinished, err := d.openJournal()
+	if err != nil {
+		return err
+	}
 
-	// Start background queue processor
+	// Set up routes
+	mux := http.NewServeMux()
+	mux.HandleFunc("/logs", d.handleLogPacket)
+	mux.HandleFunc("/v1/logs", d.handleOTLPLogs)
+	mux.HandleFunc("/logs/stream", d.handleLogStream)
+	mux.HandleFunc("/health", d.handleHealth)
+	mux.HandleFunc("/queue", d.handleQueueStatus)
+	mux.HandleFunc("/admin/analyzers", d.handleAnalyzers)
+	mux.HandleFunc("/admin/analyzers/", d.handleAnalyzer)
+	mux.HandleFunc("/admin/reload", d.handleReload)
+	if d.config.Membership.Enabled {
+		mux.HandleFunc("/members", d.handleMembers)
+		mux.HandleFunc("/members/", d.handleMember)
+	}
+	mux.HandleFunc("/deadletters", d.handleDeadLetters)
+	mux.HandleFunc("/deadletters/", d.handleDeadLetter)
+	mux.Handle("/metrics", d.metrics.registry.Handler())
+
+	// Start background queue processor and analyzer health prober
 	go d.processQueueWorker()
+	go d.healthCheckWorker()
+	if d.configPath != "" {
+		go d.configReloadWorker()
+	}
+	if d.config.Membership.Enabled {
+		go d.membershipWorker()
+	}
+	go d.shutdownOnSignal()
+
+	// Resume distribution of journaled packets
+	d.resumeJournaledPackets(unfinished)
 
 	// Start server
 	addr := fmt.Sprintf(":%d", d.config.Port)
 	log.Printf("Distributor server starting on port %d", d.config.Port)
 	log.Printf("Health check available at http://localhost%s/health", addr)
 	log.Printf("Log endpoint available at http://localhost%s/logs", addr)
+	log.Printf("OTLP logs endpoint available at http://localhost%s/v1/logs", addr)
+	log.Printf("Streaming log endpoint available at http://localhost%s/logs/stream", addr)
+	log.Printf("Metrics available at http://localhost%s/metrics", addr)
 
-	return http.ListenAndServe(addr, nil)
+	d.server = &http.Server{
+		Addr:    addr,
+		Handler: mux,
+	}
+
+	// Start the syslog listeners alongside the HTTP server
+	if d.config.Syslog.UDPPort > 0 || d.config.Syslog.TCPPort > 0 {
+		d.syslog = newSyslogServer(d, d.config.Syslog)
+		if err := d.syslog.start(); err != nil {
+			return err
+		}
+	}
+	if err := d.server.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+	<-d.stopped
+	return nil
 }
 
 // handleLogPacket processes incoming log packets
@@ -91,36 +233,132 @@ func (d *DistributorServer) handleLogPacket(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	// Parse the log packet
+	// A draining distributor takes no new work so emitters move to another one
+	if d.draining() {
+		http.Error(w, "Distributor is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+
+	// Compressed bodies are decoded up to the configured size
+	if !d.decodeRequestBody(w, r, d.current().config.Compression.MaxDecodedSize) {
+		return
+	}
+
+	// Parse the log packet; plain-text bodies carry one raw log line per line and binary
+	// bodies a packet in the wire package's format
 	var packet models.LogPacket
-	if err := json.NewDecoder(r.Body).Decode(&packet); err != nil {
+	if wire.IsContentType(r.Header.Get("Content-Type")) {
+		if err := readWirePacket(r, &packet); err != nil {
+			log.Printf("Error decoding binary log packet: %v", err)
+			switch {
+			case errors.Is(err, compression.ErrTooLarge):
+				compression.WriteError(w, err)
+			case errors.Is(err, wire.ErrVersion):
+				http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+			default:
+				http.Error(w, "Invalid binary packet", http.StatusBadRequest)
+			}
+			return
+		}
+	} else if isPlainText(r) {
+		var err error
+		if packet, err = d.readRawPacket(r); err != nil {
+			log.Printf("Error reading raw log lines: %v", err)
+			if errors.Is(err, compression.ErrTooLarge) {
+				compression.WriteError(w, err)
+				return
+			}
+			http.Error(w, "Invalid log lines", http.StatusBadRequest)
+			return
+		}
+	} else if err := json.NewDecoder(r.Body).Decode(&packet); err != nil {
 		log.Printf("Error decoding log packet: %v", err)
+		if errors.Is(err, compression.ErrTooLarge) {
+			compression.WriteError(w, err)
+			return
+		}
 		http.Error(w, "Invalid JSON", http.StatusBadRequest)
 		return
 	}
 
+	// In durable mode the packet is journaled before it is acknowledged
+	distributed := make(chan struct{})
+	receipt, err := d.ingestPacket(packet, func() { close(distributed) })
+	if err != nil {
+		http.Error(w, "Failed to persist log packet", http.StatusServiceUnavailable)
+		return
+	}
+	if d.config.IngestMode == models.IngestModeDurable {
+		writeReceipt(w, packet, receipt)
+		return
+	}
+
 	// Send success response
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("Log packet received successfully"))
 
-	// Process log messages in parallel with backpressure control
-	d.distributeLogMessagesParallel(packet.Messages)
+	// Hold the request until the messages are distributed, which slows emitters down
+	// when analyzers fall behind
+	<-distributed
+}
 
-	// for _, message := range packet.Messages {
-	// 	d.distributeLogMessage(message)
-	// }
+// readWirePacket decodes a body in the binary packet format
+func readWirePacket(r *http.Request, packet *models.LogPacket) error {
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	return wire.Unmarshal(data, packet)
 }
 
-// distributeLogMessagesParallel processes multiple log messages concurrently
-func (d *DistributorServer) distributeLogMessagesParallel(messages []models.LogMessage) {
+// ingestPacket distributes a packet from any ingestion path without waiting for it. In
+// durable mode it is journaled first, the receipt names its journal entry and an error
+// means it was not accepted. done, if not nil, is called once the packet has been
+// distributed.
+func (d *DistributorServer) ingestPacket(packet models.LogPacket, done func()) (uint64, error) {
+	d.metrics.packetsReceived.With().Inc()
+	d.metrics.messagesReceived.With().Add(float64(len(packet.Messages)))
+
+	durable := d.config.IngestMode == models.IngestModeDurable
+	var jp journaledPacket
+	if durable {
+		var err error
+		if jp, err = d.journalPacket(packet); err != nil {
+			return 0, err
+		}
+	}
+
+	// The work is registered before the goroutine starts so that shutdown waits for it
+	d.work.add()
+	go func() {
+		defer d.work.done()
+		if durable {
+			d.processJournaledPacket(jp)
+		} else {
+			d.distributeLogMessagesParallel(packet.Messages)
+		}
+		if done != nil {
+			done()
+		}
+	}()
+	return jp.Receipt, nil
+}
+
+// distributeLogMessagesParallel processes multiple log messages concurrently. The error
+// reports messages that were neither delivered nor persisted in the retry queue.
+func (d *DistributorServer) distributeLogMessagesParallel(messages []models.LogMessage) error {
 	if len(messages) == 0 {
-		return
+		return nil
 	}
 
+	d.work.add()
+	defer d.work.done()
+
 	log.Printf("Processing %d log messages in parallel", len(messages))
 
 	// Use WaitGroup to wait for all goroutines to complete
 	var wg sync.WaitGroup
+	var unpersisted atomic.Int64
 	wg.Add(len(messages))
 
 	// Process each message in a separate goroutine
@@ -128,77 +366,74 @@ func (d *DistributorServer) distributeLogMessagesParallel(messages []models.LogM
 		go func(msg models.LogMessage) {
 			defer wg.Done()
 
-			// Acquire worker slot (backpressure mechanism)
-			d.workerPool <- struct{}{}
-			defer func() { <-d.workerPool }()
-
-			// Distribute the log message
-			d.distributeLogMessage(msg)
+			// Distribute the log message; worker slots are taken per analyzer request
+			if err := d.distributeLogMessage(msg); err != nil {
+				unpersisted.Add(1)
+			}
 		}(logMessage)
 	}
 
 	// Wait for all messages to be processed
 	wg.Wait()
 	log.Printf("Completed processing %d log messages", len(messages))
-}
 
-func (d *DistributorServer) distributeLogMessage(logMessage models.LogMessage) {
-	analyzerConfig := d.selectAnalyzer()
-	if analyzerConfig.ID == "" {
-		log.Printf("No analyzers available for log message: %s", logMessage.ID)
-		return
+	if n := unpersisted.Load(); n > 0 {
+		return fmt.Errorf("%d of %d messages could not be persisted in the retry queue", n, len(messages))
 	}
+	return nil
+}
 
-	log.Printf("Selected analyzer %s (weight: %.2f) for log message: %s",
-		analyzerConfig.ID, analyzerConfig.Weight, logMessage.ID)
-
-	timeout := time.Duration(analyzerConfig.Timeout) * time.Millisecond
-	if timeout == 0 {
-		timeout = 10 * time.Second
+// distributeLogMessage delivers a message, or queues it for retry once its retries are
+// exhausted. The error reports a queued message that could not be persisted.
+func (d *DistributorServer) distributeLogMessage(logMessage models.LogMessage) error {
+	group, analyzerConfig := d.selectAnalyzer(logMessage)
+	if analyzerConfig.ID == "" {
+		log.Printf("No analyzers available in group %s for log message %s, queuing it", group, logMessage.ID)
+		return d.enqueueFailedMessage(logMessage, "", fmt.Errorf("no analyzers available in group %s", group))
 	}
 
-	client := &http.Client{
-		Timeout: timeout,
-	}
-
-	jsonData, err := json.Marshal(logMessage)
-	if err != nil {
-		log.Printf("Error marshalling log message %s: %v", logMessage.ID, err)
-		return
-	}
+	log.Printf("Selected analyzer %s (group: %s, weight: %.2f) for log message: %s",
+		analyzerConfig.ID, group, analyzerConfig.Weight, logMessage.ID)
 
 	var lastErr error
+	attempts := 0
 	for attempt := 0; attempt <= analyzerConfig.RetryCount; attempt++ {
+		// The analyzer was removed or drained mid-delivery, move the message to another one
+		if !d.accepting(analyzerConfig.ID) {
+			previous := analyzerConfig.ID
+			group, analyzerConfig = d.selectAnalyzer(logMessage)
+			if analyzerConfig.ID == "" {
+				lastErr = fmt.Errorf("analyzer %s no longer accepts traffic and no analyzers are available in group %s", previous, group)
+				break
+			}
+			log.Printf("Analyzer %s no longer accepts traffic, rerouting log message %s to %s",
+				previous, logMessage.ID, analyzerConfig.ID)
+		}
+
+		// Stop burning the retry budget once the analyzer's circuit is open
+		if !d.health.allow(analyzerConfig.ID) {
+			lastErr = fmt.Errorf("circuit open for analyzer %s", analyzerConfig.ID)
+			log.Printf("Circuit open for analyzer %s, not retrying log message %s",
+				analyzerConfig.ID, logMessage.ID)
+			break
+		}
+		attempts++
+
 		if attempt > 0 {
+			d.metrics.retries.With("inline").Inc()
 			log.Printf("Retrying log message %s to analyzer %s (attempt %d/%d)",
 				logMessage.ID, analyzerConfig.ID, attempt+1, analyzerConfig.RetryCount+1)
 		}
 
-		ctx, cancel := context.WithTimeout(context.Background(), timeout)
-		req, err := http.NewRequestWithContext(
-			ctx,
-			"POST",
-			analyzerConfig.Endpoint,
-			bytes.NewBuffer(jsonData),
-		)
-		if err != nil {
-			cancel()
-			log.Printf("Error creating request for log message %s: %v", logMessage.ID, err)
-			return
+		statusCode, duration, err := d.deliver(analyzerConfig, logMessage, classLive)
+		if err != nil && d.ctx.Err() != nil {
+			// Shutdown aborted the request, persist the message in the retry queue
+			d.health.release(analyzerConfig.ID)
+			lastErr = fmt.Errorf("delivery aborted by shutdown: %w", err)
+			break
 		}
-
-		req.Header.Set("Content-Type", "application/json")
-		req.Header.Set("User-Agent", "log-distributor/1.0")
-		req.Header.Set("X-Log-ID", logMessage.ID)
-		req.Header.Set("X-Analyzer-ID", analyzerConfig.ID)
-
-		start := time.Now()
-		resp, err := client.Do(req)
-		duration := time.Since(start)
-
-		cancel()
-
 		if err != nil {
+			d.health.recordFailure(analyzerConfig.ID)
 			lastErr = fmt.Errorf("network error: %w", err)
 			log.Printf("Network error sending log message %s to analyzer %s (attempt %d): %v",
 				logMessage.ID, analyzerConfig.ID, attempt+1, err)
@@ -206,109 +441,129 @@ func (d *DistributorServer) distributeLogMessage(logMessage models.LogMessage) {
 			if attempt < analyzerConfig.RetryCount {
 				backoff := time.Duration(1<<attempt) * time.Second
 				log.Printf("Waiting %v before retry for log message %s", backoff, logMessage.ID)
-				time.Sleep(backoff)
+				d.pause(backoff)
 			}
 			continue
 		}
 
-		defer resp.Body.Close()
-
-		if resp.StatusCode == http.StatusOK {
+		if statusCode == http.StatusOK {
+			d.health.recordSuccess(analyzerConfig.ID)
 			log.Printf("Successfully sent log message %s to analyzer %s in %v",
 				logMessage.ID, analyzerConfig.ID, duration)
-			return
+			return nil
 		}
 
-		lastErr = fmt.Errorf("analyzer returned status code: %d", resp.StatusCode)
+		lastErr = fmt.Errorf("analyzer returned status code: %d", statusCode)
 		log.Printf("Analyzer %s returned status code %d for log message %s (attempt %d)",
-			analyzerConfig.ID, resp.StatusCode, logMessage.ID, attempt+1)
+			analyzerConfig.ID, statusCode, logMessage.ID, attempt+1)
 
-		if resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != 429 {
+		if statusCode >= 400 && statusCode < 500 && statusCode != 429 {
+			// The analyzer is up and rejected this message, so the circuit stays closed
+			d.health.recordSuccess(analyzerConfig.ID)
 			log.Printf("Not retrying log message %s due to client error (status %d)",
-				logMessage.ID, resp.StatusCode)
-			return
+				logMessage.ID, statusCode)
+			return nil
 		}
+		d.health.recordFailure(analyzerConfig.ID)
 
 		if attempt < analyzerConfig.RetryCount {
 			backoff := time.Duration(1<<attempt) * time.Second
 			log.Printf("Waiting %v before retry for log message %s", backoff, logMessage.ID)
-			time.Sleep(backoff)
+			d.pause(backoff)
 		}
 	}
 
 	// All retries exhausted, enqueue for retry
 	log.Printf("Enqueuing log message %s for retry after %d failed attempts. Last error: %v",
-		logMessage.ID, analyzerConfig.RetryCount+1, lastErr)
-	d.enqueueFailedMessage(logMessage, analyzerConfig.ID)
+		logMessage.ID, attempts, lastErr)
+	return d.enqueueFailedMessage(logMessage, analyzerConfig.ID, lastErr)
 }
 
-// enqueueFailedMessage adds a failed message to the queue for future retry
-func (d *DistributorServer) enqueueFailedMessage(logMessage models.LogMessage, failedAnalyzer string) {
+// enqueueFailedMessage adds a failed message to the queue for future retry. A message
+// the queue write-ahead log cannot take is still retried from memory, and the error
+// tells the caller it would not survive a restart.
+func (d *DistributorServer) enqueueFailedMessage(logMessage models.LogMessage, failedAnalyzer string, lastErr error) error {
 	d.queueMu.Lock()
 	defer d.queueMu.Unlock()
 
-	qm := QueuedMessage{
+	now := time.Now()
+	qm := &QueuedMessage{
 		LogMessage:     logMessage,
 		TriedAnalyzers: map[string]bool{failedAnalyzer: true},
 		Attempts:       1,
-		LastAttempt:    time.Now(),
-		QueuedAt:       time.Now(),
+		LastAttempt:    now,
+		NextAttempt:    now.Add(d.retryBackoff(1)),
+		QueuedAt:       now,
+	}
+	if lastErr != nil {
+		qm.LastError = lastErr.Error()
+	}
+	seq, err := d.store.add(qm)
+	if err != nil {
+		log.Printf("Failed to persist queued message %s, keeping it in memory only: %v", logMessage.ID, err)
+		err = fmt.Errorf("failed to persist queued message %s: %w", logMessage.ID, err)
 	}
-	d.queue = append(d.queue, qm)
+	qm.Seq = seq
+	d.schedule(qm)
+	d.metrics.queued.With().Inc()
 	log.Printf("Message %s added to queue. Queue size: %d", logMessage.ID, len(d.queue))
+	return err
 }
 
-func (d *DistributorServer) selectAnalyzer() models.AnalyzerConfig {
-	// Get all analyzers and check their health
-	var analyzers []models.AnalyzerConfig
-	var totalWeight float64
-
-	for _, analyzer := range d.config.Analyzers {
-		// Check if analyzer is healthy by calling its health endpoint
-		// if d.isAnalyzerHealthy(analyzer) {
-		// 	analyzers = append(healthyAnalyzers, analyzer)
-		// 	totalWeight += analyzer.Weight
-		// }
-
-		analyzers = append(analyzers, analyzer)
-		totalWeight += analyzer.Weight
+// restoreQueue opens the queue write-ahead log and reloads every undelivered message
+func (d *DistributorServer) restoreQueue() error {
+	store, entries, err := openDurableLog("QUEUE", d.config.QueueWAL)
+	if err != nil {
+		return err
 	}
 
-	// Generate random number between 0 and total weight
-	rand.Seed(time.Now().UnixNano())
-	randomValue := rand.Float64() * totalWeight
-
-	// Select analyzer based on weighted distribution
-	currentWeight := 0.0
-	for _, analyzer := range analyzers {
-		currentWeight += analyzer.Weight
-		if randomValue <= currentWeight {
-			return analyzer
+	restored := make([]*QueuedMessage, 0, len(entries))
+	for _, entry := range entries {
+		qm := &QueuedMessage{}
+		if err := json.Unmarshal(entry.Data, qm); err != nil {
+			log.Printf("[QUEUE] Skipping unreadable queued message %d: %v", entry.Seq, err)
+			continue
 		}
+		qm.Seq = entry.Seq
+		if qm.TriedAnalyzers == nil {
+			qm.TriedAnalyzers = make(map[string]bool)
+		}
+		restored = append(restored, qm)
 	}
 
-	// Fallback to first healthy analyzer (shouldn't reach here)
-	return analyzers[0]
+	d.queueMu.Lock()
+	d.store = store
+	d.queue = append(d.queue, restored...)
+	heap.Init(&d.queue)
+	d.queueMu.Unlock()
+	return nil
 }
 
-// // isAnalyzerHealthy checks if an analyzer is healthy by calling its health endpoint
-// func (d *DistributorServer) isAnalyzerHealthy(analyzer models.AnalyzerConfig) bool {
-// 	client := &http.Client{Timeout: 5 * time.Second}
+// selectAnalyzer picks an analyzer from the group the message routes to
+func (d *DistributorServer) selectAnalyzer(logMessage models.LogMessage) (string, models.AnalyzerConfig) {
+	group := d.current().router.groupFor(logMessage)
 
-// 	// Try to hit the health endpoint
-// 	healthURL := strings.Replace(analyzer.Endpoint, "/analyze", "/health", 1)
-// 	resp, err := client.Get(healthURL)
-// 	if err != nil {
-// 		log.Printf("Health check failed for analyzer %s: %v", analyzer.ID, err)
-// 		return false
-// 	}
-// 	defer resp.Body.Close()
+	// Skip analyzers that are draining, fail their health probe or have an open circuit
+	analyzer := group.pick(logMessage, func(a models.AnalyzerConfig) bool {
+		return !a.Draining && d.health.available(a.ID)
+	})
+	return group.name, analyzer
+}
 
-// 	return resp.StatusCode == http.StatusOK
-// }
+// accepting reports whether an analyzer is still configured and not draining
+func (d *DistributorServer) accepting(id string) bool {
+	config := d.current().config
+	i := findAnalyzer(&config, id)
+	return i >= 0 && !config.Analyzers[i].Draining
+}
 
 // handleHealth provides a health check endpoint
 func (d *DistributorServer) handleHealth(w http.ResponseWriter, r *http.Request) {
+	if d.draining() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("Distributor is draining"))
+		return
+	}
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("Distributor is healthy"))
 }
@@ -319,14 +574,32 @@ func (d *DistributorServer) handleQueueStatus(w http.ResponseWriter, r *http.Req
 	d.queueMu.Lock()
 	size := len(d.queue)
 	oldest := ""
+	nextRetry := ""
 	if size > 0 {
-		oldest = time.Since(d.queue[0].QueuedAt).String()
+		oldestQueuedAt := d.queue[0].QueuedAt
+		for _, qm := range d.queue {
+			if qm.QueuedAt.Before(oldestQueuedAt) {
+				oldestQueuedAt = qm.QueuedAt
+			}
+		}
+		oldest = time.Since(oldestQueuedAt).String()
+		nextRetry = time.Until(d.queue[0].NextAttempt).String()
 	}
 	d.queueMu.Unlock()
 	resp := map[string]interface{}{
 		"queue_size":         size,
 		"oldest_message_age": oldest,
-		"timestamp":          time.Now().Format(time.RFC3339),
+		"next_retry_in":      nextRetry,
+		"journaled_packets":  d.journal.pending(),
+		"dead_letters":       d.deadLetters.count(),
+		"retries_in_flight":  len(d.retryInFlight),
+		"workers": map[string]interface{}{
+			"live":       d.workerPool.capacity(classLive),
+			"live_busy":  d.workerPool.inUse(classLive),
+			"retry":      d.workerPool.capacity(classRetry),
+			"retry_busy": d.workerPool.inUse(classRetry),
+		},
+		"timestamp": time.Now().Format(time.RFC3339),
 	}
 	json.NewEncoder(w).Encode(resp)
 }
@@ -345,13 +618,56 @@ func loadConfig(configPath string) (*models.DistributorConfig, error) {
 		return nil, fmt.Errorf("failed to parse config file: %w", err)
 	}
 
-	// Validate configuration
+	if err := validateConfig(&config); err != nil {
+		return nil, err
+	}
+
+	log.Printf("Loaded configuration:")
+	log.Printf("  Port: %d", config.Port)
+	log.Printf("  Total analyzers: %d", len(config.Analyzers))
+	log.Printf("  Total weight: %.2f", config.TotalWeight)
+	log.Printf("  Ingest mode: %s", config.IngestMode)
+	log.Printf("  Workers: %d (%.0f%% reserved for retries)", config.Workers.Size, config.Workers.RetryShare*100)
+	if len(config.Routing.Rules) > 0 {
+		log.Printf("  Routing: %d rules across %d groups", len(config.Routing.Rules), len(config.Routing.Groups))
+	}
+	if config.Batching.Enabled {
+		log.Printf("  Batching: up to %d messages, %d ms linger", config.Batching.MaxSize, config.Batching.Linger)
+	}
+	if config.Membership.Enabled {
+		log.Printf("  Membership: analyzers may register, %d ms heartbeat timeout", config.Membership.HeartbeatTimeout)
+	}
+	if config.QueueWAL.Dir != "" {
+		log.Printf("  Queue WAL: %s (sync: %s)", config.QueueWAL.Dir, config.QueueWAL.SyncPolicy)
+	}
+	if config.Syslog.UDPPort > 0 || config.Syslog.TCPPort > 0 {
+		log.Printf("  Syslog: UDP port %d, TCP port %d", config.Syslog.UDPPort, config.Syslog.TCPPort)
+	}
+
+	return &config, nil
+}
+
+// validateConfig checks a configuration and fills in defaults. It is used both at
+// startup and for every runtime change, so it must be safe to run more than once.
+func validateConfig(config *models.DistributorConfig) error {
 	if config.Port <= 0 {
-		return nil, fmt.Errorf("invalid port number: %d", config.Port)
+		return fmt.Errorf("invalid port number: %d", config.Port)
+	}
+
+	// With self-registration the pool may start empty and fill as analyzers register
+	if len(config.Analyzers) == 0 && !config.Membership.Enabled {
+		return fmt.Errorf("no analyzers configured")
 	}
 
-	if len(config.Analyzers) == 0 {
-		return nil, fmt.Errorf("no analyzers configured")
+	seen := make(map[string]bool)
+	for _, analyzer := range config.Analyzers {
+		if analyzer.ID == "" {
+			return fmt.Errorf("analyzer without an id")
+		}
+		if seen[analyzer.ID] {
+			return fmt.Errorf("duplicate analyzer: %s", analyzer.ID)
+		}
+		seen[analyzer.ID] = true
 	}
 
 	// Calculate total weight
@@ -360,115 +676,311 @@ func loadConfig(configPath string) (*models.DistributorConfig, error) {
 		config.TotalWeight += analyzer.Weight
 	}
 
-	if config.TotalWeight <= 0 {
-		return nil, fmt.Errorf("no analyzers with positive weights")
+	if len(config.Analyzers) > 0 && config.TotalWeight <= 0 {
+		return fmt.Errorf("no analyzers with positive weights")
 	}
 
-	log.Printf("Loaded configuration:")
-	log.Printf("  Port: %d", config.Port)
-	log.Printf("  Total analyzers: %d", len(config.Analyzers))
-	log.Printf("  Total weight: %.2f", config.TotalWeight)
+	// Set default shutdown values if not provided
+	if config.Shutdown.DrainDelay < 0 {
+		return fmt.Errorf("invalid shutdown drain delay: %d", config.Shutdown.DrainDelay)
+	}
+	if config.Shutdown.Timeout <= 0 {
+		config.Shutdown.Timeout = 10000
+	}
 
-	return &config, nil
+	if config.Membership.HeartbeatTimeout <= 0 {
+		config.Membership.HeartbeatTimeout = 15000
+	}
+
+	// Set default health check values if not provided
+	if config.HealthCheck.Interval <= 0 {
+		config.HealthCheck.Interval = 5000
+	}
+	if config.HealthCheck.Timeout <= 0 {
+		config.HealthCheck.Timeout = 2000
+	}
+	if config.HealthCheck.FailureThreshold <= 0 {
+		config.HealthCheck.FailureThreshold = 5
+	}
+	if config.HealthCheck.OpenDuration <= 0 {
+		config.HealthCheck.OpenDuration = 10000
+	}
+
+	if _, err := newRouter(config); err != nil {
+		return fmt.Errorf("invalid routing configuration: %w", err)
+	}
+
+	// Set default batching values if not provided
+	if config.Batching.MaxSize <= 0 {
+		config.Batching.MaxSize = 50
+	}
+	if config.Batching.Linger <= 0 {
+		config.Batching.Linger = 10
+	}
+
+	if err := validateWALConfig("queue_wal", config.QueueWAL); err != nil {
+		return err
+	}
+
+	// Set default retry backoff values if not provided
+	if config.Retry.InitialBackoff <= 0 {
+		config.Retry.InitialBackoff = 1000
+	}
+	if config.Retry.MaxBackoff <= 0 {
+		config.Retry.MaxBackoff = 60000
+	}
+	if config.Retry.Multiplier < 1 {
+		config.Retry.Multiplier = 2
+	}
+	if config.Retry.Jitter < 0 || config.Retry.Jitter > 1 {
+		return fmt.Errorf("invalid retry jitter: %.2f (must be between 0 and 1)", config.Retry.Jitter)
+	}
+
+	// Set default worker pool values if not provided
+	if config.Workers.Size <= 0 {
+		config.Workers.Size = 10
+	}
+	if config.Workers.RetryShare == 0 {
+		config.Workers.RetryShare = 0.2
+	}
+	if config.Workers.RetryShare < 0 || config.Workers.RetryShare >= 1 {
+		return fmt.Errorf("invalid worker retry share: %.2f (must be between 0 and 1)", config.Workers.RetryShare)
+	}
+
+	if config.DeadLetter.MaxAttempts < 0 {
+		return fmt.Errorf("invalid dead letter max attempts: %d", config.DeadLetter.MaxAttempts)
+	}
+	if config.DeadLetter.MaxAge < 0 {
+		return fmt.Errorf("invalid dead letter max age: %d", config.DeadLetter.MaxAge)
+	}
+	if err := validateWALConfig("dead_letter.wal", config.DeadLetter.WAL); err != nil {
+		return err
+	}
+
+	switch config.IngestMode {
+	case "":
+		config.IngestMode = models.IngestModeImmediate
+	case models.IngestModeImmediate:
+	case models.IngestModeDurable:
+		// A journaled packet is only finished once its undelivered messages are persisted,
+		// which takes a queue on disk
+		if config.IngestJournal.Dir == "" || config.QueueWAL.Dir == "" {
+			return fmt.Errorf("ingest mode %s requires ingest_journal.dir and queue_wal.dir", config.IngestMode)
+		}
+	default:
+		return fmt.Errorf("invalid ingest mode: %s", config.IngestMode)
+	}
+
+	if err := validateWALConfig("ingest_journal", config.IngestJournal); err != nil {
+		return err
+	}
+
+	// Set default raw ingestion values if not provided
+	if config.RawIngest.Source == "" {
+		config.RawIngest.Source = "raw"
+	}
+	if _, err := parser.New(config.RawIngest.Parser); err != nil {
+		return fmt.Errorf("invalid raw_ingest parser: %w", err)
+	}
+	if _, err := parser.NewMultiline(config.RawIngest.Multiline); err != nil {
+		return fmt.Errorf("invalid raw_ingest multiline settings: %w", err)
+	}
+
+	// Set default syslog values if not provided
+	if config.Syslog.UDPPort < 0 || config.Syslog.TCPPort < 0 {
+		return fmt.Errorf("invalid syslog ports: udp %d, tcp %d", config.Syslog.UDPPort, config.Syslog.TCPPort)
+	}
+	if config.Syslog.MaxMessageSize <= 0 {
+		config.Syslog.MaxMessageSize = 65536
+	}
+	if config.Syslog.BatchSize <= 0 {
+		config.Syslog.BatchSize = 100
+	}
+	if config.Syslog.FlushInterval <= 0 {
+		config.Syslog.FlushInterval = 1000
+	}
+	if config.Syslog.Source == "" {
+		config.Syslog.Source = "syslog"
+	}
+
+	// Set default stream ingestion values if not provided
+	if config.StreamIngest.BatchSize <= 0 {
+		config.StreamIngest.BatchSize = 100
+	}
+	if config.StreamIngest.FlushInterval <= 0 {
+		config.StreamIngest.FlushInterval = 1000
+	}
+	if config.StreamIngest.MaxInFlight <= 0 {
+		config.StreamIngest.MaxInFlight = 4
+	}
+	if config.StreamIngest.MaxLineSize <= 0 {
+		config.StreamIngest.MaxLineSize = 1024 * 1024
+	}
+
+	// Set default compression values if not provided
+	if config.Compression.Encoding == "" {
+		config.Compression.Encoding = compression.Gzip
+	}
+	if !compression.Valid(config.Compression.Encoding) {
+		return fmt.Errorf("invalid compression encoding: %s", config.Compression.Encoding)
+	}
+	if config.Compression.MaxDecodedSize <= 0 {
+		config.Compression.MaxDecodedSize = compression.DefaultMaxDecodedSize
+	}
+
+	return nil
 }
 
-// processQueueWorker periodically retries queued messages
+// processQueueWorker retries queued messages as their next attempt time comes due.
+// Each due message is redelivered on its own goroutine without holding queueMu, so
+// enqueueing and /queue never wait on analyzers; retryInFlight bounds the redeliveries
+// and the worker pool's retry slots bound the requests they make.
 func (d *DistributorServer) processQueueWorker() {
-	for {
-		time.Sleep(2 * time.Second)
-		d.queueMu.Lock()
-		if len(d.queue) == 0 {
-			d.queueMu.Unlock()
+	for !d.draining() {
+		due, wait := d.takeDue(time.Now())
+		if len(due) == 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-d.queueWake:
+			case <-d.stopping:
+			case <-timer.C:
+			}
+			timer.Stop()
 			continue
 		}
-		newQueue := make([]QueuedMessage, 0, len(d.queue))
-		for _, qm := range d.queue {
-			analyzer := d.selectAlternativeAnalyzer(qm.TriedAnalyzers)
-			if analyzer.ID == "" {
-				// No alternative analyzer available, keep in queue
-				newQueue = append(newQueue, qm)
-				continue
-			}
-			// Try to deliver
-			success := d.tryDeliverQueued(qm, analyzer)
-			if !success {
-				// Mark this analyzer as tried and keep in queue
-				qm.TriedAnalyzers[analyzer.ID] = true
-				qm.Attempts++
-				qm.LastAttempt = time.Now()
-				newQueue = append(newQueue, qm)
-			}
+
+		for _, qm := range due {
+			d.retryInFlight <- struct{}{}
+			d.work.add()
+			go func(qm *QueuedMessage) {
+				defer d.work.done()
+				defer func() { <-d.retryInFlight }()
+				d.retryQueued(qm)
+			}(qm)
 		}
-		d.queue = newQueue
-		d.queueMu.Unlock()
 	}
 }
 
-// selectAlternativeAnalyzer picks an analyzer not in tried map
-func (d *DistributorServer) selectAlternativeAnalyzer(tried map[string]bool) models.AnalyzerConfig {
-	var candidates []models.AnalyzerConfig
-	for _, analyzer := range d.config.Analyzers {
-		if !tried[analyzer.ID] {
-			candidates = append(candidates, analyzer)
+// retryQueued makes one delivery attempt for a queued message that is due,
+// rescheduling it with backoff when the attempt fails
+func (d *DistributorServer) retryQueued(qm *QueuedMessage) {
+	// Messages that are out of attempts or too old go to the dead-letter store
+	if reason := d.deadLetterReason(*qm); reason != "" {
+		if err := d.moveToDeadLetters(*qm, reason); err != nil {
+			// Try the dead-letter store again after a backoff
+			qm.NextAttempt = time.Now().Add(d.retryBackoff(qm.Attempts))
+			d.queueMu.Lock()
+			d.schedule(qm)
+			d.queueMu.Unlock()
 		}
+		return
+	}
+
+	analyzer := d.selectAlternativeAnalyzer(qm.LogMessage, qm.TriedAnalyzers)
+	if analyzer.ID == "" && len(qm.TriedAnalyzers) > 0 {
+		// Every analyzer has been tried, start a new rotation so recovered analyzers get another chance
+		log.Printf("[QUEUE] All analyzers tried for log message %s, starting a new rotation", qm.LogMessage.ID)
+		qm.TriedAnalyzers = make(map[string]bool)
+		analyzer = d.selectAlternativeAnalyzer(qm.LogMessage, qm.TriedAnalyzers)
 	}
-	if len(candidates) == 0 {
-		return models.AnalyzerConfig{}
-	}
-	// Weighted random selection
-	var totalWeight float64
-	for _, a := range candidates {
-		totalWeight += a.Weight
-	}
-	rand.Seed(time.Now().UnixNano())
-	r := rand.Float64() * totalWeight
-	w := 0.0
-	for _, a := range candidates {
-		w += a.Weight
-		if r <= w {
-			return a
+	if analyzer.ID == "" {
+		// No analyzer available right now, keep in queue
+		qm.NextAttempt = time.Now().Add(d.retryBackoff(qm.Attempts))
+		d.queueMu.Lock()
+		d.schedule(qm)
+		d.queueMu.Unlock()
+		return
+	}
+
+	// Try to deliver
+	d.metrics.retries.With("queue").Inc()
+	err := d.tryDeliverQueued(*qm, analyzer)
+	if err == nil {
+		if err := d.store.ack(qm.Seq); err != nil {
+			log.Printf("[QUEUE] Failed to record delivery of log message %s: %v", qm.LogMessage.ID, err)
 		}
+		return
+	}
+	if d.ctx.Err() != nil {
+		// Shutdown aborted the attempt; the message stays in the queue write-ahead log
+		d.queueMu.Lock()
+		d.schedule(qm)
+		d.queueMu.Unlock()
+		return
+	}
+
+	// Mark this analyzer as tried and keep in queue
+	qm.TriedAnalyzers[analyzer.ID] = true
+	qm.Attempts++
+	qm.LastAttempt = time.Now()
+	qm.LastError = err.Error()
+	if reason := d.deadLetterReason(*qm); reason != "" {
+		if err := d.moveToDeadLetters(*qm, reason); err != nil {
+			// Try the dead-letter store again after a backoff
+			qm.NextAttempt = time.Now().Add(d.retryBackoff(qm.Attempts))
+			d.queueMu.Lock()
+			d.schedule(qm)
+			d.queueMu.Unlock()
+		}
+		return
+	}
+
+	qm.NextAttempt = qm.LastAttempt.Add(d.retryBackoff(qm.Attempts))
+	d.persistQueued(qm)
+	d.queueMu.Lock()
+	d.schedule(qm)
+	d.queueMu.Unlock()
+}
+
+// persistQueued replaces a rescheduled message's record in the queue write-ahead log,
+// so that its attempts, tried analyzers and next attempt survive a restart. If the new
+// record cannot be written the old one is kept.
+func (d *DistributorServer) persistQueued(qm *QueuedMessage) {
+	seq, err := d.store.add(qm)
+	if err != nil {
+		log.Printf("[QUEUE] Failed to persist retry state of log message %s: %v", qm.LogMessage.ID, err)
+		return
+	}
+	previous := qm.Seq
+	qm.Seq = seq
+	if err := d.store.ack(previous); err != nil {
+		log.Printf("[QUEUE] Failed to record replaced retry state of log message %s: %v", qm.LogMessage.ID, err)
 	}
-	return candidates[0]
+}
+
+// selectAlternativeAnalyzer picks an analyzer from the message's group that is not in tried map
+func (d *DistributorServer) selectAlternativeAnalyzer(logMessage models.LogMessage, tried map[string]bool) models.AnalyzerConfig {
+	group := d.current().router.groupFor(logMessage)
+
+	return group.pick(logMessage, func(a models.AnalyzerConfig) bool {
+		return !tried[a.ID] && !a.Draining && d.health.available(a.ID)
+	})
 }
 
 // tryDeliverQueued tries to deliver a queued message to a given analyzer
-func (d *DistributorServer) tryDeliverQueued(qm QueuedMessage, analyzer models.AnalyzerConfig) bool {
-	timeout := time.Duration(analyzer.Timeout) * time.Millisecond
-	if timeout == 0 {
-		timeout = 10 * time.Second
+func (d *DistributorServer) tryDeliverQueued(qm QueuedMessage, analyzer models.AnalyzerConfig) error {
+	if !d.health.allow(analyzer.ID) {
+		log.Printf("[QUEUE] Circuit open for analyzer %s, skipping log message %s", analyzer.ID, qm.LogMessage.ID)
+		return fmt.Errorf("circuit open for analyzer %s", analyzer.ID)
 	}
-	client := &http.Client{Timeout: timeout}
-	jsonData, err := json.Marshal(qm.LogMessage)
-	if err != nil {
-		log.Printf("[QUEUE] Error marshalling log message %s: %v", qm.LogMessage.ID, err)
-		return false
+	statusCode, duration, err := d.deliver(analyzer, qm.LogMessage, classRetry)
+	if err != nil && d.ctx.Err() != nil {
+		d.health.release(analyzer.ID)
+		return fmt.Errorf("delivery aborted by shutdown: %w", err)
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
-	defer cancel()
-	req, err := http.NewRequestWithContext(ctx, "POST", analyzer.Endpoint, bytes.NewBuffer(jsonData))
-	if err != nil {
-		log.Printf("[QUEUE] Error creating request for log message %s: %v", qm.LogMessage.ID, err)
-		return false
-	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("User-Agent", "log-distributor/1.0")
-	req.Header.Set("X-Log-ID", qm.LogMessage.ID)
-	req.Header.Set("X-Analyzer-ID", analyzer.ID)
-	start := time.Now()
-	resp, err := client.Do(req)
-	duration := time.Since(start)
 	if err != nil {
+		d.health.recordFailure(analyzer.ID)
 		log.Printf("[QUEUE] Network error sending log message %s to analyzer %s: %v", qm.LogMessage.ID, analyzer.ID, err)
-		return false
+		return fmt.Errorf("network error: %w", err)
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode == http.StatusOK {
+	if statusCode == http.StatusOK {
+		d.health.recordSuccess(analyzer.ID)
 		log.Printf("[QUEUE] Successfully delivered log message %s to analyzer %s in %v", qm.LogMessage.ID, analyzer.ID, duration)
-		return true
+		return nil
 	}
-	log.Printf("[QUEUE] Analyzer %s returned status %d for log message %s", analyzer.ID, resp.StatusCode, qm.LogMessage.ID)
-	return false
+	d.health.recordFailure(analyzer.ID)
+	log.Printf("[QUEUE] Analyzer %s returned status %d for log message %s", analyzer.ID, statusCode, qm.LogMessage.ID)
+	return fmt.Errorf("analyzer %s returned status code: %d", analyzer.ID, statusCode)
 }
 
 func main() {
@@ -483,8 +995,9 @@ func main() {
 		log.Fatalf("Failed to load configuration: %v", err)
 	}
 
-	// Create and start the distributor server
+	// Create and start the distributor server, reloading the configuration on SIGHUP or change
 	server := NewDistributorServer(*config)
+	server.configPath = configPath
 
 	if err := server.Start(); err != nil {
 		log.Fatalf("Failed to start distributor server: %v", err)
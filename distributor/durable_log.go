@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"resolve/models"
+	"resolve/wal"
+)
+
+const (
+	durableOpAdd = "add"
+	durableOpAck = "ack"
+)
+
+// durableRecord is a single entry in a durable log
+type durableRecord struct {
+	Op   string          `json:"op"`             // add or ack
+	Seq  uint64          `json:"seq,omitempty"`  // sequence being acknowledged
+	Data json.RawMessage `json:"data,omitempty"` // item being added
+}
+
+// durableEntry is an item that was added but never acknowledged
+type durableEntry struct {
+	Seq  uint64
+	Data json.RawMessage
+}
+
+// durableLog persists items in a write-ahead log until they are acknowledged.
+// Every add is appended to the log and every ack appends a marker; segments are
+// dropped once every item they hold has been acknowledged.
+// Without a configured directory it only hands out sequence numbers.
+type durableLog struct {
+	name    string
+	log     *wal.Log
+	mu      sync.Mutex
+	nextSeq uint64
+	live    map[uint64]struct{}
+	minLive uint64
+}
+
+// openDurableLog opens the log and replays it, returning every item that was
+// added but never acknowledged, oldest first
+func openDurableLog(name string, cfg models.WALConfig) (*durableLog, []durableEntry, error) {
+	dl := &durableLog{
+		name:    name,
+		nextSeq: 1,
+		live:    make(map[uint64]struct{}),
+	}
+	if cfg.Dir == "" {
+		return dl, nil, nil
+	}
+
+	l, err := wal.Open(cfg.Dir, walOptions(cfg))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open %s wal: %w", name, err)
+	}
+	dl.log = l
+
+	pending := make(map[uint64]json.RawMessage)
+	err = l.Replay(func(index uint64, data []byte) error {
+		var record durableRecord
+		if err := json.Unmarshal(data, &record); err != nil {
+			log.Printf("[%s] Skipping unreadable wal record %d: %v", name, index, err)
+			return nil
+		}
+		switch record.Op {
+		case durableOpAdd:
+			pending[index] = record.Data
+		case durableOpAck:
+			delete(pending, record.Seq)
+		}
+		return nil
+	})
+	if err != nil {
+		l.Close()
+		return nil, nil, fmt.Errorf("failed to replay %s wal: %w", name, err)
+	}
+
+	entries := make([]durableEntry, 0, len(pending))
+	for seq, data := range pending {
+		dl.live[seq] = struct{}{}
+		entries = append(entries, durableEntry{Seq: seq, Data: data})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Seq < entries[j].Seq
+	})
+
+	dl.recomputeMinLive()
+	if err := dl.truncate(); err != nil {
+		log.Printf("[%s] Failed to truncate wal: %v", name, err)
+	}
+
+	log.Printf("[%s] Replayed %d pending entries from %s (%d segments)",
+		name, len(entries), cfg.Dir, l.SegmentCount())
+	return dl, entries, nil
+}
+
+// add appends an item and returns its sequence number
+func (dl *durableLog) add(item interface{}) (uint64, error) {
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+
+	if dl.log == nil {
+		seq := dl.nextSeq
+		dl.nextSeq++
+		return seq, nil
+	}
+
+	itemData, err := json.Marshal(item)
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal %s entry: %w", dl.name, err)
+	}
+	data, err := json.Marshal(durableRecord{Op: durableOpAdd, Data: itemData})
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal %s record: %w", dl.name, err)
+	}
+	seq, err := dl.log.Append(data)
+	if err != nil {
+		return 0, err
+	}
+
+	dl.live[seq] = struct{}{}
+	if dl.minLive == 0 || seq < dl.minLive {
+		dl.minLive = seq
+	}
+	return seq, nil
+}
+
+// ack records that an item is finished with and drops fully acknowledged segments
+func (dl *durableLog) ack(seq uint64) error {
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+
+	if dl.log == nil {
+		return nil
+	}
+	if _, ok := dl.live[seq]; !ok {
+		return nil
+	}
+
+	data, err := json.Marshal(durableRecord{Op: durableOpAck, Seq: seq})
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s ack: %w", dl.name, err)
+	}
+	if _, err := dl.log.Append(data); err != nil {
+		return err
+	}
+
+	delete(dl.live, seq)
+	if seq == dl.minLive {
+		dl.recomputeMinLive()
+		return dl.truncate()
+	}
+	return nil
+}
+
+// pending returns the number of unacknowledged items
+func (dl *durableLog) pending() int {
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+	return len(dl.live)
+}
+
+// recomputeMinLive finds the oldest unacknowledged sequence number
+func (dl *durableLog) recomputeMinLive() {
+	dl.minLive = 0
+	for seq := range dl.live {
+		if dl.minLive == 0 || seq < dl.minLive {
+			dl.minLive = seq
+		}
+	}
+}
+
+// truncate removes every segment older than the oldest unacknowledged item
+func (dl *durableLog) truncate() error {
+	if dl.minLive == 0 {
+		return dl.log.TruncateFront(dl.log.NextIndex())
+	}
+	return dl.log.TruncateFront(dl.minLive)
+}
+
+// close flushes and closes the underlying log
+func (dl *durableLog) close() error {
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+	if dl.log == nil {
+		return nil
+	}
+	return dl.log.Close()
+}
+
+// walOptions converts a WAL configuration block into log options
+func walOptions(cfg models.WALConfig) wal.Options {
+	return wal.Options{
+		SegmentSize:  cfg.SegmentSize,
+		SyncPolicy:   wal.SyncPolicy(cfg.SyncPolicy),
+		SyncInterval: time.Duration(cfg.SyncInterval) * time.Millisecond,
+	}
+}
+
+// validateWALConfig checks a WAL configuration block
+func validateWALConfig(name string, cfg models.WALConfig) error {
+	switch wal.SyncPolicy(cfg.SyncPolicy) {
+	case "", wal.SyncAlways, wal.SyncInterval, wal.SyncNone:
+	default:
+		return fmt.Errorf("invalid %s sync policy: %s", name, cfg.SyncPolicy)
+	}
+	if cfg.SegmentSize < 0 {
+		return fmt.Errorf("invalid %s segment size: %d", name, cfg.SegmentSize)
+	}
+	if cfg.SyncInterval < 0 {
+		return fmt.Errorf("invalid %s sync interval: %d", name, cfg.SyncInterval)
+	}
+	return nil
+}
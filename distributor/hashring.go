@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"sort"
+	"strings"
+
+	"resolve/models"
+)
+
+// defaultVirtualNodes is the number of ring points per unit of analyzer weight
+const defaultVirtualNodes = 100
+
+// ringPoint is one virtual node on the hash ring
+type ringPoint struct {
+	hash  uint64
+	owner int // index into hashRing.analyzers
+}
+
+// hashRing is a consistent-hash ring over a set of analyzers. Each analyzer owns a
+// number of virtual nodes proportional to its weight, so adding, removing or skipping
+// an analyzer only remaps the keys that analyzer owned.
+type hashRing struct {
+	analyzers []models.AnalyzerConfig
+	points    []ringPoint
+}
+
+// newHashRing builds a ring with virtualNodes points per unit of weight. Analyzers whose
+// weight rounds to no points are left off the ring.
+func newHashRing(analyzers []models.AnalyzerConfig, virtualNodes int) *hashRing {
+	if virtualNodes <= 0 {
+		virtualNodes = defaultVirtualNodes
+	}
+
+	ring := &hashRing{analyzers: analyzers}
+	for i, analyzer := range analyzers {
+		// An analyzer without weight gets no points, as weighted selection never picks it
+		nodes := int(math.Round(analyzer.Weight * float64(virtualNodes)))
+		for v := 0; v < nodes; v++ {
+			ring.points = append(ring.points, ringPoint{
+				hash:  hashKey(fmt.Sprintf("%s#%d", analyzer.ID, v)),
+				owner: i,
+			})
+		}
+	}
+
+	sort.Slice(ring.points, func(i, j int) bool {
+		return ring.points[i].hash < ring.points[j].hash
+	})
+	return ring
+}
+
+// lookup returns the first eligible analyzer clockwise from the key's position.
+// Ineligible analyzers are skipped, so their keys fall through to the next owner
+// while every other key keeps its analyzer.
+func (r *hashRing) lookup(key string, eligible func(models.AnalyzerConfig) bool) models.AnalyzerConfig {
+	if len(r.points) == 0 {
+		return models.AnalyzerConfig{}
+	}
+
+	h := hashKey(key)
+	start := sort.Search(len(r.points), func(i int) bool {
+		return r.points[i].hash >= h
+	})
+
+	checked := make(map[int]bool)
+	for i := 0; i < len(r.points) && len(checked) < len(r.analyzers); i++ {
+		owner := r.points[(start+i)%len(r.points)].owner
+		if checked[owner] {
+			continue
+		}
+		checked[owner] = true
+		if eligible(r.analyzers[owner]) {
+			return r.analyzers[owner]
+		}
+	}
+	return models.AnalyzerConfig{}
+}
+
+// hashKey hashes a string onto the ring. FNV alone clusters short, similar keys
+// such as "analyzer-1#0", so the result is passed through a 64-bit finalizer.
+func hashKey(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+// stickyKey extracts the configured routing key from a message. Supported keys are
+// id, level, source, message and metadata.<name>. It returns false when the message
+// has no value for the key.
+func stickyKey(logMessage models.LogMessage, key string) (string, bool) {
+	var value string
+	switch {
+	case key == "id":
+		value = logMessage.ID
+	case key == "level":
+		value = logMessage.Level
+	case key == "source":
+		value = logMessage.Source
+	case key == "message":
+		value = logMessage.Message
+	case strings.HasPrefix(key, "metadata."):
+		value = logMessage.Metadata[strings.TrimPrefix(key, "metadata.")]
+	}
+	return value, value != ""
+}
+
+// validStickyKey reports whether a hash key names a supported message field
+func validStickyKey(key string) bool {
+	switch key {
+	case "id", "level", "source", "message":
+		return true
+	}
+	return strings.HasPrefix(key, "metadata.") && len(key) > len("metadata.")
+}
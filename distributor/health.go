@@ -0,0 +1,355 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"resolve/models"
+)
+
+// Circuit breaker states
+const (
+	circuitClosed   = "closed"
+	circuitOpen     = "open"
+	circuitHalfOpen = "half-open"
+)
+
+// AnalyzerHealth tracks the health status of an analyzer
+type AnalyzerHealth struct {
+	ID                  string    `json:"id"`
+	Healthy             bool      `json:"healthy"` // outcome of the latest /health probe
+	LastHealthCheck     time.Time `json:"last_health_check"`
+	LastProbeError      string    `json:"last_probe_error,omitempty"`
+	Circuit             string    `json:"circuit"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+	OpenedAt            time.Time `json:"opened_at"`
+	LastSuccess         time.Time `json:"last_success"`
+	LastFailure         time.Time `json:"last_failure"`
+	SuccessCount        int64     `json:"success_count"`
+	FailureCount        int64     `json:"failure_count"`
+	BatchSupported      bool      `json:"batch_supported"` // advertised via the analyzer's /health capabilities
+	trialInFlight       bool
+}
+
+// healthTracker combines active /health probing with a per-analyzer circuit breaker
+// fed by live delivery outcomes
+type healthTracker struct {
+	config    models.HealthCheckConfig
+	mu        sync.Mutex
+	analyzers map[string]*AnalyzerHealth
+}
+
+// newHealthTracker creates a tracker; analyzers start healthy with a closed circuit
+func newHealthTracker(config models.HealthCheckConfig) *healthTracker {
+	return &healthTracker{
+		config:    config,
+		analyzers: make(map[string]*AnalyzerHealth),
+	}
+}
+
+// get returns the health record for an analyzer, creating it on first use
+func (h *healthTracker) get(id string) *AnalyzerHealth {
+	state, ok := h.analyzers[id]
+	if !ok {
+		state = &AnalyzerHealth{
+			ID:      id,
+			Healthy: true,
+			Circuit: circuitClosed,
+		}
+		h.analyzers[id] = state
+	}
+	return state
+}
+
+// available reports whether an analyzer may be selected for new traffic.
+// It has no side effects, so it is safe to call for every candidate.
+func (h *healthTracker) available(id string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	state := h.get(id)
+	if !state.Healthy {
+		return false
+	}
+	switch state.Circuit {
+	case circuitOpen:
+		return time.Since(state.OpenedAt) >= h.openDuration()
+	case circuitHalfOpen:
+		return !state.trialInFlight
+	}
+	return true
+}
+
+// allow is called right before a delivery attempt. An open circuit whose wait has
+// elapsed moves to half-open and lets exactly one trial request through.
+func (h *healthTracker) allow(id string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	state := h.get(id)
+	switch state.Circuit {
+	case circuitOpen:
+		if time.Since(state.OpenedAt) < h.openDuration() {
+			return false
+		}
+		state.Circuit = circuitHalfOpen
+		state.trialInFlight = true
+		log.Printf("[HEALTH] Circuit for analyzer %s is half-open, sending trial request", id)
+		return true
+	case circuitHalfOpen:
+		if state.trialInFlight {
+			return false
+		}
+		state.trialInFlight = true
+		return true
+	}
+	return true
+}
+
+// recordSuccess feeds a successful delivery into the circuit breaker
+func (h *healthTracker) recordSuccess(id string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	state := h.get(id)
+	state.SuccessCount++
+	state.LastSuccess = time.Now()
+	state.ConsecutiveFailures = 0
+	state.trialInFlight = false
+	if state.Circuit != circuitClosed {
+		log.Printf("[HEALTH] Circuit for analyzer %s closed", id)
+		state.Circuit = circuitClosed
+	}
+}
+
+// release ends a delivery attempt that has no outcome, such as one aborted by shutdown,
+// so that a half-open circuit lets the next trial request through
+func (h *healthTracker) release(id string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.get(id).trialInFlight = false
+}
+
+// recordFailure feeds a failed delivery into the circuit breaker
+func (h *healthTracker) recordFailure(id string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	state := h.get(id)
+	state.FailureCount++
+	state.LastFailure = time.Now()
+	state.ConsecutiveFailures++
+	state.trialInFlight = false
+
+	switch state.Circuit {
+	case circuitHalfOpen:
+		state.Circuit = circuitOpen
+		state.OpenedAt = time.Now()
+		log.Printf("[HEALTH] Trial request to analyzer %s failed, circuit re-opened", id)
+	case circuitClosed:
+		if state.ConsecutiveFailures >= h.config.FailureThreshold {
+			state.Circuit = circuitOpen
+			state.OpenedAt = time.Now()
+			log.Printf("[HEALTH] Circuit for analyzer %s opened after %d consecutive failures",
+				id, state.ConsecutiveFailures)
+		}
+	}
+}
+
+// supportsBatch reports whether the analyzer advertised the batch capability
+func (h *healthTracker) supportsBatch(id string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.get(id).BatchSupported
+}
+
+// disableBatch stops batching to an analyzer until its next probe advertises batch support again
+func (h *healthTracker) disableBatch(id string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.get(id).BatchSupported = false
+}
+
+// recordProbe stores the outcome of a /health probe
+func (h *healthTracker) recordProbe(id string, healthy bool, capabilities []string, probeErr error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	state := h.get(id)
+	if state.Healthy != healthy {
+		log.Printf("[HEALTH] Analyzer %s is now %s",
+			id, map[bool]string{true: "healthy", false: "unhealthy"}[healthy])
+	}
+	state.Healthy = healthy
+	state.LastHealthCheck = time.Now()
+	if healthy {
+		state.BatchSupported = false
+		for _, capability := range capabilities {
+			if capability == models.CapabilityBatch {
+				state.BatchSupported = true
+			}
+		}
+	}
+	state.LastProbeError = ""
+	if probeErr != nil {
+		state.LastProbeError = probeErr.Error()
+	}
+}
+
+// snapshot returns a copy of every tracked analyzer's state, sorted by ID
+func (h *healthTracker) snapshot() []AnalyzerHealth {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	states := make([]AnalyzerHealth, 0, len(h.analyzers))
+	for _, state := range h.analyzers {
+		states = append(states, *state)
+	}
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].ID < states[j].ID
+	})
+	return states
+}
+
+// setConfig replaces the circuit breaker settings after a configuration change
+func (h *healthTracker) setConfig(config models.HealthCheckConfig) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.config = config
+}
+
+// forget drops the state of an analyzer that was removed
+func (h *healthTracker) forget(id string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.analyzers, id)
+}
+
+func (h *healthTracker) openDuration() time.Duration {
+	return time.Duration(h.config.OpenDuration) * time.Millisecond
+}
+
+// healthCheckWorker periodically probes every analyzer's health endpoint until the
+// distributor stops
+func (d *DistributorServer) healthCheckWorker() {
+	interval := d.healthCheckInterval()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		d.probeAnalyzers()
+
+		select {
+		case <-d.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		// A reload may have changed the interval
+		if next := d.healthCheckInterval(); next != interval {
+			interval = next
+			ticker.Reset(interval)
+		}
+	}
+}
+
+// healthCheckInterval returns the configured time between probe rounds
+func (d *DistributorServer) healthCheckInterval() time.Duration {
+	return time.Duration(d.current().config.HealthCheck.Interval) * time.Millisecond
+}
+
+// probeAnalyzers probes every analyzer once, concurrently
+func (d *DistributorServer) probeAnalyzers() {
+	// Re-read the configuration every round so analyzers added at runtime are probed
+	config := d.current().config
+	client := &http.Client{
+		Timeout: time.Duration(config.HealthCheck.Timeout) * time.Millisecond,
+	}
+
+	var wg sync.WaitGroup
+	for _, analyzer := range config.Analyzers {
+		wg.Add(1)
+		go func(a models.AnalyzerConfig) {
+			defer wg.Done()
+			healthy, capabilities, err := d.isAnalyzerHealthy(client, a)
+			d.health.recordProbe(a.ID, healthy, capabilities, err)
+		}(analyzer)
+	}
+	wg.Wait()
+}
+
+// isAnalyzerHealthy checks if an analyzer is healthy by calling its health endpoint
+// and returns the capabilities it advertises
+func (d *DistributorServer) isAnalyzerHealthy(client *http.Client, analyzer models.AnalyzerConfig) (bool, []string, error) {
+	resp, err := client.Get(healthURL(analyzer))
+	if err != nil {
+		log.Printf("[HEALTH] Health check failed for analyzer %s: %v", analyzer.ID, err)
+		return false, nil, err
+	}
+	defer resp.Body.Close()
+
+	// Analyzers advertise the request encodings they accept on every response
+	d.peerFor(analyzer.ID).Update(resp, "")
+
+	if resp.StatusCode != http.StatusOK {
+		return false, nil, fmt.Errorf("health endpoint returned status code: %d", resp.StatusCode)
+	}
+
+	// Older analyzers answer with plain text, which simply means no capabilities
+	var body struct {
+		Capabilities []string `json:"capabilities"`
+	}
+	json.NewDecoder(resp.Body).Decode(&body)
+	return true, body.Capabilities, nil
+}
+
+// healthURL returns the health endpoint for an analyzer
+func healthURL(analyzer models.AnalyzerConfig) string {
+	if analyzer.HealthEndpoint != "" {
+		return analyzer.HealthEndpoint
+	}
+	return strings.Replace(analyzer.Endpoint, "/analyze", "/health", 1)
+}
+
+// handleAnalyzerStatus reports probe results and circuit breaker state for every analyzer
+func (d *DistributorServer) handleAnalyzerStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	config := d.current().config
+	analyzers := make([]map[string]interface{}, 0, len(config.Analyzers))
+	states := make(map[string]AnalyzerHealth)
+	for _, state := range d.health.snapshot() {
+		states[state.ID] = state
+	}
+	for _, analyzer := range config.Analyzers {
+		state, ok := states[analyzer.ID]
+		if !ok {
+			state = AnalyzerHealth{ID: analyzer.ID, Healthy: true, Circuit: circuitClosed}
+		}
+		analyzers = append(analyzers, map[string]interface{}{
+			"id":        analyzer.ID,
+			"endpoint":  analyzer.Endpoint,
+			"weight":    analyzer.Weight,
+			"draining":  analyzer.Draining,
+			"available": d.health.available(analyzer.ID),
+			"health":    state,
+		})
+	}
+
+	response := map[string]interface{}{
+		"analyzers": analyzers,
+		"timestamp": time.Now().Format(time.RFC3339),
+	}
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"resolve/models"
+)
+
+// journaledPacket is a log packet accepted in durable ingest mode.
+// It stays in the journal until every message has been delivered or queued.
+type journaledPacket struct {
+	Receipt    uint64           `json:"-"`
+	AcceptedAt time.Time        `json:"accepted_at"`
+	Packet     models.LogPacket `json:"packet"`
+}
+
+// openJournal opens the ingest journal and returns packets left unprocessed by a previous run
+func (d *DistributorServer) openJournal() ([]journaledPacket, error) {
+	journal, entries, err := openDurableLog("JOURNAL", d.config.IngestJournal)
+	if err != nil {
+		return nil, err
+	}
+	d.journal = journal
+
+	unfinished := make([]journaledPacket, 0, len(entries))
+	for _, entry := range entries {
+		var jp journaledPacket
+		if err := json.Unmarshal(entry.Data, &jp); err != nil {
+			log.Printf("[JOURNAL] Skipping unreadable packet %d: %v", entry.Seq, err)
+			continue
+		}
+		jp.Receipt = entry.Seq
+		unfinished = append(unfinished, jp)
+	}
+	return unfinished, nil
+}
+
+// resumeJournaledPackets restarts distribution of packets that were accepted but not finished
+func (d *DistributorServer) resumeJournaledPackets(packets []journaledPacket) {
+	for _, jp := range packets {
+		log.Printf("[JOURNAL] Resuming packet %s (receipt %d, accepted %s)",
+			jp.Packet.PacketID, jp.Receipt, jp.AcceptedAt.Format(time.RFC3339))
+		d.work.add()
+		go func(jp journaledPacket) {
+			defer d.work.done()
+			d.processJournaledPacket(jp)
+		}(jp)
+	}
+}
+
+// writeReceipt acknowledges a journaled packet with its receipt
+func writeReceipt(w http.ResponseWriter, packet models.LogPacket, receipt uint64) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	response := map[string]interface{}{
+		"status":    "accepted",
+		"packet_id": packet.PacketID,
+		"receipt":   receipt,
+		"messages":  len(packet.Messages),
+		"timestamp": time.Now().Format(time.RFC3339),
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// journalPacket writes a packet to the ingest journal; the caller starts distributing it
+func (d *DistributorServer) journalPacket(packet models.LogPacket) (journaledPacket, error) {
+	jp := journaledPacket{
+		AcceptedAt: time.Now(),
+		Packet:     packet,
+	}
+
+	receipt, err := d.journal.add(jp)
+	if err != nil {
+		log.Printf("[JOURNAL] Failed to journal packet %s: %v", packet.PacketID, err)
+		return jp, err
+	}
+	jp.Receipt = receipt
+	return jp, nil
+}
+
+// processJournaledPacket distributes a journaled packet and marks it done in the journal.
+// A packet with messages that were neither delivered nor persisted in the retry queue
+// stays in the journal and is distributed again on the next start. The caller registers
+// the work with d.work.
+func (d *DistributorServer) processJournaledPacket(jp journaledPacket) {
+	if err := d.distributeLogMessagesParallel(jp.Packet.Messages); err != nil {
+		log.Printf("[JOURNAL] Keeping packet %s (receipt %d) in the journal: %v",
+			jp.Packet.PacketID, jp.Receipt, err)
+		return
+	}
+
+	if err := d.journal.ack(jp.Receipt); err != nil {
+		log.Printf("[JOURNAL] Failed to mark packet %s (receipt %d) as processed: %v",
+			jp.Packet.PacketID, jp.Receipt, err)
+	}
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"resolve/models"
+)
+
+// Member is an analyzer that registered itself with the distributor
+type Member struct {
+	models.MemberRegistration
+	Static        bool      `json:"static"` // shadowed by a statically configured analyzer with the same ID
+	RegisteredAt  time.Time `json:"registered_at"`
+	LastHeartbeat time.Time `json:"last_heartbeat"`
+	draining      bool
+}
+
+// memberRegistry tracks self-registered analyzers and their heartbeats
+type memberRegistry struct {
+	mu      sync.Mutex
+	members map[string]*Member
+}
+
+func newMemberRegistry() *memberRegistry {
+	return &memberRegistry{members: make(map[string]*Member)}
+}
+
+// register adds or replaces a member, keeping its drain state across re-registration
+func (m *memberRegistry) register(reg models.MemberRegistration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	member := &Member{MemberRegistration: reg, RegisteredAt: now, LastHeartbeat: now}
+	if previous, ok := m.members[reg.ID]; ok {
+		member.draining = previous.draining
+	}
+	m.members[reg.ID] = member
+}
+
+// heartbeat records that a member is alive. It reports false for unknown members,
+// which tells the analyzer to register again.
+func (m *memberRegistry) heartbeat(id string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	member, ok := m.members[id]
+	if ok {
+		member.LastHeartbeat = time.Now()
+	}
+	return ok
+}
+
+// remove deletes a member and reports whether it was registered
+func (m *memberRegistry) remove(id string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, ok := m.members[id]
+	delete(m.members, id)
+	return ok
+}
+
+// change applies an admin change to a member's analyzer settings
+func (m *memberRegistry) change(id string, change func(*models.AnalyzerConfig)) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	member, ok := m.members[id]
+	if !ok {
+		return false
+	}
+	analyzer := member.analyzer()
+	change(&analyzer)
+	member.Weight = analyzer.Weight
+	member.draining = analyzer.Draining
+	return true
+}
+
+// expire removes members whose last heartbeat is older than timeout and returns their IDs
+func (m *memberRegistry) expire(timeout time.Duration) []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var expired []string
+	for id, member := range m.members {
+		if time.Since(member.LastHeartbeat) > timeout {
+			delete(m.members, id)
+			expired = append(expired, id)
+		}
+	}
+	return expired
+}
+
+// list returns a copy of every member, sorted by ID
+func (m *memberRegistry) list() []Member {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	members := make([]Member, 0, len(m.members))
+	for _, member := range m.members {
+		members = append(members, *member)
+	}
+	sort.Slice(members, func(i, j int) bool {
+		return members[i].ID < members[j].ID
+	})
+	return members
+}
+
+// analyzer returns the analyzer configuration a member is routed with
+func (member Member) analyzer() models.AnalyzerConfig {
+	weight := member.Weight
+	if weight <= 0 {
+		weight = float64(member.Capacity)
+	}
+	if weight <= 0 {
+		weight = 1
+	}
+	return models.AnalyzerConfig{
+		ID:             member.ID,
+		Weight:         weight,
+		Endpoint:       member.Endpoint,
+		HealthEndpoint: member.HealthEndpoint,
+		Timeout:        member.Timeout,
+		RetryCount:     member.RetryCount,
+		Draining:       member.draining,
+	}
+}
+
+// mergeMembers adds self-registered analyzers to a static configuration. Statically
+// configured analyzers win when both use the same ID.
+func mergeMembers(static models.DistributorConfig, members []Member) models.DistributorConfig {
+	config := cloneConfig(static)
+	for _, member := range members {
+		if findAnalyzer(&config, member.ID) >= 0 {
+			continue
+		}
+		config.Analyzers = append(config.Analyzers, member.analyzer())
+		for _, group := range member.Groups {
+			addGroupMember(&config, group, member.ID)
+		}
+	}
+	return config
+}
+
+// refreshMembers rebuilds the configuration in effect after membership changed
+func (d *DistributorServer) refreshMembers() {
+	d.configMu.Lock()
+	defer d.configMu.Unlock()
+
+	if err := d.applyConfig(d.current().static); err != nil {
+		log.Printf("[MEMBERS] Failed to apply membership change: %v", err)
+	}
+}
+
+// membershipWorker expires members that stopped sending heartbeats
+func (d *DistributorServer) membershipWorker() {
+	for {
+		timeout := time.Duration(d.current().config.Membership.HeartbeatTimeout) * time.Millisecond
+		time.Sleep(timeout / 3)
+
+		expired := d.members.expire(timeout)
+		if len(expired) == 0 {
+			continue
+		}
+		for _, id := range expired {
+			log.Printf("[MEMBERS] Analyzer %s missed its heartbeats for %v, removing it", id, timeout)
+		}
+		d.refreshMembers()
+	}
+}
+
+// handleMembers lists members (GET) or registers an analyzer (POST)
+func (d *DistributorServer) handleMembers(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case "GET":
+		members := d.members.list()
+		for i := range members {
+			members[i].Static = d.isStatic(members[i].ID)
+		}
+		response := map[string]interface{}{
+			"members":   members,
+			"timestamp": time.Now().Format(time.RFC3339),
+		}
+		json.NewEncoder(w).Encode(response)
+	case "POST":
+		var reg models.MemberRegistration
+		if err := json.NewDecoder(r.Body).Decode(&reg); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		if reg.ID == "" || reg.Endpoint == "" {
+			http.Error(w, "Member id and endpoint are required", http.StatusBadRequest)
+			return
+		}
+		if reg.Weight < 0 || reg.Capacity < 0 {
+			http.Error(w, "Weight and capacity must not be negative", http.StatusBadRequest)
+			return
+		}
+		static := d.current().static
+		for _, group := range reg.Groups {
+			if !hasGroup(&static, group) {
+				http.Error(w, fmt.Sprintf("Unknown analyzer group %s", group), http.StatusBadRequest)
+				return
+			}
+		}
+
+		d.members.register(reg)
+		d.refreshMembers()
+
+		isStatic := d.isStatic(reg.ID)
+		log.Printf("[MEMBERS] Analyzer %s registered at %s (static: %v)", reg.ID, reg.Endpoint, isStatic)
+		response := map[string]interface{}{
+			"status":            "registered",
+			"id":                reg.ID,
+			"static":            isStatic,
+			"heartbeat_timeout": d.current().config.Membership.HeartbeatTimeout,
+			"timestamp":         time.Now().Format(time.RFC3339),
+		}
+		json.NewEncoder(w).Encode(response)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleMember serves heartbeats and deregistration:
+//
+//	POST   /members/{id}/heartbeat  keep a member alive; 404 means register again
+//	DELETE /members/{id}            deregister a member
+func (d *DistributorServer) handleMember(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/members/"), "/")
+	parts := strings.Split(path, "/")
+	id := parts[0]
+
+	switch {
+	case len(parts) == 2 && parts[1] == "heartbeat" && r.Method == "POST":
+		if !d.members.heartbeat(id) {
+			http.Error(w, fmt.Sprintf("Member %s not registered", id), http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case len(parts) == 1 && r.Method == "DELETE":
+		if !d.members.remove(id) {
+			http.Error(w, fmt.Sprintf("Member %s not registered", id), http.StatusNotFound)
+			return
+		}
+		d.refreshMembers()
+		log.Printf("[MEMBERS] Analyzer %s deregistered", id)
+		w.WriteHeader(http.StatusNoContent)
+	case len(parts) == 1, len(parts) == 2 && parts[1] == "heartbeat":
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// isStatic reports whether an analyzer ID is statically configured
+func (d *DistributorServer) isStatic(id string) bool {
+	static := d.current().static
+	return findAnalyzer(&static, id) >= 0
+}
+
+// hasGroup reports whether a named routing group is configured
+func hasGroup(config *models.DistributorConfig, name string) bool {
+	for _, group := range config.Routing.Groups {
+		if group.Name == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"strconv"
+	"time"
+
+	"resolve/metrics"
+)
+
+// distributorMetrics holds the series exposed on the distributor's /metrics endpoint
+type distributorMetrics struct {
+	registry *metrics.Registry
+
+	packetsReceived  *metrics.CounterVec
+	messagesReceived *metrics.CounterVec
+	deliveries       *metrics.CounterVec
+	deliveryDuration *metrics.HistogramVec
+	retries          *metrics.CounterVec
+	queued           *metrics.CounterVec
+	deadLettered     *metrics.CounterVec
+	rawParseErrors   *metrics.CounterVec
+
+	syslogReceived    *metrics.CounterVec
+	syslogParseErrors *metrics.CounterVec
+	syslogDropped     *metrics.CounterVec
+
+	otlpRecords *metrics.CounterVec
+
+	streamLines        *metrics.CounterVec
+	streamBackpressure *metrics.CounterVec
+}
+
+// newDistributorMetrics registers the distributor's metrics. Queue depth, retries in
+// flight, dead letters and journaled packets are read from the server at scrape time.
+func newDistributorMetrics(d *DistributorServer) *distributorMetrics {
+	r := metrics.NewRegistry()
+	m := &distributorMetrics{
+		registry: r,
+		packetsReceived: r.Counter("distributor_packets_received_total",
+			"Log packets received on /logs, /logs/stream, /v1/logs and from the syslog listeners."),
+		messagesReceived: r.Counter("distributor_messages_received_total",
+			"Log messages received on /logs, /logs/stream, /v1/logs and from the syslog listeners."),
+		deliveries: r.Counter("distributor_deliveries_total",
+			"Delivery attempts to analyzers by status code, or \"error\" for network errors.", "analyzer", "class", "code"),
+		deliveryDuration: r.Histogram("distributor_delivery_duration_seconds",
+			"Latency of delivery attempts to analyzers.", nil, "analyzer"),
+		retries: r.Counter("distributor_retries_total",
+			"Delivery retries, either inline before queuing or from the retry queue.", "source"),
+		queued: r.Counter("distributor_messages_queued_total",
+			"Messages added to the retry queue."),
+		deadLettered: r.Counter("distributor_dead_lettered_total",
+			"Messages moved from the retry queue to the dead-letter store.", "reason"),
+		rawParseErrors: r.Counter("distributor_raw_parse_errors_total",
+			"Plain-text log lines the raw_ingest parser could not parse."),
+		syslogReceived: r.Counter("distributor_syslog_messages_total",
+			"Syslog messages received.", "transport"),
+		syslogParseErrors: r.Counter("distributor_syslog_parse_errors_total",
+			"Syslog messages that were neither RFC 5424 nor RFC 3164."),
+		syslogDropped: r.Counter("distributor_syslog_dropped_total",
+			"Syslog messages dropped because the queue was full (UDP only) or the packet could not be journaled.", "reason"),
+		otlpRecords: r.Counter("distributor_otlp_log_records_total",
+			"OTLP log records received on /v1/logs.", "encoding"),
+		streamLines: r.Counter("distributor_stream_lines_total",
+			"Lines of NDJSON streams on /logs/stream, accepted or rejected.", "result"),
+		streamBackpressure: r.Counter("distributor_stream_backpressure_seconds_total",
+			"Time NDJSON streams spent paused waiting for their packets to be distributed."),
+	}
+
+	r.GaugeFunc("distributor_queue_depth", "Messages waiting in the retry queue.", func() float64 {
+		d.queueMu.Lock()
+		defer d.queueMu.Unlock()
+		return float64(len(d.queue))
+	})
+	r.GaugeFunc("distributor_retries_in_flight", "Queued messages currently being redelivered.", func() float64 {
+		return float64(len(d.retryInFlight))
+	})
+	r.GaugeFunc("distributor_dead_letters", "Messages held in the dead-letter store.", func() float64 {
+		return float64(d.deadLetters.count())
+	})
+	r.GaugeFunc("distributor_streams_active", "NDJSON streams being read on /logs/stream.", func() float64 {
+		return float64(d.streams.Load())
+	})
+	r.GaugeFunc("distributor_journaled_packets", "Accepted packets not yet fully distributed.", func() float64 {
+		return float64(d.journal.pending())
+	})
+	return m
+}
+
+// observeDelivery records the outcome and latency of one delivery attempt
+func (m *distributorMetrics) observeDelivery(analyzerID string, class trafficClass, statusCode int, duration time.Duration, err error) {
+	code := "error"
+	if err == nil {
+		code = strconv.Itoa(statusCode)
+	}
+	m.deliveries.With(analyzerID, class.String(), code).Inc()
+	if duration > 0 {
+		m.deliveryDuration.With(analyzerID).ObserveDuration(duration)
+	}
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"resolve/compression"
+	"resolve/models"
+	"resolve/parser"
+)
+
+// maxOTLPRequestSize bounds the body of an OTLP export request
+const maxOTLPRequestSize = 16 * 1024 * 1024
+
+// OTLP/HTTP content types
+const (
+	otlpContentTypeJSON     = "application/json"
+	otlpContentTypeProtobuf = "application/x-protobuf"
+)
+
+// otlpLogsRequest is an OTLP ExportLogsServiceRequest. The JSON tags follow the OTLP
+// JSON encoding; protobuf requests are decoded into the same types.
+type otlpLogsRequest struct {
+	ResourceLogs []otlpResourceLogs `json:"resourceLogs"`
+}
+
+type otlpResourceLogs struct {
+	Resource  otlpResource    `json:"resource"`
+	ScopeLogs []otlpScopeLogs `json:"scopeLogs"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeLogs struct {
+	Scope      otlpScope       `json:"scope"`
+	LogRecords []otlpLogRecord `json:"logRecords"`
+}
+
+type otlpScope struct {
+	Name       string         `json:"name"`
+	Version    string         `json:"version"`
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpLogRecord struct {
+	TimeUnixNano         otlpInt        `json:"timeUnixNano"`
+	ObservedTimeUnixNano otlpInt        `json:"observedTimeUnixNano"`
+	SeverityNumber       otlpSeverity   `json:"severityNumber"`
+	SeverityText         string         `json:"severityText"`
+	Body                 otlpAnyValue   `json:"body"`
+	Attributes           []otlpKeyValue `json:"attributes"`
+	Flags                uint32         `json:"flags"`
+	TraceID              string         `json:"traceId"` // hex, as in the JSON encoding
+	SpanID               string         `json:"spanId"`  // hex, as in the JSON encoding
+	EventName            string         `json:"eventName"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+// otlpAnyValue holds one of its fields, or none for an empty value
+type otlpAnyValue struct {
+	StringValue *string        `json:"stringValue,omitempty"`
+	BoolValue   *bool          `json:"boolValue,omitempty"`
+	IntValue    *otlpInt       `json:"intValue,omitempty"`
+	DoubleValue *float64       `json:"doubleValue,omitempty"`
+	ArrayValue  *otlpArray     `json:"arrayValue,omitempty"`
+	KvlistValue *otlpKeyValues `json:"kvlistValue,omitempty"`
+	BytesValue  []byte         `json:"bytesValue,omitempty"`
+}
+
+type otlpArray struct {
+	Values []otlpAnyValue `json:"values"`
+}
+
+type otlpKeyValues struct {
+	Values []otlpKeyValue `json:"values"`
+}
+
+// otlpInt is a 64-bit integer, which the OTLP JSON encoding writes as a string
+type otlpInt int64
+
+// UnmarshalJSON accepts the integer as a string or a number
+func (i *otlpInt) UnmarshalJSON(data []byte) error {
+	text := string(data)
+	if unquoted, err := strconv.Unquote(text); err == nil {
+		text = unquoted
+	}
+	// Timestamps in nanoseconds are unsigned and may not fit an int64
+	if n, err := strconv.ParseInt(text, 10, 64); err == nil {
+		*i = otlpInt(n)
+		return nil
+	}
+	n, err := strconv.ParseUint(text, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid OTLP integer %s", data)
+	}
+	*i = otlpInt(n)
+	return nil
+}
+
+// otlpSeverity is a SeverityNumber from 1 (TRACE) to 24 (FATAL4), 0 when unspecified
+type otlpSeverity int
+
+// otlpSeverityLevels name the severities in steps of four, from TRACE (1-4) to FATAL (21-24)
+var otlpSeverityLevels = []string{"TRACE", "DEBUG", "INFO", "WARN", "ERROR", "FATAL"}
+
+// UnmarshalJSON accepts the severity as a number or an enum name
+func (s *otlpSeverity) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		// Enum names are SEVERITY_NUMBER_INFO, SEVERITY_NUMBER_INFO2 and so on
+		name = strings.TrimPrefix(name, "SEVERITY_NUMBER_")
+		if name == "UNSPECIFIED" {
+			*s = 0
+			return nil
+		}
+		for i, level := range otlpSeverityLevels {
+			for step := 1; step <= 4; step++ {
+				suffix := ""
+				if step > 1 {
+					suffix = strconv.Itoa(step)
+				}
+				if name == level+suffix {
+					*s = otlpSeverity(i*4 + step)
+					return nil
+				}
+			}
+		}
+		return fmt.Errorf("unknown OTLP severity %q", name)
+	}
+	var n int
+	if err := json.Unmarshal(data, &n); err != nil {
+		return fmt.Errorf("invalid OTLP severity %s", data)
+	}
+	*s = otlpSeverity(n)
+	return nil
+}
+
+// level maps the severity onto DEBUG, INFO, WARN, ERROR and FATAL, falling back to the
+// severity text when the number is unspecified
+func (s otlpSeverity) level(text string) string {
+	switch {
+	case s >= 21:
+		return "FATAL"
+	case s >= 17:
+		return "ERROR"
+	case s >= 13:
+		return "WARN"
+	case s >= 9:
+		return "INFO"
+	case s >= 1:
+		return "DEBUG"
+	case text != "":
+		return parser.NormalizeLevel(text)
+	}
+	return "INFO"
+}
+
+// String renders a value as text: strings as they are, bytes in base64, and arrays
+// and key/value lists as JSON
+func (v otlpAnyValue) String() string {
+	switch {
+	case v.StringValue != nil:
+		return *v.StringValue
+	case v.ArrayValue != nil, v.KvlistValue != nil:
+		data, _ := json.Marshal(v.plain())
+		return string(data)
+	}
+	if plain := v.plain(); plain != nil {
+		return fmt.Sprint(plain)
+	}
+	return ""
+}
+
+// plain converts a value to the Go value it holds, for rendering as JSON
+func (v otlpAnyValue) plain() interface{} {
+	switch {
+	case v.StringValue != nil:
+		return *v.StringValue
+	case v.BoolValue != nil:
+		return *v.BoolValue
+	case v.IntValue != nil:
+		return int64(*v.IntValue)
+	case v.DoubleValue != nil:
+		return *v.DoubleValue
+	case v.BytesValue != nil:
+		return base64.StdEncoding.EncodeToString(v.BytesValue)
+	case v.ArrayValue != nil:
+		values := make([]interface{}, len(v.ArrayValue.Values))
+		for i, value := range v.ArrayValue.Values {
+			values[i] = value.plain()
+		}
+		return values
+	case v.KvlistValue != nil:
+		values := make(map[string]interface{}, len(v.KvlistValue.Values))
+		for _, kv := range v.KvlistValue.Values {
+			values[kv.Key] = kv.Value.plain()
+		}
+		return values
+	}
+	return nil
+}
+
+// handleOTLPLogs receives an OTLP/HTTP logs export, in JSON or protobuf, and distributes
+// its log records as one packet
+func (d *DistributorServer) handleOTLPLogs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// A draining distributor takes no new work; OTLP exporters retry on 503
+	if d.draining() {
+		http.Error(w, "Distributor is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+
+	contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if contentType != otlpContentTypeJSON && contentType != otlpContentTypeProtobuf {
+		http.Error(w, "Content-Type must be application/json or application/x-protobuf", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	// OTLP exporters typically send gzip bodies
+	if !d.decodeRequestBody(w, r, d.current().config.Compression.MaxDecodedSize) {
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxOTLPRequestSize))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) || errors.Is(err, compression.ErrTooLarge) {
+			http.Error(w, "OTLP request too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "Failed to read OTLP request", http.StatusBadRequest)
+		return
+	}
+
+	var request otlpLogsRequest
+	encoding := "json"
+	if contentType == otlpContentTypeProtobuf {
+		encoding = "protobuf"
+		err = decodeOTLPLogsRequest(body, &request)
+	} else {
+		err = json.Unmarshal(body, &request)
+	}
+	if err != nil {
+		log.Printf("[OTLP] Error decoding logs request: %v", err)
+		http.Error(w, "Invalid OTLP logs request", http.StatusBadRequest)
+		return
+	}
+
+	packet := newOTLPPacket(request, time.Now())
+	d.metrics.otlpRecords.With(encoding).Add(float64(len(packet.Messages)))
+	if len(packet.Messages) > 0 {
+		if _, err := d.ingestPacket(packet, nil); err != nil {
+			http.Error(w, "Failed to persist log records", http.StatusServiceUnavailable)
+			return
+		}
+	}
+
+	// An empty ExportLogsServiceResponse reports full success
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+	if contentType == otlpContentTypeJSON {
+		w.Write([]byte("{}"))
+	}
+}
+
+// newOTLPPacket turns the log records of an export request into a packet. The resource's
+// service.name is the source; resource attributes are added to the metadata as
+// resource.<key>, the scope as scope.name, scope.version and scope.<key>, and record
+// attributes under their own keys.
+func newOTLPPacket(request otlpLogsRequest, received time.Time) models.LogPacket {
+	packet := models.LogPacket{
+		PacketID:  fmt.Sprintf("otlp-%d", received.UnixNano()),
+		AgentID:   "otlp",
+		Timestamp: received,
+	}
+
+	for _, resourceLogs := range request.ResourceLogs {
+		source := "otlp"
+		resource := make(map[string]string)
+		for _, kv := range resourceLogs.Resource.Attributes {
+			resource["resource."+kv.Key] = kv.Value.String()
+			if kv.Key == "service.name" && kv.Value.String() != "" {
+				source = kv.Value.String()
+			}
+		}
+
+		for _, scopeLogs := range resourceLogs.ScopeLogs {
+			scope := make(map[string]string)
+			if scopeLogs.Scope.Name != "" {
+				scope["scope.name"] = scopeLogs.Scope.Name
+			}
+			if scopeLogs.Scope.Version != "" {
+				scope["scope.version"] = scopeLogs.Scope.Version
+			}
+			for _, kv := range scopeLogs.Scope.Attributes {
+				scope["scope."+kv.Key] = kv.Value.String()
+			}
+
+			for _, record := range scopeLogs.LogRecords {
+				msg := models.LogMessage{
+					ID:        fmt.Sprintf("%s-%d", packet.PacketID, len(packet.Messages)+1),
+					Timestamp: received,
+					Level:     record.SeverityNumber.level(record.SeverityText),
+					Source:    source,
+					Message:   record.Body.String(),
+					Metadata:  make(map[string]string),
+				}
+				if record.TimeUnixNano != 0 {
+					msg.Timestamp = time.Unix(0, int64(record.TimeUnixNano)).UTC()
+				} else if record.ObservedTimeUnixNano != 0 {
+					msg.Timestamp = time.Unix(0, int64(record.ObservedTimeUnixNano)).UTC()
+				}
+
+				for key, value := range resource {
+					msg.Metadata[key] = value
+				}
+				for key, value := range scope {
+					msg.Metadata[key] = value
+				}
+				for _, kv := range record.Attributes {
+					msg.Metadata[kv.Key] = kv.Value.String()
+				}
+				if record.TraceID != "" {
+					msg.Metadata["trace_id"] = otlpID(record.TraceID)
+				}
+				if record.SpanID != "" {
+					msg.Metadata["span_id"] = otlpID(record.SpanID)
+				}
+				if record.Flags != 0 {
+					msg.Metadata["trace_flags"] = fmt.Sprintf("%02x", record.Flags&0xff)
+				}
+				if record.SeverityText != "" {
+					msg.Metadata["severity_text"] = record.SeverityText
+				}
+				if record.EventName != "" {
+					msg.Metadata["event_name"] = record.EventName
+				}
+				packet.Messages = append(packet.Messages, msg)
+			}
+		}
+	}
+	return packet
+}
+
+// otlpID normalizes a trace or span ID to lower-case hex. OTLP JSON uses hex, but
+// generic protobuf JSON encoders write the ID bytes in base64.
+func otlpID(id string) string {
+	if _, err := hex.DecodeString(id); err == nil {
+		return strings.ToLower(id)
+	}
+	if data, err := base64.StdEncoding.DecodeString(id); err == nil && (len(data) == 16 || len(data) == 8) {
+		return hex.EncodeToString(data)
+	}
+	return id
+}
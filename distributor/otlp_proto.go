@@ -0,0 +1,314 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Protobuf wire types used by OTLP
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+var errProtoTruncated = errors.New("truncated protobuf message")
+
+// protoField is one field of a protobuf message as it appears on the wire
+type protoField struct {
+	number int
+	wire   int
+	value  uint64 // varint, fixed64 and fixed32 fields
+	data   []byte // length-delimited fields: strings, bytes and nested messages
+}
+
+// readProto calls each for every field of a protobuf message, in wire order
+func readProto(data []byte, each func(f protoField) error) error {
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 {
+			return errProtoTruncated
+		}
+		data = data[n:]
+
+		f := protoField{number: int(key >> 3), wire: int(key & 7)}
+		if f.number == 0 {
+			return fmt.Errorf("invalid protobuf field number 0")
+		}
+		switch f.wire {
+		case wireVarint:
+			if f.value, n = binary.Uvarint(data); n <= 0 {
+				return errProtoTruncated
+			}
+			data = data[n:]
+		case wireFixed64:
+			if len(data) < 8 {
+				return errProtoTruncated
+			}
+			f.value = binary.LittleEndian.Uint64(data)
+			data = data[8:]
+		case wireFixed32:
+			if len(data) < 4 {
+				return errProtoTruncated
+			}
+			f.value = uint64(binary.LittleEndian.Uint32(data))
+			data = data[4:]
+		case wireBytes:
+			length, n := binary.Uvarint(data)
+			if n <= 0 || length > uint64(len(data)-n) {
+				return errProtoTruncated
+			}
+			f.data = data[n : n+int(length)]
+			data = data[n+int(length):]
+		default:
+			return fmt.Errorf("unsupported protobuf wire type %d", f.wire)
+		}
+
+		if err := each(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// expect checks that a field the decoder knows has the wire type its schema gives it
+func (f protoField) expect(wire int) error {
+	if f.wire != wire {
+		return fmt.Errorf("protobuf field %d has wire type %d, expected %d", f.number, f.wire, wire)
+	}
+	return nil
+}
+
+// decodeOTLPLogsRequest decodes a protobuf ExportLogsServiceRequest. Fields it does not
+// know are skipped, as protobuf requires.
+func decodeOTLPLogsRequest(data []byte, request *otlpLogsRequest) error {
+	return readProto(data, func(f protoField) error {
+		if f.number != 1 { // resource_logs
+			return nil
+		}
+		if err := f.expect(wireBytes); err != nil {
+			return err
+		}
+		var resourceLogs otlpResourceLogs
+		if err := decodeOTLPResourceLogs(f.data, &resourceLogs); err != nil {
+			return err
+		}
+		request.ResourceLogs = append(request.ResourceLogs, resourceLogs)
+		return nil
+	})
+}
+
+// decodeOTLPResourceLogs decodes a ResourceLogs message
+func decodeOTLPResourceLogs(data []byte, resourceLogs *otlpResourceLogs) error {
+	return readProto(data, func(f protoField) error {
+		switch f.number {
+		case 1: // resource
+			if err := f.expect(wireBytes); err != nil {
+				return err
+			}
+			return readProto(f.data, func(f protoField) error {
+				if f.number != 1 { // attributes
+					return nil
+				}
+				return appendOTLPKeyValue(f, &resourceLogs.Resource.Attributes)
+			})
+		case 2: // scope_logs
+			if err := f.expect(wireBytes); err != nil {
+				return err
+			}
+			var scopeLogs otlpScopeLogs
+			if err := decodeOTLPScopeLogs(f.data, &scopeLogs); err != nil {
+				return err
+			}
+			resourceLogs.ScopeLogs = append(resourceLogs.ScopeLogs, scopeLogs)
+		}
+		return nil
+	})
+}
+
+// decodeOTLPScopeLogs decodes a ScopeLogs message
+func decodeOTLPScopeLogs(data []byte, scopeLogs *otlpScopeLogs) error {
+	return readProto(data, func(f protoField) error {
+		switch f.number {
+		case 1: // scope
+			if err := f.expect(wireBytes); err != nil {
+				return err
+			}
+			return decodeOTLPScope(f.data, &scopeLogs.Scope)
+		case 2: // log_records
+			if err := f.expect(wireBytes); err != nil {
+				return err
+			}
+			var record otlpLogRecord
+			if err := decodeOTLPLogRecord(f.data, &record); err != nil {
+				return err
+			}
+			scopeLogs.LogRecords = append(scopeLogs.LogRecords, record)
+		}
+		return nil
+	})
+}
+
+// decodeOTLPScope decodes an InstrumentationScope message
+func decodeOTLPScope(data []byte, scope *otlpScope) error {
+	return readProto(data, func(f protoField) error {
+		switch f.number {
+		case 1: // name
+			if err := f.expect(wireBytes); err != nil {
+				return err
+			}
+			scope.Name = string(f.data)
+		case 2: // version
+			if err := f.expect(wireBytes); err != nil {
+				return err
+			}
+			scope.Version = string(f.data)
+		case 3: // attributes
+			return appendOTLPKeyValue(f, &scope.Attributes)
+		}
+		return nil
+	})
+}
+
+// decodeOTLPLogRecord decodes a LogRecord message. Trace and span IDs are hex-encoded
+// as in the JSON encoding.
+func decodeOTLPLogRecord(data []byte, record *otlpLogRecord) error {
+	return readProto(data, func(f protoField) error {
+		var err error
+		switch f.number {
+		case 1: // time_unix_nano
+			if err = f.expect(wireFixed64); err == nil {
+				record.TimeUnixNano = otlpInt(f.value)
+			}
+		case 11: // observed_time_unix_nano
+			if err = f.expect(wireFixed64); err == nil {
+				record.ObservedTimeUnixNano = otlpInt(f.value)
+			}
+		case 2: // severity_number
+			if err = f.expect(wireVarint); err == nil {
+				record.SeverityNumber = otlpSeverity(f.value)
+			}
+		case 3: // severity_text
+			if err = f.expect(wireBytes); err == nil {
+				record.SeverityText = string(f.data)
+			}
+		case 5: // body
+			if err = f.expect(wireBytes); err == nil {
+				err = decodeOTLPAnyValue(f.data, &record.Body)
+			}
+		case 6: // attributes
+			err = appendOTLPKeyValue(f, &record.Attributes)
+		case 8: // flags
+			if err = f.expect(wireFixed32); err == nil {
+				record.Flags = uint32(f.value)
+			}
+		case 9: // trace_id
+			if err = f.expect(wireBytes); err == nil {
+				record.TraceID = hex.EncodeToString(f.data)
+			}
+		case 10: // span_id
+			if err = f.expect(wireBytes); err == nil {
+				record.SpanID = hex.EncodeToString(f.data)
+			}
+		case 12: // event_name
+			if err = f.expect(wireBytes); err == nil {
+				record.EventName = string(f.data)
+			}
+		}
+		return err
+	})
+}
+
+// appendOTLPKeyValue decodes a KeyValue field and appends it to list
+func appendOTLPKeyValue(f protoField, list *[]otlpKeyValue) error {
+	if err := f.expect(wireBytes); err != nil {
+		return err
+	}
+	var kv otlpKeyValue
+	err := readProto(f.data, func(f protoField) error {
+		switch f.number {
+		case 1: // key
+			if err := f.expect(wireBytes); err != nil {
+				return err
+			}
+			kv.Key = string(f.data)
+		case 2: // value
+			if err := f.expect(wireBytes); err != nil {
+				return err
+			}
+			return decodeOTLPAnyValue(f.data, &kv.Value)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	*list = append(*list, kv)
+	return nil
+}
+
+// decodeOTLPAnyValue decodes an AnyValue message
+func decodeOTLPAnyValue(data []byte, value *otlpAnyValue) error {
+	return readProto(data, func(f protoField) error {
+		var err error
+		switch f.number {
+		case 1: // string_value
+			if err = f.expect(wireBytes); err == nil {
+				s := string(f.data)
+				value.StringValue = &s
+			}
+		case 2: // bool_value
+			if err = f.expect(wireVarint); err == nil {
+				b := f.value != 0
+				value.BoolValue = &b
+			}
+		case 3: // int_value
+			if err = f.expect(wireVarint); err == nil {
+				i := otlpInt(f.value)
+				value.IntValue = &i
+			}
+		case 4: // double_value
+			if err = f.expect(wireFixed64); err == nil {
+				d := math.Float64frombits(f.value)
+				value.DoubleValue = &d
+			}
+		case 5: // array_value
+			if err = f.expect(wireBytes); err == nil {
+				value.ArrayValue = &otlpArray{}
+				err = readProto(f.data, func(f protoField) error {
+					if f.number != 1 { // values
+						return nil
+					}
+					if err := f.expect(wireBytes); err != nil {
+						return err
+					}
+					var element otlpAnyValue
+					if err := decodeOTLPAnyValue(f.data, &element); err != nil {
+						return err
+					}
+					value.ArrayValue.Values = append(value.ArrayValue.Values, element)
+					return nil
+				})
+			}
+		case 6: // kvlist_value
+			if err = f.expect(wireBytes); err == nil {
+				value.KvlistValue = &otlpKeyValues{}
+				err = readProto(f.data, func(f protoField) error {
+					if f.number != 1 { // values
+						return nil
+					}
+					return appendOTLPKeyValue(f, &value.KvlistValue.Values)
+				})
+			}
+		case 7: // bytes_value
+			if err = f.expect(wireBytes); err == nil {
+				value.BytesValue = append([]byte{}, f.data...)
+			}
+		}
+		return err
+	})
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"resolve/models"
+	"resolve/parser"
+)
+
+// maxRawLineSize bounds a single plain-text log line
+const maxRawLineSize = 1024 * 1024
+
+// isPlainText reports whether a request body holds raw log lines rather than a JSON packet
+func isPlainText(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == "text/plain"
+}
+
+// readRawPacket turns a plain-text body into a packet with one message per non-empty
+// line, parsed with the raw_ingest parser. With multiline settings the lines of one event
+// become one message; the body is complete, so its last event ends with it. The agent_id
+// and source query parameters name the sender and the default source. Lines the parser
+// rejects are kept as they are, with a parse_error metadata field.
+func (d *DistributorServer) readRawPacket(r *http.Request) (models.LogPacket, error) {
+	live := d.current()
+	received := time.Now()
+
+	agentID := r.URL.Query().Get("agent_id")
+	if agentID == "" {
+		agentID = "raw"
+	}
+	source := r.URL.Query().Get("source")
+	if source == "" {
+		source = live.config.RawIngest.Source
+	}
+
+	packet := models.LogPacket{
+		PacketID:  fmt.Sprintf("%s-%d", agentID, received.UnixNano()),
+		AgentID:   agentID,
+		Timestamp: received,
+	}
+
+	var lines []string
+	scanner := bufio.NewScanner(r.Body)
+	scanner.Buffer(make([]byte, 64*1024), maxRawLineSize)
+	for scanner.Scan() {
+		lines = append(lines, strings.TrimRight(scanner.Text(), "\r"))
+	}
+	if err := scanner.Err(); err != nil {
+		return models.LogPacket{}, err
+	}
+
+	for _, event := range live.rawMultiline.Group(lines) {
+		if strings.TrimSpace(event) == "" {
+			continue
+		}
+
+		msg := models.LogMessage{
+			ID:        fmt.Sprintf("%s-%d", packet.PacketID, len(packet.Messages)+1),
+			Timestamp: received,
+			Level:     "INFO",
+			Source:    source,
+		}
+		if err := parser.Apply(live.rawParser, event, &msg); err != nil {
+			d.metrics.rawParseErrors.With().Inc()
+		}
+		packet.Messages = append(packet.Messages, msg)
+	}
+	return packet, nil
+}
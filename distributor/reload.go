@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"resolve/models"
+	"resolve/parser"
+)
+
+// configPollInterval is how often the configuration file is checked for changes
+const configPollInterval = 2 * time.Second
+
+// liveConfig is the configuration in effect together with the router built from it.
+// It is replaced as a whole, never modified, so readers need no lock.
+type liveConfig struct {
+	config models.DistributorConfig // static analyzers merged with self-registered members
+	static models.DistributorConfig // configuration file plus admin API changes
+	router *router
+
+	// Parser and multiline settings for plain-text lines posted to /logs
+	rawParser    parser.Parser
+	rawMultiline *parser.Multiline
+}
+
+// current returns the configuration in effect
+func (d *DistributorServer) current() *liveConfig {
+	return d.live.Load()
+}
+
+// updateConfig applies a change to a copy of the static configuration, validates it
+// and swaps it in. Changes are serialized so concurrent updates are never lost.
+func (d *DistributorServer) updateConfig(change func(*models.DistributorConfig) error) error {
+	d.configMu.Lock()
+	defer d.configMu.Unlock()
+
+	config := cloneConfig(d.current().static)
+	if err := change(&config); err != nil {
+		return err
+	}
+	return d.applyConfig(config)
+}
+
+// applyConfig merges a static configuration with the registered members, validates it,
+// swaps it in and cleans up after analyzers that were removed or changed. The caller
+// must hold configMu.
+func (d *DistributorServer) applyConfig(static models.DistributorConfig) error {
+	config := mergeMembers(static, d.members.list())
+	if err := validateConfig(&config); err != nil {
+		return err
+	}
+	router, err := newRouter(&config)
+	if err != nil {
+		return fmt.Errorf("invalid routing configuration: %w", err)
+	}
+	rawParser, err := parser.New(config.RawIngest.Parser)
+	if err != nil {
+		return fmt.Errorf("invalid raw_ingest parser: %w", err)
+	}
+	rawMultiline, err := parser.NewMultiline(config.RawIngest.Multiline)
+	if err != nil {
+		return fmt.Errorf("invalid raw_ingest multiline settings: %w", err)
+	}
+
+	previous := d.current()
+	d.live.Store(&liveConfig{config: config, static: static, router: router,
+		rawParser: rawParser, rawMultiline: rawMultiline})
+	d.health.setConfig(config.HealthCheck)
+
+	// Batchers hold a copy of their analyzer's settings, so they are rebuilt on change
+	updated := make(map[string]models.AnalyzerConfig)
+	for _, analyzer := range config.Analyzers {
+		updated[analyzer.ID] = analyzer
+	}
+	for _, analyzer := range previous.config.Analyzers {
+		next, ok := updated[analyzer.ID]
+		if !ok {
+			log.Printf("[CONFIG] Analyzer %s removed, its traffic moves to the remaining analyzers", analyzer.ID)
+			d.health.forget(analyzer.ID)
+			d.retireBatchers(analyzer.ID)
+			continue
+		}
+		if next != analyzer {
+			d.retireBatchers(analyzer.ID)
+		}
+	}
+	return nil
+}
+
+// reloadConfig re-reads the configuration file and swaps it in. Settings that are only
+// read at startup keep their current values.
+func (d *DistributorServer) reloadConfig() error {
+	config, err := loadConfig(d.configPath)
+	if err != nil {
+		return err
+	}
+
+	d.configMu.Lock()
+	defer d.configMu.Unlock()
+
+	startup := d.config
+	if config.Port != startup.Port || config.IngestMode != startup.IngestMode ||
+		config.QueueWAL != startup.QueueWAL || config.IngestJournal != startup.IngestJournal ||
+		config.DeadLetter.WAL != startup.DeadLetter.WAL || config.Workers != startup.Workers ||
+		config.Syslog != startup.Syslog {
+		log.Printf("[CONFIG] Port, ingest mode, write-ahead logs, workers and syslog listeners only change on restart, keeping the current values")
+	}
+	config.Port = startup.Port
+	config.IngestMode = startup.IngestMode
+	config.QueueWAL = startup.QueueWAL
+	config.IngestJournal = startup.IngestJournal
+	config.DeadLetter.WAL = startup.DeadLetter.WAL
+	config.Workers = startup.Workers
+	config.Syslog = startup.Syslog
+
+	if err := d.applyConfig(*config); err != nil {
+		return err
+	}
+	log.Printf("[CONFIG] Reloaded configuration from %s", d.configPath)
+	return nil
+}
+
+// configReloadWorker reloads the configuration on SIGHUP and whenever the file changes.
+// An invalid file is logged and the current configuration stays in effect.
+func (d *DistributorServer) configReloadWorker() {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+
+	ticker := time.NewTicker(configPollInterval)
+	defer ticker.Stop()
+
+	lastMod := configModTime(d.configPath)
+	for {
+		select {
+		case <-hangup:
+			log.Printf("[CONFIG] SIGHUP received, reloading %s", d.configPath)
+		case <-ticker.C:
+			modTime := configModTime(d.configPath)
+			if modTime.Equal(lastMod) {
+				continue
+			}
+			lastMod = modTime
+			log.Printf("[CONFIG] %s changed, reloading", d.configPath)
+		}
+
+		if err := d.reloadConfig(); err != nil {
+			log.Printf("[CONFIG] Reload failed, keeping the current configuration: %v", err)
+		}
+	}
+}
+
+// configModTime returns the modification time of the configuration file, or the zero time
+// when it cannot be read
+func configModTime(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+// cloneConfig copies a configuration deeply enough that changing analyzers or routing
+// groups in the copy leaves the original untouched
+func cloneConfig(config models.DistributorConfig) models.DistributorConfig {
+	config.Analyzers = append([]models.AnalyzerConfig(nil), config.Analyzers...)
+
+	groups := make([]models.AnalyzerGroup, len(config.Routing.Groups))
+	for i, group := range config.Routing.Groups {
+		group.Members = append([]models.GroupMember(nil), group.Members...)
+		groups[i] = group
+	}
+	config.Routing.Groups = groups
+	return config
+}
+
+// findAnalyzer returns the index of an analyzer in the configuration, or -1
+func findAnalyzer(config *models.DistributorConfig, id string) int {
+	for i, analyzer := range config.Analyzers {
+		if analyzer.ID == id {
+			return i
+		}
+	}
+	return -1
+}
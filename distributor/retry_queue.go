@@ -0,0 +1,86 @@
+package main
+
+import (
+	"container/heap"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// retryHeap orders queued messages by their next attempt time
+type retryHeap []*QueuedMessage
+
+func (h retryHeap) Len() int { return len(h) }
+
+func (h retryHeap) Less(i, j int) bool {
+	if h[i].NextAttempt.Equal(h[j].NextAttempt) {
+		return h[i].Seq < h[j].Seq
+	}
+	return h[i].NextAttempt.Before(h[j].NextAttempt)
+}
+
+func (h retryHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *retryHeap) Push(x interface{}) {
+	*h = append(*h, x.(*QueuedMessage))
+}
+
+func (h *retryHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	qm := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return qm
+}
+
+// maxDuePerPass bounds how many messages are taken off the heap under one lock
+const maxDuePerPass = 100
+
+// schedule puts a message on the retry heap and wakes the worker if it became the
+// earliest one. The caller must hold queueMu.
+func (d *DistributorServer) schedule(qm *QueuedMessage) {
+	heap.Push(&d.queue, qm)
+	if d.queue[0] == qm {
+		select {
+		case d.queueWake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// takeDue pops the messages whose next attempt time has passed. When none are due
+// it returns how long to wait for the earliest one.
+func (d *DistributorServer) takeDue(now time.Time) ([]*QueuedMessage, time.Duration) {
+	d.queueMu.Lock()
+	defer d.queueMu.Unlock()
+
+	var due []*QueuedMessage
+	for len(d.queue) > 0 && len(due) < maxDuePerPass && !d.queue[0].NextAttempt.After(now) {
+		due = append(due, heap.Pop(&d.queue).(*QueuedMessage))
+	}
+	if len(due) > 0 || len(d.queue) == 0 {
+		return due, time.Minute
+	}
+	return nil, d.queue[0].NextAttempt.Sub(now)
+}
+
+// retryBackoff returns the exponential backoff with jitter before the next attempt
+// of a message that has been attempted the given number of times
+func (d *DistributorServer) retryBackoff(attempts int) time.Duration {
+	cfg := d.current().config.Retry
+	initial := float64(cfg.InitialBackoff) * float64(time.Millisecond)
+	maxBackoff := float64(cfg.MaxBackoff) * float64(time.Millisecond)
+
+	exponent := attempts - 1
+	if exponent < 0 {
+		exponent = 0
+	}
+	backoff := math.Min(initial*math.Pow(cfg.Multiplier, float64(exponent)), maxBackoff)
+
+	// Spread retries of messages that failed together so they do not arrive in lockstep
+	if cfg.Jitter > 0 {
+		backoff *= 1 - cfg.Jitter + rand.Float64()*2*cfg.Jitter
+	}
+	return time.Duration(backoff)
+}
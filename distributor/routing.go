@@ -0,0 +1,244 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"regexp"
+	"strings"
+
+	"resolve/models"
+)
+
+// defaultGroupName is used for unmatched messages when no default group is configured
+const defaultGroupName = "all"
+
+// compiledRule is a routing rule with its matchers prepared for evaluation
+type compiledRule struct {
+	name     string
+	group    string
+	levels   map[string]bool
+	sources  map[string]bool
+	metadata map[string]string
+	message  *regexp.Regexp
+}
+
+// analyzerGroup is a pool of analyzers selected by weight, or by consistent hashing
+// when a sticky hash key is configured
+type analyzerGroup struct {
+	name      string
+	analyzers []models.AnalyzerConfig
+	hashKey   string
+	ring      *hashRing
+}
+
+// router maps log messages to analyzer groups using content-based rules
+type router struct {
+	rules        []compiledRule
+	groups       map[string]*analyzerGroup
+	defaultGroup string
+}
+
+// newRouter compiles the routing configuration, resolving group members against the analyzers
+func newRouter(config *models.DistributorConfig) (*router, error) {
+	analyzers := make(map[string]models.AnalyzerConfig)
+	for _, analyzer := range config.Analyzers {
+		analyzers[analyzer.ID] = analyzer
+	}
+
+	r := &router{
+		groups:       make(map[string]*analyzerGroup),
+		defaultGroup: config.Routing.DefaultGroup,
+	}
+
+	if config.Routing.VirtualNodes < 0 {
+		return nil, fmt.Errorf("invalid virtual node count: %d", config.Routing.VirtualNodes)
+	}
+
+	for _, group := range config.Routing.Groups {
+		if group.Name == "" {
+			return nil, fmt.Errorf("analyzer group without a name")
+		}
+		if _, exists := r.groups[group.Name]; exists {
+			return nil, fmt.Errorf("duplicate analyzer group: %s", group.Name)
+		}
+		if len(group.Members) == 0 {
+			return nil, fmt.Errorf("analyzer group %s has no members", group.Name)
+		}
+
+		members := make([]models.AnalyzerConfig, 0, len(group.Members))
+		for _, member := range group.Members {
+			analyzer, ok := analyzers[member.ID]
+			if !ok {
+				return nil, fmt.Errorf("analyzer group %s references unknown analyzer %s", group.Name, member.ID)
+			}
+			if member.Weight < 0 {
+				return nil, fmt.Errorf("analyzer group %s has negative weight for %s", group.Name, member.ID)
+			}
+			if member.Weight > 0 {
+				analyzer.Weight = member.Weight
+			}
+			members = append(members, analyzer)
+		}
+
+		hashKey := group.HashKey
+		if hashKey == "" {
+			hashKey = config.Routing.HashKey
+		}
+		built, err := newAnalyzerGroup(group.Name, members, hashKey, config.Routing.VirtualNodes)
+		if err != nil {
+			return nil, err
+		}
+		r.groups[group.Name] = built
+	}
+
+	// Without a configured default group, unmatched messages may go to any analyzer
+	if r.defaultGroup == "" {
+		r.defaultGroup = defaultGroupName
+		if _, exists := r.groups[defaultGroupName]; !exists {
+			built, err := newAnalyzerGroup(defaultGroupName, config.Analyzers, config.Routing.HashKey, config.Routing.VirtualNodes)
+			if err != nil {
+				return nil, err
+			}
+			r.groups[defaultGroupName] = built
+		}
+	}
+	if _, exists := r.groups[r.defaultGroup]; !exists {
+		return nil, fmt.Errorf("default group %s is not defined", r.defaultGroup)
+	}
+
+	for i, rule := range config.Routing.Rules {
+		name := rule.Name
+		if name == "" {
+			name = fmt.Sprintf("rule-%d", i+1)
+		}
+		if _, exists := r.groups[rule.Group]; !exists {
+			return nil, fmt.Errorf("routing rule %s targets unknown group %s", name, rule.Group)
+		}
+
+		compiled := compiledRule{
+			name:     name,
+			group:    rule.Group,
+			metadata: rule.Metadata,
+		}
+		if len(rule.Levels) > 0 {
+			compiled.levels = make(map[string]bool)
+			for _, level := range rule.Levels {
+				compiled.levels[strings.ToUpper(level)] = true
+			}
+		}
+		if len(rule.Sources) > 0 {
+			compiled.sources = make(map[string]bool)
+			for _, source := range rule.Sources {
+				compiled.sources[source] = true
+			}
+		}
+		if rule.MessageRegex != "" {
+			re, err := regexp.Compile(rule.MessageRegex)
+			if err != nil {
+				return nil, fmt.Errorf("routing rule %s has invalid message regex: %w", name, err)
+			}
+			compiled.message = re
+		}
+		r.rules = append(r.rules, compiled)
+	}
+
+	return r, nil
+}
+
+// route returns the group for a message: the first matching rule wins,
+// otherwise the default group
+func (r *router) route(logMessage models.LogMessage) string {
+	for _, rule := range r.rules {
+		if rule.matches(logMessage) {
+			return rule.group
+		}
+	}
+	return r.defaultGroup
+}
+
+// groupFor returns the group a message routes to
+func (r *router) groupFor(logMessage models.LogMessage) *analyzerGroup {
+	return r.groups[r.route(logMessage)]
+}
+
+// newAnalyzerGroup builds a group, preparing its hash ring when a sticky key is configured
+func newAnalyzerGroup(name string, analyzers []models.AnalyzerConfig, hashKey string, virtualNodes int) (*analyzerGroup, error) {
+	group := &analyzerGroup{
+		name:      name,
+		analyzers: analyzers,
+		hashKey:   hashKey,
+	}
+	if hashKey != "" {
+		if !validStickyKey(hashKey) {
+			return nil, fmt.Errorf("analyzer group %s has invalid hash key: %s", name, hashKey)
+		}
+		group.ring = newHashRing(analyzers, virtualNodes)
+	}
+	return group, nil
+}
+
+// pick selects an eligible analyzer for a message. Messages carrying the group's
+// hash key always land on the same analyzer while it stays eligible; everything
+// else is selected by weight.
+func (g *analyzerGroup) pick(logMessage models.LogMessage, eligible func(models.AnalyzerConfig) bool) models.AnalyzerConfig {
+	if g.ring != nil {
+		if key, ok := stickyKey(logMessage, g.hashKey); ok {
+			return g.ring.lookup(key, eligible)
+		}
+	}
+
+	var candidates []models.AnalyzerConfig
+	for _, analyzer := range g.analyzers {
+		if eligible(analyzer) {
+			candidates = append(candidates, analyzer)
+		}
+	}
+	return pickWeighted(candidates)
+}
+
+// matches reports whether a message satisfies every condition of the rule
+func (rule compiledRule) matches(logMessage models.LogMessage) bool {
+	if rule.levels != nil && !rule.levels[strings.ToUpper(logMessage.Level)] {
+		return false
+	}
+	if rule.sources != nil && !rule.sources[logMessage.Source] {
+		return false
+	}
+	for key, want := range rule.metadata {
+		got, ok := logMessage.Metadata[key]
+		if !ok || (want != "*" && got != want) {
+			return false
+		}
+	}
+	if rule.message != nil && !rule.message.MatchString(logMessage.Message) {
+		return false
+	}
+	return true
+}
+
+// pickWeighted selects an analyzer at random in proportion to its weight
+func pickWeighted(analyzers []models.AnalyzerConfig) models.AnalyzerConfig {
+	if len(analyzers) == 0 {
+		return models.AnalyzerConfig{}
+	}
+
+	var totalWeight float64
+	for _, analyzer := range analyzers {
+		totalWeight += analyzer.Weight
+	}
+
+	// Generate random number between 0 and total weight
+	randomValue := rand.Float64() * totalWeight
+
+	// Select analyzer based on weighted distribution
+	currentWeight := 0.0
+	for _, analyzer := range analyzers {
+		currentWeight += analyzer.Weight
+		if randomValue <= currentWeight {
+			return analyzer
+		}
+	}
+
+	// Fallback to first analyzer (shouldn't reach here)
+	return analyzers[0]
+}
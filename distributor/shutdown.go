@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// inFlight counts work in progress so shutdown can wait for it to finish
+type inFlight struct {
+	mu   sync.Mutex
+	n    int
+	zero chan struct{} // closed when n drops to zero
+}
+
+func (f *inFlight) add() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.n++
+}
+
+func (f *inFlight) done() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.n--
+	if f.n == 0 && f.zero != nil {
+		close(f.zero)
+		f.zero = nil
+	}
+}
+
+// wait blocks until no work is in progress or ctx ends, reporting whether it drained
+func (f *inFlight) wait(ctx context.Context) bool {
+	f.mu.Lock()
+	if f.n == 0 {
+		f.mu.Unlock()
+		return true
+	}
+	if f.zero == nil {
+		f.zero = make(chan struct{})
+	}
+	zero := f.zero
+	f.mu.Unlock()
+
+	select {
+	case <-zero:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// draining reports whether shutdown has started
+func (d *DistributorServer) draining() bool {
+	select {
+	case <-d.stopping:
+		return true
+	default:
+		return false
+	}
+}
+
+// pause waits out a retry backoff, returning early once shutdown aborts deliveries
+func (d *DistributorServer) pause(backoff time.Duration) {
+	timer := time.NewTimer(backoff)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-d.ctx.Done():
+	}
+}
+
+// shutdownOnSignal starts a graceful shutdown on SIGINT or SIGTERM
+func (d *DistributorServer) shutdownOnSignal() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-signals
+	log.Printf("[SHUTDOWN] %v received", sig)
+	d.Shutdown()
+}
+
+// Shutdown drains the distributor. /health reports draining and new packets are refused
+// for the drain delay, then the listener closes and in-flight messages get until the
+// shutdown timeout to be delivered. Messages still undelivered after that have their
+// requests aborted and are moved to the retry queue, and every write-ahead log is closed.
+func (d *DistributorServer) Shutdown() {
+	d.shutdownOnce.Do(func() {
+		cfg := d.current().config.Shutdown
+		close(d.stopping)
+		log.Printf("[SHUTDOWN] Draining, refusing new packets for %d ms before closing the listener", cfg.DrainDelay)
+		time.Sleep(time.Duration(cfg.DrainDelay) * time.Millisecond)
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.Timeout)*time.Millisecond)
+		defer cancel()
+
+		if d.server != nil {
+			if err := d.server.Shutdown(ctx); err != nil {
+				log.Printf("[SHUTDOWN] HTTP server did not stop cleanly: %v", err)
+			}
+		}
+		// Received syslog messages are distributed like packets that were already accepted
+		if d.syslog != nil {
+			d.syslog.stop()
+		}
+		if !d.work.wait(ctx) {
+			log.Printf("[SHUTDOWN] Deadline passed, aborting in-flight deliveries and queuing their messages")
+		}
+
+		// Aborted deliveries fail fast and move their messages to the retry queue
+		d.abort()
+		d.work.wait(context.Background())
+
+		d.queueMu.Lock()
+		queued := len(d.queue)
+		d.queueMu.Unlock()
+		if d.config.QueueWAL.Dir == "" && queued > 0 {
+			log.Printf("[SHUTDOWN] %d queued messages are kept in memory only and will be lost", queued)
+		}
+
+		stores := []*durableLog{d.store, d.journal}
+		if d.deadLetters != nil {
+			stores = append(stores, d.deadLetters.store)
+		}
+		for _, store := range stores {
+			if store == nil {
+				continue
+			}
+			if err := store.close(); err != nil {
+				log.Printf("[SHUTDOWN] Failed to close %s log: %v", store.name, err)
+			}
+		}
+		log.Printf("[SHUTDOWN] Distributor stopped with %d messages queued for retry", queued)
+		close(d.stopped)
+	})
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"resolve/models"
+)
+
+// maxStreamErrors bounds the rejected lines described in a stream summary
+const maxStreamErrors = 10
+
+// Stream outcomes reported in the summary
+const (
+	streamCompleted   = "completed"   // the whole body was read
+	streamInterrupted = "interrupted" // the distributor started shutting down
+	streamFailed      = "failed"      // the body could not be read or a packet could not be journaled
+)
+
+// streamLine is one non-empty line of a stream, decoded or rejected
+type streamLine struct {
+	number  int
+	message models.LogMessage
+	err     error
+}
+
+// streamError describes a rejected line in the summary
+type streamError struct {
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+}
+
+// ingestStream reads one NDJSON request body. A reader goroutine decodes lines while the
+// handler collects them into packets; once MaxInFlight packets are being distributed the
+// handler stops taking lines, the reader stops reading and TCP flow control slows the
+// sender down.
+type ingestStream struct {
+	d       *DistributorServer
+	config  models.StreamConfig
+	id      string
+	agentID string
+	source  string
+
+	lines    chan streamLine
+	inFlight chan struct{} // one slot per packet being distributed
+	readErr  error         // set by the reader before it closes lines
+
+	read     int // non-empty lines read
+	accepted int
+	rejected int
+	packets  int
+	errors   []streamError
+}
+
+// handleLogStream receives newline-delimited LogMessage JSON over a long-lived, typically
+// chunked request and answers with a summary once the body ends. The agent_id and source
+// query parameters name the sender and the default source.
+func (d *DistributorServer) handleLogStream(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// A draining distributor takes no new work so emitters move to another one
+	if d.draining() {
+		http.Error(w, "Distributor is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+
+	// A stream's lines are bounded one by one, so its decoded size need not be
+	if !d.decodeRequestBody(w, r, 0) {
+		return
+	}
+
+	started := time.Now()
+	s := &ingestStream{
+		d:       d,
+		config:  d.current().config.StreamIngest,
+		id:      fmt.Sprintf("stream-%d", started.UnixNano()),
+		agentID: r.URL.Query().Get("agent_id"),
+		source:  r.URL.Query().Get("source"),
+		errors:  []streamError{},
+	}
+	if s.agentID == "" {
+		s.agentID = "stream"
+	}
+	if s.source == "" {
+		s.source = "stream"
+	}
+	s.lines = make(chan streamLine, s.config.BatchSize)
+	s.inFlight = make(chan struct{}, s.config.MaxInFlight)
+
+	d.streams.Add(1)
+	defer d.streams.Add(-1)
+	log.Printf("[STREAM] Stream %s from %s opened", s.id, s.agentID)
+
+	// Shutdown ends the stream at the next read so the handler can answer in time
+	reading := make(chan struct{})
+	var interrupted atomic.Bool
+	go func() {
+		select {
+		case <-d.stopping:
+			interrupted.Store(true)
+			http.NewResponseController(w).SetReadDeadline(time.Now())
+		case <-reading:
+		}
+	}()
+
+	go s.readLines(r.Body)
+	status, err := s.collect()
+	if status != streamCompleted {
+		// Stop the reader; lines it still decodes are not accepted
+		http.NewResponseController(w).SetReadDeadline(time.Now())
+		for range s.lines {
+		}
+	}
+	close(reading)
+	if status == streamFailed && interrupted.Load() {
+		status, err = streamInterrupted, errors.New("distributor is shutting down")
+	}
+	d.metrics.streamLines.With("accepted").Add(float64(s.accepted))
+	d.metrics.streamLines.With("rejected").Add(float64(s.rejected))
+
+	code := http.StatusOK
+	switch {
+	case status == streamInterrupted:
+		code = http.StatusServiceUnavailable
+	case status == streamFailed:
+		code = http.StatusBadRequest
+		if !errors.Is(err, errStreamRead) {
+			code = http.StatusServiceUnavailable
+		}
+	case d.config.IngestMode == models.IngestModeDurable:
+		code = http.StatusAccepted
+	}
+
+	response := map[string]interface{}{
+		"status":      status,
+		"stream_id":   s.id,
+		"lines":       s.read,
+		"accepted":    s.accepted,
+		"rejected":    s.rejected,
+		"packets":     s.packets,
+		"errors":      s.errors,
+		"duration_ms": time.Since(started).Milliseconds(),
+		"timestamp":   time.Now().Format(time.RFC3339),
+	}
+	if err != nil {
+		response["error"] = err.Error()
+		log.Printf("[STREAM] Stream %s from %s %s after %d lines: %v", s.id, s.agentID, status, s.read, err)
+	} else {
+		log.Printf("[STREAM] Stream %s from %s completed: %d accepted, %d rejected", s.id, s.agentID, s.accepted, s.rejected)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(response)
+}
+
+// errStreamRead marks a failure to read the request body, as opposed to a failure to
+// accept what was read
+var errStreamRead = errors.New("failed to read stream")
+
+// readLines decodes the body line by line until it ends or cannot be read
+func (s *ingestStream) readLines(body io.Reader) {
+	defer close(s.lines)
+
+	reader := bufio.NewReaderSize(body, s.config.MaxLineSize)
+	number := 0
+	for {
+		line, err := reader.ReadSlice('\n')
+		tooLong := err == bufio.ErrBufferFull
+		for err == bufio.ErrBufferFull {
+			// Skip the rest of an oversized line
+			_, err = reader.ReadSlice('\n')
+		}
+		if err != nil && err != io.EOF {
+			s.readErr = fmt.Errorf("%w: %v", errStreamRead, err)
+			return
+		}
+
+		if tooLong || len(bytes.TrimSpace(line)) > 0 {
+			number++
+			s.lines <- s.decode(number, line, tooLong)
+		}
+		if err == io.EOF {
+			return
+		}
+	}
+}
+
+// decode turns one line into a log message, filling in an ID, timestamp, level and
+// source where the line has none
+func (s *ingestStream) decode(number int, line []byte, tooLong bool) streamLine {
+	if tooLong {
+		return streamLine{number: number, err: fmt.Errorf("line exceeds the %d byte limit", s.config.MaxLineSize)}
+	}
+
+	var msg models.LogMessage
+	if err := json.Unmarshal(line, &msg); err != nil {
+		return streamLine{number: number, err: fmt.Errorf("invalid JSON: %v", err)}
+	}
+	if msg.Message == "" {
+		return streamLine{number: number, err: errors.New("missing message")}
+	}
+	if msg.ID == "" {
+		msg.ID = fmt.Sprintf("%s-%d", s.id, number)
+	}
+	if msg.Timestamp.IsZero() {
+		msg.Timestamp = time.Now()
+	}
+	if msg.Level == "" {
+		msg.Level = "INFO"
+	}
+	if msg.Source == "" {
+		msg.Source = s.source
+	}
+	return streamLine{number: number, message: msg}
+}
+
+// collect batches decoded lines into packets of BatchSize, handing on a partial packet
+// after FlushInterval, until the reader is done or a packet cannot be handed on
+func (s *ingestStream) collect() (string, error) {
+	ticker := time.NewTicker(time.Duration(s.config.FlushInterval) * time.Millisecond)
+	defer ticker.Stop()
+
+	var batch []models.LogMessage
+	var err error
+	for {
+		select {
+		case line, ok := <-s.lines:
+			if !ok {
+				if err = s.dispatch(batch); err != nil {
+					return streamFailed, err
+				}
+				if s.readErr != nil {
+					return streamFailed, s.readErr
+				}
+				return streamCompleted, nil
+			}
+
+			s.read++
+			if line.err != nil {
+				s.rejected++
+				if len(s.errors) < maxStreamErrors {
+					s.errors = append(s.errors, streamError{Line: line.number, Error: line.err.Error()})
+				}
+				continue
+			}
+			batch = append(batch, line.message)
+			if len(batch) >= s.config.BatchSize {
+				err = s.dispatch(batch)
+				batch = nil
+			}
+		case <-ticker.C:
+			err = s.dispatch(batch)
+			batch = nil
+		}
+
+		if err != nil {
+			return streamFailed, err
+		}
+	}
+}
+
+// dispatch hands a batch on for distribution, first waiting for one of the stream's
+// in-flight slots. The wait is the stream's backpressure.
+func (s *ingestStream) dispatch(batch []models.LogMessage) error {
+	if len(batch) == 0 {
+		return nil
+	}
+
+	select {
+	case s.inFlight <- struct{}{}:
+	default:
+		waited := time.Now()
+		select {
+		case s.inFlight <- struct{}{}:
+		case <-s.d.ctx.Done():
+			return errors.New("distributor stopped before the stream's messages could be distributed")
+		}
+		s.d.metrics.streamBackpressure.With().Add(time.Since(waited).Seconds())
+	}
+
+	s.packets++
+	packet := models.LogPacket{
+		PacketID:  fmt.Sprintf("%s-packet-%d", s.id, s.packets),
+		AgentID:   s.agentID,
+		Timestamp: time.Now(),
+		Messages:  batch,
+	}
+	if _, err := s.d.ingestPacket(packet, func() { <-s.inFlight }); err != nil {
+		<-s.inFlight
+		s.packets--
+		return fmt.Errorf("failed to persist packet %s: %w", packet.PacketID, err)
+	}
+	s.accepted += len(batch)
+	return nil
+}
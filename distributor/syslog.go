@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"resolve/models"
+	"resolve/parser"
+)
+
+// syslogQueueSize is how many parsed messages may wait to be packed, per batch
+const syslogQueueSize = 10
+
+// syslogServer receives syslog messages over UDP and TCP and distributes them in packets
+// through the same path as packets posted to /logs. TCP connections block while the
+// queue is full; UDP messages are dropped instead.
+type syslogServer struct {
+	d      *DistributorServer
+	config models.SyslogConfig
+	parser parser.Parser
+
+	udp     net.PacketConn
+	tcp     net.Listener
+	connsMu sync.Mutex
+	conns   map[net.Conn]struct{}
+	readers sync.WaitGroup
+
+	messages chan models.LogMessage
+	packed   chan struct{} // closed when the last packet has been handed on
+	started  time.Time     // keeps message IDs unique across restarts
+	sequence atomic.Uint64
+	packets  atomic.Uint64
+}
+
+// newSyslogServer creates the syslog listeners described by config
+func newSyslogServer(d *DistributorServer, config models.SyslogConfig) *syslogServer {
+	p, _ := parser.New(models.ParserConfig{Type: parser.TypeSyslog})
+	return &syslogServer{
+		d:        d,
+		config:   config,
+		parser:   p,
+		conns:    make(map[net.Conn]struct{}),
+		messages: make(chan models.LogMessage, config.BatchSize*syslogQueueSize),
+		packed:   make(chan struct{}),
+		started:  time.Now(),
+	}
+}
+
+// start opens the configured listeners and starts reading from them
+func (s *syslogServer) start() error {
+	if s.config.UDPPort > 0 {
+		udp, err := net.ListenPacket("udp", fmt.Sprintf(":%d", s.config.UDPPort))
+		if err != nil {
+			return fmt.Errorf("failed to listen for syslog on UDP port %d: %w", s.config.UDPPort, err)
+		}
+		s.udp = udp
+	}
+	if s.config.TCPPort > 0 {
+		tcp, err := net.Listen("tcp", fmt.Sprintf(":%d", s.config.TCPPort))
+		if err != nil {
+			if s.udp != nil {
+				s.udp.Close()
+			}
+			return fmt.Errorf("failed to listen for syslog on TCP port %d: %w", s.config.TCPPort, err)
+		}
+		s.tcp = tcp
+	}
+
+	if s.udp != nil {
+		s.readers.Add(1)
+		go s.serveUDP()
+		log.Printf("[SYSLOG] Listening on UDP port %d", s.config.UDPPort)
+	}
+	if s.tcp != nil {
+		s.readers.Add(1)
+		go s.serveTCP()
+		log.Printf("[SYSLOG] Listening on TCP port %d", s.config.TCPPort)
+	}
+	go s.pack()
+	return nil
+}
+
+// stop closes the listeners and every TCP connection, then distributes the messages
+// that were already received
+func (s *syslogServer) stop() {
+	if s.udp != nil {
+		s.udp.Close()
+	}
+	if s.tcp != nil {
+		s.tcp.Close()
+	}
+	s.connsMu.Lock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.connsMu.Unlock()
+
+	s.readers.Wait()
+	close(s.messages)
+	<-s.packed
+}
+
+// serveUDP reads one message per datagram
+func (s *syslogServer) serveUDP() {
+	defer s.readers.Done()
+
+	buf := make([]byte, s.config.MaxMessageSize)
+	for {
+		n, addr, err := s.udp.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Printf("[SYSLOG] UDP read failed: %v", err)
+			continue
+		}
+
+		msg := s.newMessage(buf[:n], "udp", addr)
+		select {
+		case s.messages <- msg:
+		default:
+			s.d.metrics.syslogDropped.With("queue_full").Inc()
+		}
+	}
+}
+
+// serveTCP accepts connections until the listener is closed
+func (s *syslogServer) serveTCP() {
+	defer s.readers.Done()
+
+	for {
+		conn, err := s.tcp.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Printf("[SYSLOG] TCP accept failed: %v", err)
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+
+		s.connsMu.Lock()
+		s.conns[conn] = struct{}{}
+		s.connsMu.Unlock()
+		s.readers.Add(1)
+		go s.serveConn(conn)
+	}
+}
+
+// serveConn reads frames from one TCP connection until it closes or sends a frame that
+// cannot be read
+func (s *syslogServer) serveConn(conn net.Conn) {
+	defer s.readers.Done()
+	defer func() {
+		s.connsMu.Lock()
+		delete(s.conns, conn)
+		s.connsMu.Unlock()
+		conn.Close()
+	}()
+
+	reader := bufio.NewReaderSize(conn, s.config.MaxMessageSize)
+	for {
+		frame, err := readSyslogFrame(reader, s.config.MaxMessageSize)
+		if err != nil {
+			if err != io.EOF && !errors.Is(err, net.ErrClosed) {
+				log.Printf("[SYSLOG] Closing connection from %s: %v", conn.RemoteAddr(), err)
+			}
+			return
+		}
+		if len(frame) == 0 {
+			continue
+		}
+		s.messages <- s.newMessage(frame, "tcp", conn.RemoteAddr())
+	}
+}
+
+// readSyslogFrame reads one frame of a syslog stream (RFC 6587). A frame starting with a
+// digit is octet-counted ("LENGTH MESSAGE"); any other frame ends at a newline.
+func readSyslogFrame(reader *bufio.Reader, maxSize int) ([]byte, error) {
+	first, err := reader.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+
+	if first[0] >= '1' && first[0] <= '9' {
+		var length []byte
+		for {
+			c, err := reader.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+			if c == ' ' {
+				break
+			}
+			if c < '0' || c > '9' || len(length) >= 10 {
+				return nil, fmt.Errorf("invalid octet count %q", length)
+			}
+			length = append(length, c)
+		}
+		n, _ := strconv.Atoi(string(length))
+		if n > maxSize {
+			return nil, fmt.Errorf("message of %d bytes exceeds the %d byte limit", n, maxSize)
+		}
+		frame := make([]byte, n)
+		if _, err := io.ReadFull(reader, frame); err != nil {
+			return nil, err
+		}
+		return frame, nil
+	}
+
+	line, err := reader.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		return nil, fmt.Errorf("message exceeds the %d byte limit", maxSize)
+	}
+	if err != nil && (err != io.EOF || len(line) == 0) {
+		return nil, err
+	}
+	// The slice is only valid until the next read
+	return bytes.Clone(line), nil
+}
+
+// newMessage parses a syslog message. A message that does not parse is kept as it is,
+// with a parse_error metadata field.
+func (s *syslogServer) newMessage(frame []byte, transport string, addr net.Addr) models.LogMessage {
+	s.d.metrics.syslogReceived.With(transport).Inc()
+	msg := models.LogMessage{
+		ID:        fmt.Sprintf("syslog-%d-%d", s.started.UnixNano(), s.sequence.Add(1)),
+		Timestamp: time.Now(),
+		Level:     "INFO",
+		Source:    s.config.Source,
+		Metadata: map[string]string{
+			"transport":   transport,
+			"remote_addr": addr.String(),
+		},
+	}
+	line := string(bytes.TrimRight(frame, "\r\n\x00"))
+	if err := parser.Apply(s.parser, line, &msg); err != nil {
+		s.d.metrics.syslogParseErrors.With().Inc()
+	}
+	return msg
+}
+
+// pack collects messages into packets of BatchSize, handing on a partial packet after
+// FlushInterval
+func (s *syslogServer) pack() {
+	defer close(s.packed)
+
+	ticker := time.NewTicker(time.Duration(s.config.FlushInterval) * time.Millisecond)
+	defer ticker.Stop()
+
+	var batch []models.LogMessage
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		packet := models.LogPacket{
+			PacketID:  fmt.Sprintf("syslog-packet-%d", s.packets.Add(1)),
+			AgentID:   "syslog",
+			Timestamp: time.Now(),
+			Messages:  batch,
+		}
+		if _, err := s.d.ingestPacket(packet, nil); err != nil {
+			log.Printf("[SYSLOG] Dropped packet %s with %d messages: %v", packet.PacketID, len(batch), err)
+			s.d.metrics.syslogDropped.With("ingest_failed").Add(float64(len(batch)))
+		}
+		batch = nil
+	}
+
+	for {
+		select {
+		case msg, ok := <-s.messages:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, msg)
+			if len(batch) >= s.config.BatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
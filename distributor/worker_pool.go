@@ -0,0 +1,75 @@
+package main
+
+import "math"
+
+// trafficClass identifies who is asking for a worker slot
+type trafficClass int
+
+const (
+	classLive  trafficClass = iota // messages from freshly received packets
+	classRetry                     // redelivery of queued messages
+)
+
+// String returns the class name used in logs and status responses
+func (c trafficClass) String() string {
+	if c == classRetry {
+		return "retry"
+	}
+	return "live"
+}
+
+// workerPool bounds the number of concurrent requests to analyzers. Its slots are
+// split between live traffic and retries so that a large retry backlog cannot
+// starve fresh traffic, and a burst of fresh traffic cannot stall recovery.
+type workerPool struct {
+	live  chan struct{}
+	retry chan struct{}
+}
+
+// newWorkerPool creates a pool of size slots, reserving retryShare of them for retries.
+// Each class always gets at least one slot.
+func newWorkerPool(size int, retryShare float64) *workerPool {
+	if size < 2 {
+		size = 2
+	}
+	retrySlots := int(math.Round(float64(size) * retryShare))
+	if retrySlots < 1 {
+		retrySlots = 1
+	}
+	if retrySlots > size-1 {
+		retrySlots = size - 1
+	}
+
+	return &workerPool{
+		live:  make(chan struct{}, size-retrySlots),
+		retry: make(chan struct{}, retrySlots),
+	}
+}
+
+// slots returns the semaphore for a traffic class
+func (p *workerPool) slots(class trafficClass) chan struct{} {
+	if class == classRetry {
+		return p.retry
+	}
+	return p.live
+}
+
+// acquire blocks until a slot of the given class is free
+func (p *workerPool) acquire(class trafficClass) {
+	p.slots(class) <- struct{}{}
+}
+
+// release frees a slot taken with acquire
+func (p *workerPool) release(class trafficClass) {
+	<-p.slots(class)
+}
+
+// capacity returns the number of slots reserved for a traffic class
+func (p *workerPool) capacity(class trafficClass) int {
+	return cap(p.slots(class))
+}
+
+// inUse returns the number of slots of a traffic class currently taken
+func (p *workerPool) inUse(class trafficClass) int {
+	return len(p.slots(class))
+}
@@ -93,15 +93,12 @@ show_status() {
 # Function to scale analyzers
 scale_analyzers() {
     local count=${1:-3}
-    print_header "Scaling analyzers to $count instances"
+    print_header "Scaling self-registering analyzers to $count instances"
     
-    # Stop existing analyzers
-    docker-compose -f docker-compose.yml stop analyzer-1 analyzer-2 analyzer-3 2>/dev/null || true
+    # Pool analyzers register with the distributor on startup and deregister on shutdown
+    docker-compose -f docker-compose.yml --profile scale up -d --no-recreate --scale analyzer-pool=$count analyzer-pool
     
-    # Scale up
-    docker-compose -f docker-compose.yml up -d --scale analyzer-1=1 --scale analyzer-2=1 --scale analyzer-3=1
-    
-    print_status "Scaled to $count analyzer instances"
+    print_status "Scaled to $count pool analyzers in addition to analyzer-1..3"
 }
 
 # Function to clean up
@@ -1,25 +1,54 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"math/rand"
 	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
+	"resolve/compression"
 	"resolve/emitters"
+	"resolve/metrics"
 	"resolve/models"
+	"resolve/parser"
 )
 
 // EmitterServer simulates a web application that generates logs and sends them to distributors
 type EmitterServer struct {
 	config      EmitterServerConfig
 	emitterPool *emitters.EmitterPoolImpl
+	buffers     []*emitters.BufferedEmitter
+	loggers     []*emitters.MultilineLogger // in front of each buffer when multiline is configured
+	multiline   *parser.Multiline
+	spools      []*emitters.SpoolingEmitter
+	balancer    *emitters.BalancingEmitter // shared by every emitter outside broadcast mode
+	nextEmitter atomic.Uint64
 	mu          sync.RWMutex
 	stats       EmitterServerStats
+	server      *http.Server
+
+	// Continuous generation and shutdown state, guarded by mu
+	generating chan struct{} // closed to stop continuous generation; nil when idle
+	draining   bool
+	sending    sync.WaitGroup
+
+	// Series served on /metrics
+	metrics        *metrics.Registry
+	logsGenerated  *metrics.CounterVec
+	packetsSent    *metrics.CounterVec
+	sendFailures   *metrics.CounterVec
+	packetsSpooled *metrics.CounterVec
+	emitDuration   *metrics.HistogramVec
 }
 
 // EmitterServerConfig holds the configuration for the emitter server
@@ -30,7 +59,18 @@ type EmitterServerConfig struct {
 	MaxConcurrency         int      `json:"max_concurrency"`
 	BatchSize              int      `json:"batch_size"`
 	FlushInterval          int      `json:"flush_interval"`           // milliseconds
+	BufferSize             int      `json:"buffer_size"`              // messages buffered per emitter
+	OverflowPolicy         string   `json:"overflow_policy"`          // block, drop_oldest or drop_newest
 	EmittersPerDistributor int      `json:"emitters_per_distributor"` // number of emitters per distributor
+	DeliveryMode           string   `json:"delivery_mode"`            // broadcast, round_robin, least_loaded or failover
+	FailoverCooldown       int      `json:"failover_cooldown"`        // milliseconds a failed distributor is skipped
+	StackTraceRate         float64  `json:"stack_trace_rate"`         // fraction of generated logs that are multiline stack traces
+	Compression            string   `json:"compression"`              // gzip, snappy, deflate or identity for packets, once a distributor advertises it
+	WireFormat             string   `json:"wire_format"`              // json or binary packets
+
+	Multiline models.MultilineConfig `json:"multiline"` // joins stack trace lines before they are buffered
+	Spool     models.SpoolConfig     `json:"spool"`     // one subdirectory of dir per emitter
+	Shutdown  models.ShutdownConfig  `json:"shutdown"`
 }
 
 // EmitterServerStats tracks the performance of the emitter server
@@ -45,13 +85,33 @@ type EmitterServerStats struct {
 
 // NewEmitterServer creates a new emitter server
 func NewEmitterServer(config EmitterServerConfig) *EmitterServer {
-	return &EmitterServer{
+	registry := metrics.NewRegistry()
+	em := &EmitterServer{
 		config:      config,
 		emitterPool: emitters.NewEmitterPool(),
 		stats: EmitterServerStats{
 			StartTime: time.Now(),
 		},
+		metrics: registry,
+		logsGenerated: registry.Counter("emitter_logs_generated_total",
+			"Log messages generated."),
+		packetsSent: registry.Counter("emitter_packets_sent_total",
+			"Log packets sent to distributors.", "emitter"),
+		sendFailures: registry.Counter("emitter_send_failures_total",
+			"Log packets that could not be delivered to a distributor.", "emitter"),
+		packetsSpooled: registry.Counter("emitter_packets_spooled_total",
+			"Log packets written to the spool because the distributor could not take them.", "emitter"),
+		emitDuration: registry.Histogram("emitter_emit_duration_seconds",
+			"Time taken to emit a log packet, including retries.", nil, "emitter"),
 	}
+	registry.GaugeFunc("emitter_spool_packets", "Log packets waiting in the spool.", func() float64 {
+		total := 0
+		for _, spool := range em.spools {
+			total += spool.Stats().Packets
+		}
+		return float64(total)
+	})
+	return em
 }
 
 // Start starts the emitter server
@@ -68,9 +128,10 @@ func (em *EmitterServer) Start() error {
 	mux.HandleFunc("/start", em.handleStart)
 	mux.HandleFunc("/stop", em.handleStop)
 	mux.HandleFunc("/generate", em.handleGenerateLogs)
+	mux.Handle("/metrics", em.metrics.Handler())
 
 	// Create server
-	server := &http.Server{
+	em.server = &http.Server{
 		Addr:    fmt.Sprintf(":%d", em.config.Port),
 		Handler: mux,
 	}
@@ -81,12 +142,108 @@ func (em *EmitterServer) Start() error {
 	log.Printf("Start log generation: http://localhost:%d/start", em.config.Port)
 	log.Printf("Stop log generation: http://localhost:%d/stop", em.config.Port)
 	log.Printf("Generate single batch: http://localhost:%d/generate", em.config.Port)
+	log.Printf("Metrics available at http://localhost:%d/metrics", em.config.Port)
+
+	return em.server.ListenAndServe()
+}
+
+// Shutdown stops log generation, reports draining on /health for the drain delay and
+// then waits up to the shutdown timeout for packets that are being sent
+func (em *EmitterServer) Shutdown() {
+	em.mu.Lock()
+	em.draining = true
+	em.stopGenerationLocked()
+	em.mu.Unlock()
+
+	log.Printf("Emitter server draining for %d ms", em.config.Shutdown.DrainDelay)
+	time.Sleep(time.Duration(em.config.Shutdown.DrainDelay) * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(em.config.Shutdown.Timeout)*time.Millisecond)
+	defer cancel()
+
+	sent := make(chan struct{})
+	go func() {
+		em.sending.Wait()
+		close(sent)
+	}()
+	select {
+	case <-sent:
+	case <-ctx.Done():
+		log.Printf("Packets still being sent after %d ms, abandoning them", em.config.Shutdown.Timeout)
+	}
+
+	// Send what is still buffered, including stack traces waiting for more lines
+	for _, logger := range em.loggers {
+		logger.Flush()
+	}
+	for _, buffer := range em.buffers {
+		if err := buffer.Close(ctx); err != nil {
+			log.Printf("Emitter %s did not flush in time, cancelled its remaining sends", buffer.GetID())
+		}
+	}
+
+	// Spooled packets are delivered after the next start
+	for _, spool := range em.spools {
+		if stats := spool.Stats(); stats.Packets > 0 {
+			log.Printf("Emitter %s keeps %d packets in its spool", spool.GetID(), stats.Packets)
+		}
+		if err := spool.Close(ctx); err != nil {
+			log.Printf("Emitter %s failed to close its spool: %v", spool.GetID(), err)
+		}
+	}
+
+	if em.server != nil {
+		if err := em.server.Shutdown(ctx); err != nil {
+			em.server.Close()
+		}
+	}
+	log.Printf("Emitter server stopped")
+}
+
+// beginSend registers a packet about to be sent, refusing it once draining has started
+func (em *EmitterServer) beginSend() bool {
+	em.mu.Lock()
+	defer em.mu.Unlock()
+	if em.draining {
+		return false
+	}
+	em.sending.Add(1)
+	return true
+}
 
-	return server.ListenAndServe()
+// stopGenerationLocked stops continuous generation if it is running. The caller must hold mu.
+func (em *EmitterServer) stopGenerationLocked() bool {
+	if em.generating == nil {
+		return false
+	}
+	close(em.generating)
+	em.generating = nil
+	return true
 }
 
-// initializeEmitters creates HTTP emitters for each distributor URL and adds them to the pool
+// initializeEmitters creates buffered HTTP emitters for each distributor URL and adds them to the pool.
+// In broadcast mode every emitter sends to its own distributor; in the other modes every
+// emitter sends through one balancer that picks a distributor per packet.
 func (em *EmitterServer) initializeEmitters() error {
+	multiline, err := parser.NewMultiline(em.config.Multiline)
+	if err != nil {
+		return err
+	}
+	em.multiline = multiline
+
+	if em.config.DeliveryMode != models.DeliveryBroadcast {
+		targets := make([]models.Emitter, len(em.config.DistributorURLs))
+		for i, distributorURL := range em.config.DistributorURLs {
+			targets[i] = emitters.NewHTTPEmitter(em.emitterConfig(fmt.Sprintf("distributor-%d", i+1), distributorURL))
+		}
+		balancer, err := emitters.NewBalancingEmitter("balancer", em.config.DeliveryMode, targets,
+			time.Duration(em.config.FailoverCooldown)*time.Millisecond)
+		if err != nil {
+			return err
+		}
+		em.balancer = balancer
+	}
+
 	emitterCounter := 1
 
 	for _, distributorURL := range em.config.DistributorURLs {
@@ -94,64 +251,127 @@ func (em *EmitterServer) initializeEmitters() error {
 		for j := 0; j < em.config.EmittersPerDistributor; j++ {
 			emitterID := fmt.Sprintf("emitter-%d", emitterCounter)
 
-			emitterConfig := models.EmitterConfig{
-				ID:             emitterID,
-				Endpoint:       distributorURL,
-				Timeout:        30 * time.Second,
-				RetryCount:     3,
-				RetryDelay:     1 * time.Second,
-				MaxConcurrency: em.config.MaxConcurrency,
-				BufferSize:     1000,
-				BatchSize:      em.config.BatchSize,
-				FlushInterval:  time.Duration(em.config.FlushInterval) * time.Millisecond,
+			emitterConfig := em.emitterConfig(emitterID, distributorURL)
+
+			// Packets the distributor does not take are spooled to disk when configured
+			var sender models.Emitter
+			if em.balancer != nil {
+				sender = em.balancer
+			} else {
+				sender = emitters.NewHTTPEmitter(emitterConfig)
+			}
+			if em.config.Spool.Dir != "" {
+				spoolConfig := em.config.Spool
+				spoolConfig.Dir = filepath.Join(spoolConfig.Dir, emitterID)
+				spool, err := emitters.NewSpoolingEmitter(spoolConfig, sender)
+				if err != nil {
+					return fmt.Errorf("failed to create spool for emitter %s: %w", emitterID, err)
+				}
+				em.spools = append(em.spools, spool)
+				sender = spool
 			}
 
-			emitter := emitters.NewHTTPEmitter(emitterConfig)
+			emitter, err := emitters.NewBufferedEmitter(emitterConfig, sender)
+			if err != nil {
+				return fmt.Errorf("failed to create emitter %s: %w", emitterID, err)
+			}
+			emitter.OnSend = func(packet models.LogPacket, elapsed time.Duration, err error) {
+				em.recordSend(emitterID, packet, elapsed, err)
+			}
+			em.buffers = append(em.buffers, emitter)
+			if em.multiline != nil {
+				em.loggers = append(em.loggers, emitters.NewMultilineLogger(em.multiline, emitter.Log))
+			}
 
 			// Add emitter to the pool
 			if err := em.emitterPool.AddEmitter(emitter); err != nil {
 				return fmt.Errorf("failed to add emitter %s to pool: %w", emitterID, err)
 			}
 
-			log.Printf("Initialized emitter %s for distributor %s", emitterID, distributorURL)
+			if em.balancer != nil {
+				log.Printf("Initialized emitter %s for %d distributors (%s)",
+					emitterID, len(em.config.DistributorURLs), em.config.DeliveryMode)
+			} else {
+				log.Printf("Initialized emitter %s for distributor %s", emitterID, distributorURL)
+			}
 			emitterCounter++
 		}
 	}
 
-	log.Printf("Initialized emitter pool with %d emitters (%d per distributor)",
-		em.emitterPool.GetEmitterCount(), em.config.EmittersPerDistributor)
+	log.Printf("Initialized emitter pool with %d emitters (%d per distributor, %s delivery)",
+		em.emitterPool.GetEmitterCount(), em.config.EmittersPerDistributor, em.config.DeliveryMode)
 	return nil
 }
 
+// emitterConfig returns the configuration of an emitter sending to endpoint
+func (em *EmitterServer) emitterConfig(id, endpoint string) models.EmitterConfig {
+	return models.EmitterConfig{
+		ID:             id,
+		Endpoint:       endpoint,
+		Timeout:        30 * time.Second,
+		RetryCount:     3,
+		RetryDelay:     1 * time.Second,
+		MaxRetryDelay:  10 * time.Second,
+		RetryJitter:    0.2,
+		MaxConcurrency: em.config.MaxConcurrency,
+		BufferSize:     em.config.BufferSize,
+		BatchSize:      em.config.BatchSize,
+		FlushInterval:  time.Duration(em.config.FlushInterval) * time.Millisecond,
+		OverflowPolicy: em.config.OverflowPolicy,
+		Compression:    em.config.Compression,
+		WireFormat:     em.config.WireFormat,
+	}
+}
+
+// pickEmitter returns the index of the next emitter in turn, used outside broadcast mode
+// so each log is sent by a single emitter
+func (em *EmitterServer) pickEmitter() int {
+	return int(em.nextEmitter.Add(1)-1) % len(em.buffers)
+}
+
+// logEvent hands the lines of one generated log to an emitter's buffer, through its
+// multiline logger when one is configured
+func (em *EmitterServer) logEvent(i int, messages []models.LogMessage) {
+	for _, message := range messages {
+		if em.loggers != nil {
+			em.loggers[i].Log(message)
+		} else {
+			em.buffers[i].Log(message)
+		}
+	}
+}
+
 // generateLogs creates a batch of log messages
 func (em *EmitterServer) generateLogs() models.LogPacket {
 	em.mu.Lock()
 	defer em.mu.Unlock()
 
-	// Generate log messages
-	messages := make([]models.LogMessage, em.config.BatchSize)
+	// Generate log messages; the lines of stack traces are joined when multiline is configured
+	var messages []models.LogMessage
+	add := func(message models.LogMessage) error {
+		messages = append(messages, message)
+		return nil
+	}
+	var joiner *emitters.MultilineLogger
+	if em.multiline != nil {
+		joiner = emitters.NewMultilineLogger(em.multiline, add)
+		add = joiner.Log
+	}
 	timestamp := time.Now()
 	packetID := fmt.Sprintf("packet-%d", em.stats.PacketsSent+1)
 
+	generated := 0
 	for i := 0; i < em.config.BatchSize; i++ {
-		// Generate random log data
-		logLevel := em.getRandomLogLevel()
-		source := em.getRandomSource()
-		message := em.getRandomMessage(logLevel, source)
-
-		messages[i] = models.LogMessage{
-			ID:        fmt.Sprintf("log-%s-%d", packetID, i+1),
-			Timestamp: timestamp.Add(time.Duration(i) * time.Millisecond),
-			Level:     logLevel,
-			Source:    source,
-			Message:   message,
-			Metadata: map[string]string{
-				"user_id":    fmt.Sprintf("user-%d", rand.Intn(1000)),
-				"session_id": fmt.Sprintf("session-%d", rand.Intn(10000)),
-				"ip":         fmt.Sprintf("192.168.1.%d", rand.Intn(255)),
-			},
+		lines := em.newLogEvent(fmt.Sprintf("log-%s-%d", packetID, i+1),
+			timestamp.Add(time.Duration(i)*time.Millisecond))
+		generated += len(lines)
+		for _, line := range lines {
+			add(line)
 		}
 	}
+	if joiner != nil {
+		joiner.Flush()
+	}
 
 	// Create log packet
 	packet := models.LogPacket{
@@ -162,13 +382,98 @@ func (em *EmitterServer) generateLogs() models.LogPacket {
 	}
 
 	// Update stats
-	em.stats.LogsGenerated += int64(len(messages))
+	em.stats.LogsGenerated += int64(generated)
 	em.stats.PacketsSent++
 	em.stats.LastActivityTime = time.Now()
+	em.logsGenerated.With().Add(float64(generated))
 
 	return packet
 }
 
+// generateLog creates a single log for continuous generation, returning one message per
+// line, so a stack trace comes back as several messages
+func (em *EmitterServer) generateLog() []models.LogMessage {
+	em.mu.Lock()
+	defer em.mu.Unlock()
+
+	lines := em.newLogEvent(fmt.Sprintf("log-%d", em.stats.LogsGenerated+1), time.Now())
+	em.stats.LogsGenerated += int64(len(lines))
+	em.stats.LastActivityTime = time.Now()
+	em.logsGenerated.With().Add(float64(len(lines)))
+	return lines
+}
+
+// newLogEvent creates a log message, or at stack_trace_rate the lines of a stack trace
+func (em *EmitterServer) newLogEvent(id string, timestamp time.Time) []models.LogMessage {
+	if em.config.StackTraceRate > 0 && rand.Float64() < em.config.StackTraceRate {
+		return em.newStackTrace(id, timestamp)
+	}
+	return []models.LogMessage{em.newLogMessage(id, timestamp)}
+}
+
+// newLogMessage creates a log message with random level, source and content
+func (em *EmitterServer) newLogMessage(id string, timestamp time.Time) models.LogMessage {
+	logLevel := em.getRandomLogLevel()
+	source := em.getRandomSource()
+	message := em.getRandomMessage(logLevel, source)
+
+	return models.LogMessage{
+		ID:        id,
+		Timestamp: timestamp,
+		Level:     logLevel,
+		Source:    source,
+		Message:   message,
+		Metadata: map[string]string{
+			"user_id":    fmt.Sprintf("user-%d", rand.Intn(1000)),
+			"session_id": fmt.Sprintf("session-%d", rand.Intn(10000)),
+			"ip":         fmt.Sprintf("192.168.1.%d", rand.Intn(255)),
+		},
+	}
+}
+
+// stackTraces are the lines of the stack traces the generator logs, after a first line
+// naming the source
+var stackTraces = [][]string{
+	{
+		"Unhandled exception processing request",
+		"java.lang.IllegalStateException: Gateway returned an invalid response",
+		"\tat com.example.gateway.GatewayClient.call(GatewayClient.java:142)",
+		"\tat com.example.service.RequestHandler.process(RequestHandler.java:87)",
+		"\tat com.example.api.Controller.handle(Controller.java:53)",
+		"Caused by: java.net.SocketTimeoutException: Read timed out",
+		"\tat java.base/java.net.SocketInputStream.read(SocketInputStream.java:168)",
+		"\t... 12 more",
+	},
+	{
+		"panic: runtime error: invalid memory address or nil pointer dereference",
+		"[signal SIGSEGV: segmentation violation code=0x1 addr=0x0 pc=0x4a2b3c]",
+		"",
+		"goroutine 42 [running]:",
+		"main.(*Handler).ServeHTTP(0x0, {0x7f1c20, 0xc0001a2000}, 0xc000196100)",
+		"\t/app/handler.go:42 +0x1c",
+		"net/http.serverHandler.ServeHTTP({0xc000150000}, {0x7f1c20, 0xc0001a2000}, 0xc000196100)",
+		"\t/usr/local/go/src/net/http/server.go:2936 +0x316",
+	},
+}
+
+// newStackTrace creates the lines of a random stack trace as ERROR messages from one
+// source. Only the first line starts with the [source] prefix every generated message has.
+func (em *EmitterServer) newStackTrace(id string, timestamp time.Time) []models.LogMessage {
+	first := em.newLogMessage(id, timestamp)
+	first.Level = "ERROR"
+	trace := stackTraces[rand.Intn(len(stackTraces))]
+	first.Message = fmt.Sprintf("[%s] %s", first.Source, trace[0])
+
+	lines := []models.LogMessage{first}
+	for i, text := range trace[1:] {
+		line := first
+		line.ID = fmt.Sprintf("%s-%d", id, i+2)
+		line.Message = text
+		lines = append(lines, line)
+	}
+	return lines
+}
+
 // getRandomLogLevel returns a random log level with weighted distribution
 func (em *EmitterServer) getRandomLogLevel() string {
 	levels := []string{"DEBUG", "INFO", "WARN", "ERROR"}
@@ -248,34 +553,52 @@ func (em *EmitterServer) getRandomMessage(level, source string) string {
 	return fmt.Sprintf("[%s] %s", source, levelMessages[rand.Intn(len(levelMessages))])
 }
 
-// sendLogs sends log packets to all distributors using the emitter pool
-func (em *EmitterServer) sendLogs(packet models.LogPacket) {
+// sendLogs sends a log packet and returns how many emitters sent it. In broadcast mode
+// every emitter in the pool sends it; otherwise a single emitter does.
+func (em *EmitterServer) sendLogs(packet models.LogPacket) int {
+	if em.balancer != nil {
+		em.buffers[em.pickEmitter()].Emit(packet)
+		return 1
+	}
+
 	// Get all emitters from the pool
 	allEmitters := em.emitterPool.GetAllEmitters()
 
 	var wg sync.WaitGroup
 	wg.Add(len(allEmitters))
 
-	for emitterID, emitter := range allEmitters {
-		go func(id string, e models.Emitter) {
+	for _, emitter := range allEmitters {
+		go func(e models.Emitter) {
 			defer wg.Done()
-
-			if err := e.Emit(packet); err != nil {
-				log.Printf("Emitter %s failed to send packet %s: %v", id, packet.PacketID, err)
-				em.mu.Lock()
-				em.stats.FailedSends++
-				em.mu.Unlock()
-			} else {
-				log.Printf("Emitter %s successfully sent packet %s with %d messages",
-					id, packet.PacketID, len(packet.Messages))
-				em.mu.Lock()
-				em.stats.SuccessfulSends++
-				em.mu.Unlock()
-			}
-		}(emitterID, emitter)
+			e.Emit(packet)
+		}(emitter)
 	}
 
 	wg.Wait()
+	return len(allEmitters)
+}
+
+// recordSend updates stats and metrics after an emitter sent a packet
+func (em *EmitterServer) recordSend(id string, packet models.LogPacket, elapsed time.Duration, err error) {
+	em.emitDuration.With(id).ObserveDuration(elapsed)
+	em.packetsSent.With(id).Inc()
+
+	if errors.Is(err, emitters.ErrSpooled) {
+		em.packetsSpooled.With(id).Inc()
+		log.Printf("Emitter %s spooled packet %s: %v", id, packet.PacketID, err)
+	} else if err != nil {
+		em.sendFailures.With(id).Inc()
+		log.Printf("Emitter %s failed to send packet %s: %v", id, packet.PacketID, err)
+		em.mu.Lock()
+		em.stats.FailedSends++
+		em.mu.Unlock()
+	} else {
+		log.Printf("Emitter %s successfully sent packet %s with %d messages",
+			id, packet.PacketID, len(packet.Messages))
+		em.mu.Lock()
+		em.stats.SuccessfulSends++
+		em.mu.Unlock()
+	}
 }
 
 // HTTP handlers
@@ -283,8 +606,18 @@ func (em *EmitterServer) sendLogs(packet models.LogPacket) {
 func (em *EmitterServer) handleHealth(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
+	em.mu.RLock()
+	draining := em.draining
+	em.mu.RUnlock()
+
+	status := "healthy"
+	if draining {
+		status = "draining"
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
 	response := map[string]interface{}{
-		"status":    "healthy",
+		"status":    status,
 		"service":   "emitter-server",
 		"timestamp": time.Now().Format(time.RFC3339),
 	}
@@ -315,8 +648,14 @@ func (em *EmitterServer) handleStats(w http.ResponseWriter, r *http.Request) {
 			"max_concurrency":          em.config.MaxConcurrency,
 			"batch_size":               em.config.BatchSize,
 			"flush_interval":           em.config.FlushInterval,
+			"buffer_size":              em.config.BufferSize,
+			"overflow_policy":          em.config.OverflowPolicy,
 			"distributor_count":        len(em.config.DistributorURLs),
 			"emitters_per_distributor": em.config.EmittersPerDistributor,
+			"delivery_mode":            em.config.DeliveryMode,
+			"stack_trace_rate":         em.config.StackTraceRate,
+			"compression":              em.config.Compression,
+			"wire_format":              em.config.WireFormat,
 		},
 		"emitter_pool": map[string]interface{}{
 			"count": em.emitterPool.GetEmitterCount(),
@@ -328,9 +667,26 @@ func (em *EmitterServer) handleStats(w http.ResponseWriter, r *http.Request) {
 				}
 				return ids
 			}(),
+			"buffers": func() map[string]emitters.BufferStats {
+				buffers := make(map[string]emitters.BufferStats, len(em.buffers))
+				for _, buffer := range em.buffers {
+					buffers[buffer.GetID()] = buffer.Stats()
+				}
+				return buffers
+			}(),
+			"spools": func() map[string]emitters.SpoolStats {
+				spools := make(map[string]emitters.SpoolStats, len(em.spools))
+				for _, spool := range em.spools {
+					spools[spool.GetID()] = spool.Stats()
+				}
+				return spools
+			}(),
 		},
 		"timestamp": time.Now().Format(time.RFC3339),
 	}
+	if em.balancer != nil {
+		response["distributors"] = em.balancer.Stats()
+	}
 
 	json.NewEncoder(w).Encode(response)
 }
@@ -339,7 +695,17 @@ func (em *EmitterServer) handleStart(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
 	// Start continuous log generation in background
-	go em.startContinuousGeneration()
+	em.mu.Lock()
+	if em.draining {
+		em.mu.Unlock()
+		http.Error(w, "Emitter server is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	if em.generating == nil {
+		em.generating = make(chan struct{})
+		go em.startContinuousGeneration(em.generating)
+	}
+	em.mu.Unlock()
 
 	response := map[string]interface{}{
 		"status":    "started",
@@ -354,7 +720,11 @@ func (em *EmitterServer) handleStart(w http.ResponseWriter, r *http.Request) {
 func (em *EmitterServer) handleStop(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	// Stop continuous generation (implement with context cancellation if needed)
+	// Stop continuous generation
+	em.mu.Lock()
+	em.stopGenerationLocked()
+	em.mu.Unlock()
+
 	response := map[string]interface{}{
 		"status":    "stopped",
 		"message":   "Log generation stopped",
@@ -368,11 +738,16 @@ func (em *EmitterServer) handleGenerateLogs(w http.ResponseWriter, r *http.Reque
 	w.Header().Set("Content-Type", "application/json")
 
 	// Generate and send a single batch of logs
+	if !em.beginSend() {
+		http.Error(w, "Emitter server is shutting down", http.StatusServiceUnavailable)
+		return
+	}
 	packet := em.generateLogs()
-	em.sendLogs(packet)
+	deliveries := em.sendLogs(packet)
+	em.sending.Done()
 
-	// Calculate total messages sent (packet messages × number of emitters)
-	totalMessages := len(packet.Messages) * em.emitterPool.GetEmitterCount()
+	// Calculate total messages sent (packet messages × number of emitters that sent it)
+	totalMessages := len(packet.Messages) * deliveries
 
 	response := map[string]interface{}{
 		"status":          "generated",
@@ -387,16 +762,35 @@ func (em *EmitterServer) handleGenerateLogs(w http.ResponseWriter, r *http.Reque
 	json.NewEncoder(w).Encode(response)
 }
 
-// startContinuousGeneration starts continuous log generation
-func (em *EmitterServer) startContinuousGeneration() {
-	ticker := time.NewTicker(time.Duration(1000/em.config.LogGenerationRate) * time.Millisecond)
+// startContinuousGeneration hands generated logs to every emitter's buffer until stop is
+// closed. The emitters batch them into packets by batch size and flush interval.
+func (em *EmitterServer) startContinuousGeneration(stop chan struct{}) {
+	ticker := time.NewTicker(time.Second / time.Duration(em.config.LogGenerationRate))
 	defer ticker.Stop()
 
 	log.Printf("Starting continuous log generation at %d logs/second", em.config.LogGenerationRate)
 
-	for range ticker.C {
-		packet := em.generateLogs()
-		em.sendLogs(packet)
+	for {
+		select {
+		case <-stop:
+			log.Printf("Continuous log generation stopped")
+			return
+		case <-ticker.C:
+		}
+
+		if !em.beginSend() {
+			return
+		}
+		// Messages dropped by the overflow policy are counted in the buffer stats
+		messages := em.generateLog()
+		if em.balancer != nil {
+			em.logEvent(em.pickEmitter(), messages)
+		} else {
+			for i := range em.buffers {
+				em.logEvent(i, messages)
+			}
+		}
+		em.sending.Done()
 	}
 }
 
@@ -418,6 +812,24 @@ func loadConfig(configPath string) (*EmitterServerConfig, error) {
 	if config.EmittersPerDistributor <= 0 {
 		config.EmittersPerDistributor = 5 // Default to 5 emitters per distributor
 	}
+	if config.BufferSize <= 0 {
+		config.BufferSize = 1000
+	}
+	if config.DeliveryMode == "" {
+		config.DeliveryMode = models.DeliveryBroadcast
+	}
+	if config.FailoverCooldown <= 0 {
+		config.FailoverCooldown = 10000
+	}
+	if config.OverflowPolicy == "" {
+		config.OverflowPolicy = models.OverflowBlock
+	}
+	if config.Compression == "" {
+		config.Compression = compression.Gzip
+	}
+	if config.WireFormat == "" {
+		config.WireFormat = models.WireFormatJSON
+	}
 
 	// Validate configuration
 	if config.Port <= 0 {
@@ -444,6 +856,47 @@ func loadConfig(configPath string) (*EmitterServerConfig, error) {
 		return nil, fmt.Errorf("invalid flush interval: %d", config.FlushInterval)
 	}
 
+	switch config.OverflowPolicy {
+	case models.OverflowBlock, models.OverflowDropOldest, models.OverflowDropNewest:
+	default:
+		return nil, fmt.Errorf("invalid overflow policy: %s", config.OverflowPolicy)
+	}
+
+	switch config.DeliveryMode {
+	case models.DeliveryBroadcast, models.DeliveryRoundRobin, models.DeliveryLeastLoaded, models.DeliveryFailover:
+	default:
+		return nil, fmt.Errorf("invalid delivery mode: %s", config.DeliveryMode)
+	}
+
+	if config.Spool.MaxBytes < 0 {
+		return nil, fmt.Errorf("invalid spool max bytes: %d", config.Spool.MaxBytes)
+	}
+	if config.Spool.RetryInterval < 0 {
+		return nil, fmt.Errorf("invalid spool retry interval: %d", config.Spool.RetryInterval)
+	}
+
+	if config.Shutdown.DrainDelay < 0 {
+		return nil, fmt.Errorf("invalid shutdown drain delay: %d", config.Shutdown.DrainDelay)
+	}
+	if config.Shutdown.Timeout <= 0 {
+		config.Shutdown.Timeout = 10000
+	}
+
+	if config.StackTraceRate < 0 || config.StackTraceRate > 1 {
+		return nil, fmt.Errorf("invalid stack trace rate: %.2f (must be between 0 and 1)", config.StackTraceRate)
+	}
+	if _, err := parser.NewMultiline(config.Multiline); err != nil {
+		return nil, err
+	}
+	if !compression.Valid(config.Compression) {
+		return nil, fmt.Errorf("invalid compression: %s", config.Compression)
+	}
+	switch config.WireFormat {
+	case models.WireFormatJSON, models.WireFormatBinary:
+	default:
+		return nil, fmt.Errorf("invalid wire format: %s", config.WireFormat)
+	}
+
 	log.Printf("Loaded configuration:")
 	log.Printf("  Port: %d", config.Port)
 	log.Printf("  Distributor URLs: %v", config.DistributorURLs)
@@ -451,7 +904,17 @@ func loadConfig(configPath string) (*EmitterServerConfig, error) {
 	log.Printf("  Max concurrency: %d", config.MaxConcurrency)
 	log.Printf("  Batch size: %d", config.BatchSize)
 	log.Printf("  Flush interval: %d ms", config.FlushInterval)
+	log.Printf("  Buffer size: %d (overflow policy: %s)", config.BufferSize, config.OverflowPolicy)
 	log.Printf("  Emitters per distributor: %d", config.EmittersPerDistributor)
+	log.Printf("  Delivery mode: %s", config.DeliveryMode)
+	log.Printf("  Compression: %s", config.Compression)
+	log.Printf("  Wire format: %s", config.WireFormat)
+	if config.Spool.Dir != "" {
+		log.Printf("  Spool: %s", config.Spool.Dir)
+	}
+	if config.StackTraceRate > 0 {
+		log.Printf("  Stack trace rate: %.2f", config.StackTraceRate)
+	}
 
 	return &config, nil
 }
@@ -471,7 +934,16 @@ func main() {
 	// Create and start emitter server
 	server := NewEmitterServer(*config)
 
-	if err := server.Start(); err != nil {
-		log.Fatalf("Failed to start emitter server: %v", err)
-	}
+	go func() {
+		if err := server.Start(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("Failed to start emitter server: %v", err)
+		}
+	}()
+
+	// Drain on SIGINT or SIGTERM
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-signals
+	log.Printf("%v received, shutting down emitter server", sig)
+	server.Shutdown()
 }
@@ -0,0 +1,161 @@
+package emitters
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"resolve/models"
+)
+
+// TargetStats describes one distributor behind a balancing emitter
+type TargetStats struct {
+	ID       string `json:"id"`
+	Endpoint string `json:"endpoint"`
+	InFlight int64  `json:"in_flight"`
+	Sent     int64  `json:"sent"`
+	Failed   int64  `json:"failed"`
+	Down     bool   `json:"down"`
+}
+
+// balancerTarget is a distributor emitter together with its load and availability
+type balancerTarget struct {
+	emitter   models.Emitter
+	inFlight  atomic.Int64
+	downUntil atomic.Int64 // unix nanoseconds until which the target is skipped
+	sent      atomic.Int64
+	failed    atomic.Int64
+}
+
+// down reports whether the target failed recently and is being skipped
+func (t *balancerTarget) down(now time.Time) bool {
+	return now.UnixNano() < t.downUntil.Load()
+}
+
+// BalancingEmitter delivers each packet to one of several distributors, chosen by the
+// delivery mode. When the chosen distributor fails after its own retries, the packet
+// moves on to the next one and the failed distributor is skipped for the cooldown, so
+// a packet is delivered once as long as any distributor is up.
+type BalancingEmitter struct {
+	id       string
+	mode     string
+	targets  []*balancerTarget
+	cooldown time.Duration
+	next     atomic.Uint64
+}
+
+// NewBalancingEmitter creates a balancing emitter over one emitter per distributor. In
+// failover mode the targets are tried in the order given.
+func NewBalancingEmitter(id, mode string, targets []models.Emitter, cooldown time.Duration) (*BalancingEmitter, error) {
+	switch mode {
+	case models.DeliveryRoundRobin, models.DeliveryLeastLoaded, models.DeliveryFailover:
+	default:
+		return nil, fmt.Errorf("invalid delivery mode %q: must be %s, %s or %s", mode,
+			models.DeliveryRoundRobin, models.DeliveryLeastLoaded, models.DeliveryFailover)
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("no distributors to balance across")
+	}
+
+	e := &BalancingEmitter{
+		id:       id,
+		mode:     mode,
+		cooldown: cooldown,
+	}
+	for _, target := range targets {
+		e.targets = append(e.targets, &balancerTarget{emitter: target})
+	}
+	return e, nil
+}
+
+// Emit delivers a packet to one distributor
+func (e *BalancingEmitter) Emit(packet models.LogPacket) error {
+	return e.EmitContext(context.Background(), packet)
+}
+
+// EmitContext delivers a packet to one distributor, failing over to the others in turn.
+// A packet one distributor rejects is not offered to the rest.
+func (e *BalancingEmitter) EmitContext(ctx context.Context, packet models.LogPacket) error {
+	var lastErr error
+	for _, target := range e.order() {
+		target.inFlight.Add(1)
+		err := target.emitter.EmitContext(ctx, packet)
+		target.inFlight.Add(-1)
+
+		if err == nil {
+			target.sent.Add(1)
+			target.downUntil.Store(0)
+			return nil
+		}
+		target.failed.Add(1)
+		if ctx.Err() != nil || !Retriable(err) {
+			return err
+		}
+		target.downUntil.Store(time.Now().Add(e.cooldown).UnixNano())
+		lastErr = fmt.Errorf("%s: %w", target.emitter.GetEndpoint(), err)
+	}
+	return fmt.Errorf("all %d distributors failed, last error: %w", len(e.targets), lastErr)
+}
+
+// order returns the targets in the order this packet should try them. Targets that
+// failed recently go last, so they are only used when every other distributor is down.
+func (e *BalancingEmitter) order() []*balancerTarget {
+	n := len(e.targets)
+	order := make([]*balancerTarget, 0, n)
+
+	switch e.mode {
+	case models.DeliveryFailover:
+		order = append(order, e.targets...)
+	case models.DeliveryRoundRobin, models.DeliveryLeastLoaded:
+		// Rotate the starting point so ties are spread across distributors
+		start := int(e.next.Add(1)-1) % n
+		for i := 0; i < n; i++ {
+			order = append(order, e.targets[(start+i)%n])
+		}
+		if e.mode == models.DeliveryLeastLoaded {
+			sort.SliceStable(order, func(i, j int) bool {
+				return order[i].inFlight.Load() < order[j].inFlight.Load()
+			})
+		}
+	}
+
+	now := time.Now()
+	sort.SliceStable(order, func(i, j int) bool {
+		return !order[i].down(now) && order[j].down(now)
+	})
+	return order
+}
+
+// Stats returns the load and availability of every distributor
+func (e *BalancingEmitter) Stats() []TargetStats {
+	now := time.Now()
+	stats := make([]TargetStats, len(e.targets))
+	for i, target := range e.targets {
+		stats[i] = TargetStats{
+			ID:       target.emitter.GetID(),
+			Endpoint: target.emitter.GetEndpoint(),
+			InFlight: target.inFlight.Load(),
+			Sent:     target.sent.Load(),
+			Failed:   target.failed.Load(),
+			Down:     target.down(now),
+		}
+	}
+	return stats
+}
+
+// GetID returns the emitter ID
+func (e *BalancingEmitter) GetID() string {
+	return e.id
+}
+
+// GetEndpoint returns the distributor endpoints, comma-separated
+func (e *BalancingEmitter) GetEndpoint() string {
+	endpoints := make([]string, len(e.targets))
+	for i, target := range e.targets {
+		endpoints[i] = target.emitter.GetEndpoint()
+	}
+	return strings.Join(endpoints, ",")
+}
@@ -0,0 +1,280 @@
+package emitters
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"resolve/models"
+)
+
+// ErrBufferFull is returned by Log when the buffer is full and the overflow policy is drop_newest
+var ErrBufferFull = errors.New("emitter buffer is full")
+
+// ErrEmitterClosed is returned by Log once the emitter has been closed
+var ErrEmitterClosed = errors.New("emitter is closed")
+
+// BufferStats describes the state of a buffered emitter
+type BufferStats struct {
+	Buffered     int   `json:"buffered"`
+	Capacity     int   `json:"capacity"`
+	InFlight     int   `json:"in_flight"`
+	Dropped      int64 `json:"dropped"`
+	PacketsSent  int64 `json:"packets_sent"`
+	SendFailures int64 `json:"send_failures"`
+}
+
+// BufferedEmitter collects individual log messages and sends them as packets through
+// another emitter. A packet is sent once BatchSize messages are buffered or FlushInterval
+// has passed, with at most MaxConcurrency packets in flight. When BufferSize messages are
+// waiting, the overflow policy decides whether Log blocks or a message is dropped.
+type BufferedEmitter struct {
+	sender models.Emitter
+	config models.EmitterConfig
+
+	// OnSend is called after every packet this emitter sends, whether it was built from
+	// logged messages or passed to Emit. Set it before the first Log or Emit call.
+	OnSend func(packet models.LogPacket, elapsed time.Duration, err error)
+
+	mu       sync.Mutex
+	notFull  *sync.Cond
+	buffer   []models.LogMessage
+	closed   bool
+	sequence int64
+
+	ctx      context.Context // sends of buffered packets, cancelled when Close runs out of time
+	cancel   context.CancelFunc
+	ready    chan struct{} // signalled when a full batch is buffered
+	done     chan struct{} // closed by Close
+	finished chan struct{} // closed when the flush loop has exited
+	slots    chan struct{} // one token per in-flight packet
+	sends    sync.WaitGroup
+
+	dropped atomic.Int64
+	sent    atomic.Int64
+	failed  atomic.Int64
+}
+
+// NewBufferedEmitter creates a buffered emitter that sends its packets through sender
+// and starts its flush loop
+func NewBufferedEmitter(config models.EmitterConfig, sender models.Emitter) (*BufferedEmitter, error) {
+	// Set default values if not provided
+	if config.BufferSize <= 0 {
+		config.BufferSize = 1000
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = 100
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = time.Second
+	}
+	if config.MaxConcurrency <= 0 {
+		config.MaxConcurrency = 1
+	}
+	if config.OverflowPolicy == "" {
+		config.OverflowPolicy = models.OverflowBlock
+	}
+
+	switch config.OverflowPolicy {
+	case models.OverflowBlock, models.OverflowDropOldest, models.OverflowDropNewest:
+	default:
+		return nil, fmt.Errorf("invalid overflow policy %q: must be %s, %s or %s", config.OverflowPolicy,
+			models.OverflowBlock, models.OverflowDropOldest, models.OverflowDropNewest)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	e := &BufferedEmitter{
+		sender:   sender,
+		config:   config,
+		ctx:      ctx,
+		cancel:   cancel,
+		buffer:   make([]models.LogMessage, 0, config.BatchSize),
+		ready:    make(chan struct{}, 1),
+		done:     make(chan struct{}),
+		finished: make(chan struct{}),
+		slots:    make(chan struct{}, config.MaxConcurrency),
+	}
+	e.notFull = sync.NewCond(&e.mu)
+
+	go e.run()
+	return e, nil
+}
+
+// Log adds a message to the buffer without waiting for it to be sent. When the buffer
+// is full it blocks, drops the oldest message or returns ErrBufferFull, depending on
+// the overflow policy.
+func (e *BufferedEmitter) Log(message models.LogMessage) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for !e.closed && len(e.buffer) >= e.config.BufferSize {
+		switch e.config.OverflowPolicy {
+		case models.OverflowDropNewest:
+			e.dropped.Add(1)
+			return ErrBufferFull
+		case models.OverflowDropOldest:
+			e.buffer = e.buffer[1:]
+			e.dropped.Add(1)
+		default:
+			e.notFull.Wait()
+		}
+	}
+	if e.closed {
+		return ErrEmitterClosed
+	}
+
+	e.buffer = append(e.buffer, message)
+	if len(e.buffer) >= e.config.BatchSize {
+		select {
+		case e.ready <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// Emit sends a ready-made packet straight away, sharing the in-flight limit with
+// packets built from logged messages
+func (e *BufferedEmitter) Emit(packet models.LogPacket) error {
+	return e.EmitContext(context.Background(), packet)
+}
+
+// EmitContext is Emit with a context that bounds both the wait for an in-flight slot
+// and the send itself
+func (e *BufferedEmitter) EmitContext(ctx context.Context, packet models.LogPacket) error {
+	select {
+	case e.slots <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { <-e.slots }()
+	return e.send(ctx, packet)
+}
+
+// Close stops accepting messages, sends everything still buffered and waits for the
+// packets in flight. If ctx ends first, the remaining sends are cancelled, which makes
+// a spooling emitter underneath spool them, and ctx's error is returned.
+func (e *BufferedEmitter) Close(ctx context.Context) error {
+	e.mu.Lock()
+	if !e.closed {
+		e.closed = true
+		close(e.done)
+		e.notFull.Broadcast()
+	}
+	e.mu.Unlock()
+
+	defer e.cancel()
+	select {
+	case <-e.finished:
+		return nil
+	case <-ctx.Done():
+		e.cancel()
+		<-e.finished
+		return ctx.Err()
+	}
+}
+
+// Stats returns the buffer depth and send counters
+func (e *BufferedEmitter) Stats() BufferStats {
+	e.mu.Lock()
+	buffered := len(e.buffer)
+	e.mu.Unlock()
+
+	return BufferStats{
+		Buffered:     buffered,
+		Capacity:     e.config.BufferSize,
+		InFlight:     len(e.slots),
+		Dropped:      e.dropped.Load(),
+		PacketsSent:  e.sent.Load(),
+		SendFailures: e.failed.Load(),
+	}
+}
+
+// GetID returns the emitter ID
+func (e *BufferedEmitter) GetID() string {
+	return e.config.ID
+}
+
+// GetEndpoint returns the endpoint of the underlying emitter
+func (e *BufferedEmitter) GetEndpoint() string {
+	return e.sender.GetEndpoint()
+}
+
+// run sends full batches as soon as they are buffered and partial ones every flush
+// interval. On close it sends what is left and waits for the packets in flight.
+func (e *BufferedEmitter) run() {
+	defer close(e.finished)
+
+	ticker := time.NewTicker(e.config.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-e.ready:
+			e.flush(false)
+		case <-ticker.C:
+			e.flush(true)
+		case <-e.done:
+			e.flush(true)
+			e.sends.Wait()
+			return
+		}
+	}
+}
+
+// flush sends buffered messages in batches of up to BatchSize. Unless partial is set,
+// only full batches are sent. Each batch waits for an in-flight slot before it leaves
+// the buffer, so a slow distributor fills the buffer and triggers the overflow policy.
+func (e *BufferedEmitter) flush(partial bool) {
+	for {
+		e.slots <- struct{}{}
+
+		e.mu.Lock()
+		n := len(e.buffer)
+		if n > e.config.BatchSize {
+			n = e.config.BatchSize
+		}
+		if n == 0 || (!partial && n < e.config.BatchSize) {
+			e.mu.Unlock()
+			<-e.slots
+			return
+		}
+		messages := make([]models.LogMessage, n)
+		copy(messages, e.buffer)
+		e.buffer = e.buffer[n:]
+		e.sequence++
+		packet := models.LogPacket{
+			PacketID:  fmt.Sprintf("%s-packet-%d", e.config.ID, e.sequence),
+			AgentID:   e.config.ID,
+			Timestamp: time.Now(),
+			Messages:  messages,
+		}
+		e.notFull.Broadcast()
+		e.mu.Unlock()
+
+		e.sends.Add(1)
+		go func() {
+			defer e.sends.Done()
+			defer func() { <-e.slots }()
+			e.send(e.ctx, packet)
+		}()
+	}
+}
+
+// send emits a packet through the underlying emitter and records the outcome
+func (e *BufferedEmitter) send(ctx context.Context, packet models.LogPacket) error {
+	start := time.Now()
+	err := e.sender.EmitContext(ctx, packet)
+	if err != nil {
+		e.failed.Add(1)
+	} else {
+		e.sent.Add(1)
+	}
+	if e.OnSend != nil {
+		e.OnSend(packet, time.Since(start), err)
+	}
+	return err
+}
@@ -5,18 +5,26 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"time"
 
+	"resolve/compression"
 	"resolve/models"
+	"resolve/wire"
 )
 
+// maxDrainedBody bounds how much of a response body is read before the connection is reused
+const maxDrainedBody = 64 * 1024
+
 // HTTPEmitter implements the Emitter interface for sending log packets via HTTP
 type HTTPEmitter struct {
 	id       string
 	endpoint string
 	client   *http.Client
 	config   models.EmitterConfig
+	retry    RetryPolicy
+	peer     *compression.Peer // packet encoding negotiated with the distributor
 }
 
 // NewHTTPEmitter creates a new HTTP emitter
@@ -30,6 +38,8 @@ func NewHTTPEmitter(config models.EmitterConfig) *HTTPEmitter {
 		endpoint: config.Endpoint,
 		client:   client,
 		config:   config,
+		retry:    NewRetryPolicy(config),
+		peer:     compression.NewPeer(config.Compression),
 	}
 
 	return emitter
@@ -37,48 +47,99 @@ func NewHTTPEmitter(config models.EmitterConfig) *HTTPEmitter {
 
 // Emit sends a log packet to the distributor
 func (e *HTTPEmitter) Emit(packet models.LogPacket) error {
-	// Serialize packet to JSON
-	jsonData, err := json.Marshal(packet)
+	return e.EmitContext(context.Background(), packet)
+}
+
+// EmitContext sends a log packet to the distributor, retrying temporary failures with
+// exponential backoff until the retry policy gives up or ctx is done. Packets the
+// distributor rejects with a client error are not retried.
+func (e *HTTPEmitter) EmitContext(ctx context.Context, packet models.LogPacket) error {
+	// Serialize packet in the configured wire format
+	data, err := e.marshal(packet)
 	if err != nil {
-		return fmt.Errorf("failed to marshal packet: %w", err)
+		return &terminalError{fmt.Errorf("failed to marshal packet: %w", err)}
 	}
 
-	// Create HTTP request
-	req, err := http.NewRequestWithContext(
-		context.Background(),
-		"POST",
-		e.endpoint,
-		bytes.NewBuffer(jsonData),
-	)
-	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+	for attempt := 1; ; attempt++ {
+		err = e.post(ctx, data)
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return fmt.Errorf("send cancelled after %d attempts: %w", attempt, ctx.Err())
+		}
+		if !Retriable(err) {
+			return fmt.Errorf("distributor rejected packet: %w", err)
+		}
+		if attempt >= e.retry.MaxAttempts {
+			return fmt.Errorf("failed to send request after %d attempts: %w", attempt, err)
+		}
+		if err := sleepContext(ctx, e.retry.Delay(attempt, err)); err != nil {
+			return fmt.Errorf("send cancelled after %d attempts: %w", attempt, err)
+		}
 	}
+}
 
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("User-Agent", "log-emitter/1.0")
+// marshal encodes a packet as JSON or, with the binary wire format, in the wire package's
+// format
+func (e *HTTPEmitter) marshal(packet models.LogPacket) ([]byte, error) {
+	if e.config.WireFormat == models.WireFormatBinary {
+		return wire.Marshal(packet), nil
+	}
+	return json.Marshal(packet)
+}
 
-	// Send request with retries
-	var resp *http.Response
-	for attempt := 0; attempt <= e.config.RetryCount; attempt++ {
-		resp, err = e.client.Do(req)
-		if err == nil && resp.StatusCode == http.StatusOK {
-			break
+// post makes a single attempt with a fresh request, since a request body cannot be
+// read twice. A packet the distributor refuses for its encoding is sent once more
+// without it.
+func (e *HTTPEmitter) post(ctx context.Context, data []byte) error {
+	for attempt := 1; ; attempt++ {
+		body, encoding, err := e.peer.Encode(data)
+		if err != nil {
+			return &terminalError{fmt.Errorf("failed to encode packet: %w", err)}
 		}
 
-		if attempt < e.config.RetryCount {
-			time.Sleep(e.config.RetryDelay)
+		req, err := http.NewRequestWithContext(ctx, "POST", e.endpoint, bytes.NewReader(body))
+		if err != nil {
+			return &terminalError{fmt.Errorf("failed to create request: %w", err)}
 		}
-	}
 
-	if err != nil {
-		return fmt.Errorf("failed to send request after %d attempts: %w", e.config.RetryCount+1, err)
-	}
+		if e.config.WireFormat == models.WireFormatBinary {
+			req.Header.Set("Content-Type", wire.ContentType)
+		} else {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		req.Header.Set("User-Agent", "log-emitter/1.0")
+		if encoding != "" {
+			req.Header.Set("Content-Encoding", encoding)
+		}
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("distributor returned status code: %d", resp.StatusCode)
+		resp, err := e.client.Do(req)
+		if err != nil {
+			return err
+		}
+
+		// Drain the body so the connection can be reused
+		io.Copy(io.Discard, io.LimitReader(resp.Body, maxDrainedBody))
+		resp.Body.Close()
+
+		if e.peer.Update(resp, encoding) && attempt == 1 {
+			continue
+		}
+		if !isAccepted(resp.StatusCode) {
+			return &StatusError{
+				StatusCode: resp.StatusCode,
+				RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+			}
+		}
+		return nil
 	}
+}
 
-	return nil
+// isAccepted reports whether the distributor took responsibility for the packet.
+// A durable distributor answers 202 Accepted once the packet is journaled.
+func isAccepted(statusCode int) bool {
+	return statusCode == http.StatusOK || statusCode == http.StatusAccepted
 }
 
 // GetID returns the emitter ID
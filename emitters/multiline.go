@@ -0,0 +1,95 @@
+package emitters
+
+import (
+	"sync"
+	"time"
+
+	"resolve/models"
+	"resolve/parser"
+)
+
+// MultilineLogger joins log messages that continue the message before them, such as the
+// lines of a stack trace logged one at a time, into one message before handing it on,
+// typically to a BufferedEmitter's Log. A message continues the pending one when it has
+// the same source and its text continues it by the multiline settings. The pending
+// message is handed on when a new one starts, when it reaches MaxLines, when no line was
+// added for FlushTimeout, or on Flush.
+type MultilineLogger struct {
+	multiline *parser.Multiline
+	next      func(models.LogMessage) error
+
+	mu       sync.Mutex
+	pending  *models.LogMessage
+	lines    int
+	deadline time.Time
+	timer    *time.Timer
+}
+
+// NewMultilineLogger creates a multiline logger that hands joined messages to next
+func NewMultilineLogger(multiline *parser.Multiline, next func(models.LogMessage) error) *MultilineLogger {
+	return &MultilineLogger{
+		multiline: multiline,
+		next:      next,
+	}
+}
+
+// Log adds a message to the pending one or starts a new one. The error is the one
+// returned for the previous message when it was handed on.
+func (l *MultilineLogger) Log(message models.LogMessage) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.pending != nil && l.lines < l.multiline.MaxLines &&
+		message.Source == l.pending.Source && l.multiline.Continues(message.Message) {
+		l.pending.Message += "\n" + message.Message
+		l.lines++
+		l.deadline = time.Now().Add(l.multiline.FlushTimeout)
+		return nil
+	}
+
+	err := l.flushLocked()
+	l.pending = &message
+	l.lines = 1
+	l.deadline = time.Now().Add(l.multiline.FlushTimeout)
+	if l.timer == nil {
+		l.timer = time.AfterFunc(l.multiline.FlushTimeout, l.expire)
+	} else {
+		l.timer.Reset(l.multiline.FlushTimeout)
+	}
+	return err
+}
+
+// Flush hands on the pending message
+func (l *MultilineLogger) Flush() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.flushLocked()
+}
+
+// expire hands on the pending message once it has waited FlushTimeout for more lines.
+// Errors are counted by the next logger, such as a buffer's dropped messages.
+func (l *MultilineLogger) expire() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.pending == nil {
+		return
+	}
+	if wait := time.Until(l.deadline); wait > 0 {
+		// A line arrived since the timer was set
+		l.timer.Reset(wait)
+		return
+	}
+	l.flushLocked()
+}
+
+// flushLocked hands on the pending message. The caller must hold mu.
+func (l *MultilineLogger) flushLocked() error {
+	if l.pending == nil {
+		return nil
+	}
+	message := *l.pending
+	l.pending = nil
+	l.timer.Stop()
+	return l.next(message)
+}
@@ -0,0 +1,145 @@
+package emitters
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"resolve/models"
+)
+
+// StatusError is returned when the distributor answers with a status other than 200 or 202
+type StatusError struct {
+	StatusCode int
+	RetryAfter time.Duration // from the Retry-After header of a 429 or 503, zero when absent
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("distributor returned status code: %d", e.StatusCode)
+}
+
+// Temporary reports whether the same request may succeed later. Client errors other
+// than timeouts and rate limiting never will.
+func (e *StatusError) Temporary() bool {
+	switch e.StatusCode {
+	case http.StatusRequestTimeout, http.StatusTooEarly, http.StatusTooManyRequests:
+		return true
+	case http.StatusNotImplemented, http.StatusHTTPVersionNotSupported:
+		return false
+	}
+	return e.StatusCode >= 500
+}
+
+// Retriable reports whether a failed send is worth trying again. Network errors,
+// timeouts and temporary status codes are; rejected packets are not.
+func Retriable(err error) bool {
+	if err == nil {
+		return false
+	}
+	var status *StatusError
+	if errors.As(err, &status) {
+		return status.Temporary()
+	}
+	var terminal *terminalError
+	return !errors.As(err, &terminal)
+}
+
+// terminalError marks failures that retrying cannot fix, such as a packet that cannot be encoded
+type terminalError struct{ err error }
+
+func (e *terminalError) Error() string { return e.err.Error() }
+func (e *terminalError) Unwrap() error { return e.err }
+
+// RetryPolicy decides how many times a packet is sent and how long to wait in between
+type RetryPolicy struct {
+	MaxAttempts    int // attempts including the first
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+	Jitter         float64 // fraction of each backoff randomized, 0 to 1
+}
+
+// NewRetryPolicy builds the retry policy described by an emitter configuration
+func NewRetryPolicy(config models.EmitterConfig) RetryPolicy {
+	policy := RetryPolicy{
+		MaxAttempts:    config.RetryCount + 1,
+		InitialBackoff: config.RetryDelay,
+		MaxBackoff:     config.MaxRetryDelay,
+		Multiplier:     2,
+		Jitter:         config.RetryJitter,
+	}
+
+	// Set default values if not provided
+	if policy.MaxAttempts < 1 {
+		policy.MaxAttempts = 1
+	}
+	if policy.InitialBackoff <= 0 {
+		policy.InitialBackoff = time.Second
+	}
+	if policy.MaxBackoff < policy.InitialBackoff {
+		policy.MaxBackoff = 30 * policy.InitialBackoff
+	}
+	if policy.Jitter < 0 || policy.Jitter > 1 {
+		policy.Jitter = 0
+	}
+	return policy
+}
+
+// Backoff returns the exponential backoff with jitter before the given retry, counting from 1
+func (p RetryPolicy) Backoff(retry int) time.Duration {
+	exponent := retry - 1
+	if exponent < 0 {
+		exponent = 0
+	}
+	backoff := math.Min(float64(p.InitialBackoff)*math.Pow(p.Multiplier, float64(exponent)), float64(p.MaxBackoff))
+
+	// Spread retries of emitters that failed together so they do not arrive in lockstep
+	if p.Jitter > 0 {
+		backoff *= 1 - p.Jitter + rand.Float64()*2*p.Jitter
+	}
+	return time.Duration(backoff)
+}
+
+// Delay returns how long to wait after a failed attempt. A Retry-After sent by the
+// distributor takes precedence over the backoff.
+func (p RetryPolicy) Delay(retry int, err error) time.Duration {
+	var status *StatusError
+	if errors.As(err, &status) && status.RetryAfter > 0 {
+		return status.RetryAfter
+	}
+	return p.Backoff(retry)
+}
+
+// sleepContext waits for d, returning early with ctx's error once ctx is done
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// parseRetryAfter reads a Retry-After header given in seconds or as an HTTP date
+func parseRetryAfter(header string, now time.Time) time.Duration {
+	if header == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(header); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(header); err == nil && date.After(now) {
+		return date.Sub(now)
+	}
+	return 0
+}
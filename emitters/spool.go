@@ -0,0 +1,400 @@
+package emitters
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"resolve/models"
+	"resolve/wal"
+)
+
+// ErrSpooled is returned, wrapping the delivery error, when a packet was written to the
+// spool instead of being delivered. The spool delivers it once the distributor is back.
+var ErrSpooled = errors.New("packet spooled")
+
+const (
+	spoolOpAdd = "add"
+	spoolOpAck = "ack"
+
+	defaultSpoolMaxBytes  = 64 * 1024 * 1024
+	minSpoolSegmentSize   = 64 * 1024
+	spoolSegmentsPerLimit = 8 // segments the size limit is split into, so eviction frees an eighth at a time
+)
+
+// spoolRecord is a single entry in the spool's write-ahead log
+type spoolRecord struct {
+	Op     string            `json:"op"`               // add or ack
+	Seq    uint64            `json:"seq,omitempty"`    // sequence being acknowledged
+	Packet *models.LogPacket `json:"packet,omitempty"` // packet being spooled
+}
+
+// SpoolStats describes the contents of an emitter's spool
+type SpoolStats struct {
+	Packets  int   `json:"packets"`
+	Bytes    int64 `json:"bytes"`
+	MaxBytes int64 `json:"max_bytes"`
+	Spooled  int64 `json:"spooled"`
+	Drained  int64 `json:"drained"`
+	Evicted  int64 `json:"evicted"`
+	Rejected int64 `json:"rejected"` // spooled packets the distributor refused for good
+}
+
+// SpoolingEmitter writes packets another emitter failed to deliver to a spool directory
+// and delivers them in order from a background goroutine once the distributor accepts
+// packets again. While the spool holds packets, new packets are spooled behind them so
+// they keep their order. When the spool outgrows MaxBytes the oldest packets are evicted.
+type SpoolingEmitter struct {
+	next   models.Emitter
+	config models.SpoolConfig
+	log    *wal.Log
+
+	mu      sync.Mutex
+	pending []uint64 // sequence numbers of spooled packets, oldest first
+	closed  bool
+
+	ctx      context.Context // deliveries of spooled packets, cancelled by Close
+	cancel   context.CancelFunc
+	wake     chan struct{} // signalled when a packet is spooled
+	done     chan struct{} // closed by Close
+	finished chan struct{} // closed when the drain loop has exited
+
+	spooled  atomic.Int64
+	drained  atomic.Int64
+	evicted  atomic.Int64
+	rejected atomic.Int64
+}
+
+// NewSpoolingEmitter opens the spool directory, recovers packets left in it and starts
+// draining them through next
+func NewSpoolingEmitter(config models.SpoolConfig, next models.Emitter) (*SpoolingEmitter, error) {
+	// Set default values if not provided
+	if config.MaxBytes <= 0 {
+		config.MaxBytes = defaultSpoolMaxBytes
+	}
+	if config.RetryInterval <= 0 {
+		config.RetryInterval = 5000
+	}
+
+	segmentSize := config.MaxBytes / spoolSegmentsPerLimit
+	if segmentSize < minSpoolSegmentSize {
+		segmentSize = minSpoolSegmentSize
+	}
+	l, err := wal.Open(config.Dir, wal.Options{
+		SegmentSize: segmentSize,
+		SyncPolicy:  wal.SyncPolicy(config.SyncPolicy),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open spool: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	e := &SpoolingEmitter{
+		next:     next,
+		ctx:      ctx,
+		cancel:   cancel,
+		config:   config,
+		log:      l,
+		wake:     make(chan struct{}, 1),
+		done:     make(chan struct{}),
+		finished: make(chan struct{}),
+	}
+	if err := e.recover(); err != nil {
+		cancel()
+		l.Close()
+		return nil, err
+	}
+	if len(e.pending) > 0 {
+		log.Printf("[SPOOL] Recovered %d packets for %s from %s", len(e.pending), next.GetID(), config.Dir)
+	}
+
+	go e.drain()
+	return e, nil
+}
+
+// recover rebuilds the list of spooled packets from the write-ahead log
+func (e *SpoolingEmitter) recover() error {
+	pending := make(map[uint64]struct{})
+	err := e.log.Replay(func(index uint64, data []byte) error {
+		var record spoolRecord
+		if err := json.Unmarshal(data, &record); err != nil {
+			log.Printf("[SPOOL] Skipping unreadable record %d in %s: %v", index, e.config.Dir, err)
+			return nil
+		}
+		switch record.Op {
+		case spoolOpAdd:
+			pending[index] = struct{}{}
+		case spoolOpAck:
+			delete(pending, record.Seq)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to replay spool: %w", err)
+	}
+
+	for seq := range pending {
+		e.pending = append(e.pending, seq)
+	}
+	sort.Slice(e.pending, func(i, j int) bool {
+		return e.pending[i] < e.pending[j]
+	})
+	return e.truncate()
+}
+
+// Emit delivers a packet through the underlying emitter, or spools it when delivery
+// fails or older packets are still spooled. Spooled packets return an error wrapping
+// ErrSpooled.
+func (e *SpoolingEmitter) Emit(packet models.LogPacket) error {
+	return e.EmitContext(context.Background(), packet)
+}
+
+// EmitContext is Emit with a context bounding the delivery attempt. A packet whose
+// attempt is cancelled is spooled; one the distributor rejects for good is not.
+func (e *SpoolingEmitter) EmitContext(ctx context.Context, packet models.LogPacket) error {
+	e.mu.Lock()
+	backlog := len(e.pending)
+	e.mu.Unlock()
+
+	var cause error
+	if backlog == 0 {
+		cause = e.next.EmitContext(ctx, packet)
+		if cause == nil || !Retriable(cause) {
+			return cause
+		}
+	} else {
+		cause = fmt.Errorf("%d older packets are spooled", backlog)
+	}
+
+	if err := e.spool(packet); err != nil {
+		return fmt.Errorf("%v, and spooling failed: %w", cause, err)
+	}
+	return fmt.Errorf("%w: %v", ErrSpooled, cause)
+}
+
+// Close stops draining and closes the spool. Packets still spooled are delivered after
+// the next start.
+func (e *SpoolingEmitter) Close(ctx context.Context) error {
+	e.mu.Lock()
+	if e.closed {
+		e.mu.Unlock()
+		return nil
+	}
+	e.closed = true
+	close(e.done)
+	e.cancel()
+	e.mu.Unlock()
+
+	select {
+	case <-e.finished:
+	case <-ctx.Done():
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.log.Close()
+}
+
+// Stats returns the spool depth and counters
+func (e *SpoolingEmitter) Stats() SpoolStats {
+	e.mu.Lock()
+	packets := len(e.pending)
+	e.mu.Unlock()
+
+	return SpoolStats{
+		Packets:  packets,
+		Bytes:    e.log.Size(),
+		MaxBytes: e.config.MaxBytes,
+		Spooled:  e.spooled.Load(),
+		Drained:  e.drained.Load(),
+		Evicted:  e.evicted.Load(),
+		Rejected: e.rejected.Load(),
+	}
+}
+
+// GetID returns the ID of the underlying emitter
+func (e *SpoolingEmitter) GetID() string {
+	return e.next.GetID()
+}
+
+// GetEndpoint returns the endpoint of the underlying emitter
+func (e *SpoolingEmitter) GetEndpoint() string {
+	return e.next.GetEndpoint()
+}
+
+// spool appends a packet to the spool, evicting the oldest packets when it is full
+func (e *SpoolingEmitter) spool(packet models.LogPacket) error {
+	data, err := json.Marshal(spoolRecord{Op: spoolOpAdd, Packet: &packet})
+	if err != nil {
+		return fmt.Errorf("failed to marshal spool record: %w", err)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.closed {
+		return wal.ErrClosed
+	}
+	seq, err := e.log.Append(data)
+	if err != nil {
+		return err
+	}
+	e.pending = append(e.pending, seq)
+	e.spooled.Add(1)
+
+	for e.log.Size() > e.config.MaxBytes && e.log.SegmentCount() > 1 {
+		first, err := e.log.DropOldest()
+		if err != nil {
+			return err
+		}
+		evicted := 0
+		for len(e.pending) > 0 && e.pending[0] < first {
+			e.pending = e.pending[1:]
+			evicted++
+		}
+		e.evicted.Add(int64(evicted))
+		log.Printf("[SPOOL] %s is over %d bytes, evicted the %d oldest packets",
+			e.config.Dir, e.config.MaxBytes, evicted)
+	}
+
+	select {
+	case e.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// drain delivers spooled packets oldest first, waiting RetryInterval after each failure
+func (e *SpoolingEmitter) drain() {
+	defer close(e.finished)
+
+	retryInterval := time.Duration(e.config.RetryInterval) * time.Millisecond
+
+	// One reader follows the spool for the whole drain, so each packet is read once
+	cursor := e.log.NewReader(0)
+	defer func() { cursor.Close() }()
+	var (
+		packet models.LogPacket
+		loaded uint64 // sequence number of packet, 0 while none is loaded
+	)
+	for {
+		e.mu.Lock()
+		if len(e.pending) == 0 {
+			e.mu.Unlock()
+			select {
+			case <-e.wake:
+				continue
+			case <-e.done:
+				return
+			}
+		}
+		seq := e.pending[0]
+		e.mu.Unlock()
+
+		if loaded != seq {
+			var err error
+			if packet, err = e.read(cursor, seq); err != nil {
+				log.Printf("[SPOOL] Dropping unreadable packet %d from %s: %v", seq, e.config.Dir, err)
+				e.ack(seq)
+				// The cursor may have read past the next packet looking for this one
+				cursor.Close()
+				cursor = e.log.NewReader(seq + 1)
+				continue
+			}
+			loaded = seq
+		}
+
+		if err := e.next.EmitContext(e.ctx, packet); err != nil {
+			if e.ctx.Err() != nil {
+				return
+			}
+			if !Retriable(err) {
+				log.Printf("[SPOOL] Distributor rejected spooled packet %s, dropping it: %v", packet.PacketID, err)
+				if e.ack(seq) {
+					e.rejected.Add(1)
+				}
+				continue
+			}
+			select {
+			case <-time.After(retryInterval):
+				continue
+			case <-e.done:
+				return
+			}
+		}
+		if e.ack(seq) {
+			e.drained.Add(1)
+		}
+
+		select {
+		case <-e.done:
+			return
+		default:
+		}
+	}
+}
+
+// read advances the cursor to a spooled packet and loads it. Packets are read in order,
+// so the cursor only ever moves forward.
+func (e *SpoolingEmitter) read(cursor *wal.Reader, seq uint64) (models.LogPacket, error) {
+	for {
+		index, data, err := cursor.Next()
+		if err == io.EOF || err == nil && index > seq {
+			return models.LogPacket{}, fmt.Errorf("record %d not found", seq)
+		}
+		if err != nil {
+			return models.LogPacket{}, err
+		}
+		if index < seq {
+			continue
+		}
+
+		var record spoolRecord
+		if err := json.Unmarshal(data, &record); err != nil {
+			return models.LogPacket{}, err
+		}
+		if record.Packet == nil {
+			return models.LogPacket{}, fmt.Errorf("record %d not found", seq)
+		}
+		return *record.Packet, nil
+	}
+}
+
+// ack removes the oldest spooled packet once it has been delivered and drops segments
+// that hold no spooled packets. It reports false when the packet was evicted meanwhile.
+func (e *SpoolingEmitter) ack(seq uint64) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if len(e.pending) == 0 || e.pending[0] != seq {
+		return false
+	}
+	data, err := json.Marshal(spoolRecord{Op: spoolOpAck, Seq: seq})
+	if err != nil {
+		return false
+	}
+	if _, err := e.log.Append(data); err != nil {
+		log.Printf("[SPOOL] Failed to record delivery of packet %d in %s: %v", seq, e.config.Dir, err)
+		return false
+	}
+	e.pending = e.pending[1:]
+	if err := e.truncate(); err != nil {
+		log.Printf("[SPOOL] Failed to truncate %s: %v", e.config.Dir, err)
+	}
+	return true
+}
+
+// truncate removes every segment older than the oldest spooled packet
+func (e *SpoolingEmitter) truncate() error {
+	if len(e.pending) == 0 {
+		return e.log.TruncateFront(e.log.NextIndex())
+	}
+	return e.log.TruncateFront(e.pending[0])
+}
@@ -0,0 +1,326 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Metric types as written in the TYPE line of the exposition format
+const (
+	typeCounter   = "counter"
+	typeGauge     = "gauge"
+	typeHistogram = "histogram"
+)
+
+// DefaultBuckets are latency buckets in seconds, from 1ms to 10s
+var DefaultBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Registry holds metric families and writes them in the Prometheus text format
+type Registry struct {
+	mu       sync.Mutex
+	families []*family
+	names    map[string]bool
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{names: make(map[string]bool)}
+}
+
+// family is a named metric with one series per combination of label values
+type family struct {
+	name       string
+	help       string
+	kind       string
+	labelNames []string
+	buckets    []float64
+	collect    func() float64 // set for gauges computed at scrape time
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+// series is one labelled time series of a family
+type series struct {
+	labelValues []string
+	bits        uint64 // float64 value of a counter or gauge
+
+	mu     sync.Mutex // guards the histogram fields
+	counts []uint64   // per bucket, not cumulative
+	sum    float64
+	total  uint64
+}
+
+// register adds a family, panicking on duplicate names since that is a programming error
+func (r *Registry) register(f *family) *family {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.names[f.name] {
+		panic(fmt.Sprintf("metrics: %s registered twice", f.name))
+	}
+	r.names[f.name] = true
+	f.series = make(map[string]*series)
+	r.families = append(r.families, f)
+	return f
+}
+
+// with returns the series for the given label values, creating it on first use
+func (f *family) with(labelValues []string) *series {
+	if len(labelValues) != len(f.labelNames) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", f.name, len(f.labelNames), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{labelValues: append([]string(nil), labelValues...)}
+		if f.kind == typeHistogram {
+			s.counts = make([]uint64, len(f.buckets))
+		}
+		f.series[key] = s
+	}
+	return s
+}
+
+// add atomically adds v to the series value
+func (s *series) add(v float64) {
+	for {
+		old := atomic.LoadUint64(&s.bits)
+		updated := math.Float64bits(math.Float64frombits(old) + v)
+		if atomic.CompareAndSwapUint64(&s.bits, old, updated) {
+			return
+		}
+	}
+}
+
+func (s *series) value() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&s.bits))
+}
+
+// CounterVec is a family of counters partitioned by labels
+type CounterVec struct{ f *family }
+
+// Counter is a monotonically increasing value
+type Counter struct{ s *series }
+
+// Counter registers a counter family
+func (r *Registry) Counter(name, help string, labelNames ...string) *CounterVec {
+	return &CounterVec{r.register(&family{name: name, help: help, kind: typeCounter, labelNames: labelNames})}
+}
+
+// With returns the counter for the given label values
+func (v *CounterVec) With(labelValues ...string) Counter {
+	return Counter{v.f.with(labelValues)}
+}
+
+// Inc adds one to the counter
+func (c Counter) Inc() { c.s.add(1) }
+
+// Add adds a non-negative value to the counter
+func (c Counter) Add(v float64) {
+	if v < 0 {
+		return
+	}
+	c.s.add(v)
+}
+
+// GaugeVec is a family of gauges partitioned by labels
+type GaugeVec struct{ f *family }
+
+// Gauge is a value that can go up and down
+type Gauge struct{ s *series }
+
+// Gauge registers a gauge family
+func (r *Registry) Gauge(name, help string, labelNames ...string) *GaugeVec {
+	return &GaugeVec{r.register(&family{name: name, help: help, kind: typeGauge, labelNames: labelNames})}
+}
+
+// GaugeFunc registers an unlabelled gauge whose value is read from fn at scrape time
+func (r *Registry) GaugeFunc(name, help string, fn func() float64) {
+	r.register(&family{name: name, help: help, kind: typeGauge, collect: fn})
+}
+
+// With returns the gauge for the given label values
+func (v *GaugeVec) With(labelValues ...string) Gauge {
+	return Gauge{v.f.with(labelValues)}
+}
+
+// Set replaces the gauge value
+func (g Gauge) Set(v float64) { atomic.StoreUint64(&g.s.bits, math.Float64bits(v)) }
+
+// Add adds v, which may be negative, to the gauge
+func (g Gauge) Add(v float64) { g.s.add(v) }
+
+// Inc adds one to the gauge
+func (g Gauge) Inc() { g.s.add(1) }
+
+// Dec subtracts one from the gauge
+func (g Gauge) Dec() { g.s.add(-1) }
+
+// HistogramVec is a family of histograms partitioned by labels
+type HistogramVec struct{ f *family }
+
+// Histogram counts observations into buckets
+type Histogram struct {
+	f *family
+	s *series
+}
+
+// Histogram registers a histogram family. Buckets are upper bounds in increasing order;
+// nil uses DefaultBuckets.
+func (r *Registry) Histogram(name, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	return &HistogramVec{r.register(&family{name: name, help: help, kind: typeHistogram, labelNames: labelNames, buckets: buckets})}
+}
+
+// With returns the histogram for the given label values
+func (v *HistogramVec) With(labelValues ...string) Histogram {
+	return Histogram{f: v.f, s: v.f.with(labelValues)}
+}
+
+// Observe records a value
+func (h Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.f.buckets, v)
+
+	h.s.mu.Lock()
+	defer h.s.mu.Unlock()
+	if i < len(h.s.counts) {
+		h.s.counts[i]++
+	}
+	h.s.sum += v
+	h.s.total++
+}
+
+// ObserveDuration records a duration in seconds
+func (h Histogram) ObserveDuration(d time.Duration) {
+	h.Observe(d.Seconds())
+}
+
+// Write writes every family in the Prometheus text exposition format
+func (r *Registry) Write(w io.Writer) error {
+	r.mu.Lock()
+	families := append([]*family(nil), r.families...)
+	r.mu.Unlock()
+
+	sort.Slice(families, func(i, j int) bool { return families[i].name < families[j].name })
+
+	bw := bufio.NewWriter(w)
+	for _, f := range families {
+		f.write(bw)
+	}
+	return bw.Flush()
+}
+
+// Handler serves the registry on a /metrics endpoint
+func (r *Registry) Handler() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.Write(w)
+	}
+}
+
+// write writes the HELP and TYPE lines and every series of a family
+func (f *family) write(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.kind)
+
+	if f.collect != nil {
+		fmt.Fprintf(w, "%s %s\n", f.name, formatValue(f.collect()))
+		return
+	}
+
+	f.mu.Lock()
+	all := make([]*series, 0, len(f.series))
+	for _, s := range f.series {
+		all = append(all, s)
+	}
+	f.mu.Unlock()
+
+	sort.Slice(all, func(i, j int) bool {
+		return strings.Join(all[i].labelValues, "\xff") < strings.Join(all[j].labelValues, "\xff")
+	})
+
+	for _, s := range all {
+		labels := formatLabels(f.labelNames, s.labelValues, "", "")
+		if f.kind != typeHistogram {
+			fmt.Fprintf(w, "%s%s %s\n", f.name, labels, formatValue(s.value()))
+			continue
+		}
+
+		s.mu.Lock()
+		counts := append([]uint64(nil), s.counts...)
+		sum, total := s.sum, s.total
+		s.mu.Unlock()
+
+		var cumulative uint64
+		for i, upper := range f.buckets {
+			cumulative += counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", f.name,
+				formatLabels(f.labelNames, s.labelValues, "le", formatValue(upper)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, formatLabels(f.labelNames, s.labelValues, "le", "+Inf"), total)
+		fmt.Fprintf(w, "%s_sum%s %s\n", f.name, labels, formatValue(sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", f.name, labels, total)
+	}
+}
+
+// formatLabels renders {name="value",...}, appending an extra label when extraName is set
+func formatLabels(names, values []string, extraName, extraValue string) string {
+	if len(names) == 0 && extraName == "" {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "%s=\"%s\"", name, escapeLabel(values[i]))
+	}
+	if extraName != "" {
+		if len(names) > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "%s=\"%s\"", extraName, escapeLabel(extraValue))
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"context"
 	"time"
 )
 
@@ -29,25 +30,171 @@ type Analyzer interface {
 	IsHealthy() bool
 }
 
+// CapabilityBatch is advertised on an analyzer's /health endpoint when it accepts /analyze/batch
+const CapabilityBatch = "batch"
+
+// AnalyzeBatchRequest is the body sent to an analyzer's /analyze/batch endpoint
+type AnalyzeBatchRequest struct {
+	Messages []LogMessage `json:"messages"`
+}
+
+// AnalyzeResult is the outcome of analyzing one message of a batch
+type AnalyzeResult struct {
+	LogID   string `json:"log_id"`
+	Success bool   `json:"success"`
+	Error   string `json:"error,omitempty"`
+}
+
+// AnalyzeBatchResponse holds per-message results in the same order as the request
+type AnalyzeBatchResponse struct {
+	Analyzer string          `json:"analyzer"`
+	Results  []AnalyzeResult `json:"results"`
+}
+
 // AnalyzerConfig holds analyzer configuration
 type AnalyzerConfig struct {
-	ID         string  `json:"id"`
-	Weight     float64 `json:"weight"`
-	Endpoint   string  `json:"endpoint"` // e.g., "http://analyzer1:8080"
-	Timeout    int     `json:"timeout"`  // milliseconds
-	RetryCount int     `json:"retry_count"`
+	ID             string  `json:"id"`
+	Weight         float64 `json:"weight"`
+	Endpoint       string  `json:"endpoint"`                  // e.g., "http://analyzer1:8080"
+	HealthEndpoint string  `json:"health_endpoint,omitempty"` // defaults to Endpoint with /analyze replaced by /health
+	BatchEndpoint  string  `json:"batch_endpoint,omitempty"`  // defaults to Endpoint + "/batch"
+	Timeout        int     `json:"timeout"`                   // milliseconds
+	RetryCount     int     `json:"retry_count"`
+	Draining       bool    `json:"draining,omitempty"` // receives no new traffic; queued messages go to other analyzers
+}
+
+// MemberRegistration is sent by an analyzer to join a distributor's analyzer pool
+type MemberRegistration struct {
+	ID             string   `json:"id"`
+	Endpoint       string   `json:"endpoint"`                  // e.g., "http://analyzer1:8080/analyze"
+	HealthEndpoint string   `json:"health_endpoint,omitempty"` // defaults to Endpoint with /analyze replaced by /health
+	Weight         float64  `json:"weight,omitempty"`          // defaults to Capacity, or 1
+	Capacity       int      `json:"capacity,omitempty"`        // messages the analyzer can take concurrently
+	Timeout        int      `json:"timeout,omitempty"`         // milliseconds
+	RetryCount     int      `json:"retry_count,omitempty"`
+	Groups         []string `json:"groups,omitempty"` // named routing groups to join
+}
+
+// MembershipConfig holds the distributor's analyzer self-registration settings
+type MembershipConfig struct {
+	Enabled          bool `json:"enabled"`
+	HeartbeatTimeout int  `json:"heartbeat_timeout"` // milliseconds without a heartbeat before a member expires
+}
+
+// HealthCheckConfig holds analyzer health probing and circuit breaker settings
+type HealthCheckConfig struct {
+	Interval         int `json:"interval"`          // milliseconds between /health probes
+	Timeout          int `json:"timeout"`           // milliseconds before a probe is considered failed
+	FailureThreshold int `json:"failure_threshold"` // consecutive delivery failures that open the circuit
+	OpenDuration     int `json:"open_duration"`     // milliseconds an open circuit waits before a half-open trial
+}
+
+// GroupMember references an analyzer in a group, optionally with a group-specific weight
+type GroupMember struct {
+	ID     string  `json:"id"`
+	Weight float64 `json:"weight,omitempty"` // defaults to the analyzer's own weight
 }
 
+// AnalyzerGroup is a named pool of analyzers with its own weighted selection
+type AnalyzerGroup struct {
+	Name    string        `json:"name"`
+	Members []GroupMember `json:"members"`
+	HashKey string        `json:"hash_key,omitempty"` // sticky routing key, overrides RoutingConfig.HashKey
+}
+
+// RoutingRule sends messages that match every configured condition to an analyzer group
+type RoutingRule struct {
+	Name         string            `json:"name"`
+	Levels       []string          `json:"levels,omitempty"`        // any of these levels
+	Sources      []string          `json:"sources,omitempty"`       // any of these sources
+	Metadata     map[string]string `json:"metadata,omitempty"`      // every key with this value, "*" matches any value
+	MessageRegex string            `json:"message_regex,omitempty"` // regular expression matched against Message
+	Group        string            `json:"group"`
+}
+
+// RoutingConfig holds content-based routing rules, evaluated in order
+type RoutingConfig struct {
+	Groups       []AnalyzerGroup `json:"groups"`
+	Rules        []RoutingRule   `json:"rules"`
+	DefaultGroup string          `json:"default_group"` // group for unmatched messages; empty means all analyzers
+	HashKey      string          `json:"hash_key"`      // sticky routing key: id, level, source, message or metadata.<name>
+	VirtualNodes int             `json:"virtual_nodes"` // hash ring points per unit of analyzer weight
+}
+
+// BatchConfig holds distributor-to-analyzer batching settings
+type BatchConfig struct {
+	Enabled bool `json:"enabled"`
+	MaxSize int  `json:"max_size"` // messages per batch
+	Linger  int  `json:"linger"`   // milliseconds to wait for a batch to fill
+}
+
+// RetryConfig holds the retry queue's exponential backoff settings
+type RetryConfig struct {
+	InitialBackoff int     `json:"initial_backoff"` // milliseconds before the first retry
+	MaxBackoff     int     `json:"max_backoff"`     // milliseconds cap on the backoff
+	Multiplier     float64 `json:"multiplier"`      // backoff growth per attempt
+	Jitter         float64 `json:"jitter"`          // fraction of the backoff randomized, 0 to 1
+}
+
+// WorkerConfig holds the size of the distributor's analyzer request pool
+type WorkerConfig struct {
+	Size       int     `json:"size"`        // concurrent requests to analyzers across live traffic and retries
+	RetryShare float64 `json:"retry_share"` // fraction of the pool reserved for retry queue redelivery, 0 to 1
+}
+
+// DeadLetterConfig holds the limits after which queued messages are moved to the dead-letter store
+type DeadLetterConfig struct {
+	MaxAttempts int       `json:"max_attempts"` // delivery attempts before dead-lettering; 0 disables the limit
+	MaxAge      int       `json:"max_age"`      // milliseconds a message may wait in the queue; 0 disables the limit
+	WAL         WALConfig `json:"wal"`          // durable storage for dead letters
+}
+
+// ShutdownConfig holds graceful shutdown settings shared by every component
+type ShutdownConfig struct {
+	DrainDelay int `json:"drain_delay"` // milliseconds /health reports draining before listeners close
+	Timeout    int `json:"timeout"`     // milliseconds to finish in-flight work before persisting or abandoning it
+}
+
+// WALConfig holds write-ahead log configuration
+type WALConfig struct {
+	Dir          string `json:"dir"`           // directory for segment files; empty keeps the data in memory only
+	SegmentSize  int64  `json:"segment_size"`  // bytes per segment before rolling to a new one
+	SyncPolicy   string `json:"sync_policy"`   // always, interval, none
+	SyncInterval int    `json:"sync_interval"` // milliseconds, used with the interval policy
+}
+
+// Ingest modes for the distributor's /logs endpoint
+const (
+	IngestModeImmediate = "immediate" // answer 200 as soon as the packet is decoded
+	IngestModeDurable   = "durable"   // journal the packet first, then answer 202 with a receipt
+)
+
 // DistributorConfig holds the overall configuration
 type DistributorConfig struct {
-	Analyzers   []AnalyzerConfig `json:"analyzers"`
-	Port        int              `json:"port"`
-	TotalWeight float64          `json:"-"` // calculated field, not serialized
+	Analyzers     []AnalyzerConfig  `json:"analyzers"`
+	Port          int               `json:"port"`
+	QueueWAL      WALConfig         `json:"queue_wal"`      // durable storage for the retry queue
+	IngestMode    string            `json:"ingest_mode"`    // immediate or durable
+	IngestJournal WALConfig         `json:"ingest_journal"` // packet journal used by the durable ingest mode
+	HealthCheck   HealthCheckConfig `json:"health_check"`
+	Batching      BatchConfig       `json:"batching"` // used for analyzers that advertise batch support
+	Routing       RoutingConfig     `json:"routing"`
+	Retry         RetryConfig       `json:"retry"`
+	Workers       WorkerConfig      `json:"workers"`
+	Membership    MembershipConfig  `json:"membership"` // analyzers that register themselves at runtime
+	Shutdown      ShutdownConfig    `json:"shutdown"`
+	DeadLetter    DeadLetterConfig  `json:"dead_letter"`
+	RawIngest     RawIngestConfig   `json:"raw_ingest"`    // plain-text lines posted to /logs
+	Syslog        SyslogConfig      `json:"syslog"`        // syslog listeners feeding the same distribution path
+	StreamIngest  StreamConfig      `json:"stream_ingest"` // NDJSON streams posted to /logs/stream
+	Compression   CompressionConfig `json:"compression"`   // request body encoding in both directions
+	TotalWeight   float64           `json:"-"`             // calculated field, not serialized
 }
 
 // Emitter interface for sending log packets to the distributor
 type Emitter interface {
 	Emit(packet LogPacket) error
+	EmitContext(ctx context.Context, packet LogPacket) error // stops retrying once ctx is done
 	GetID() string
 	GetEndpoint() string
 }
@@ -58,13 +205,118 @@ type EmitterConfig struct {
 	Endpoint       string // distributor endpoint to send to
 	Timeout        time.Duration
 	RetryCount     int
-	RetryDelay     time.Duration
+	RetryDelay     time.Duration // backoff before the first retry, doubling up to MaxRetryDelay
+	MaxRetryDelay  time.Duration
+	RetryJitter    float64 // fraction of each backoff randomized, 0 to 1
 	MaxConcurrency int
 	BufferSize     int
 	BatchSize      int           // max messages per packet
 	FlushInterval  time.Duration // how often to send packets
+	OverflowPolicy string        // block, drop_oldest or drop_newest when the buffer is full
+	Compression    string        // preferred Content-Encoding of packets, used once the distributor advertises it
+	WireFormat     string        // json or binary packet encoding
+}
+
+// TailConfig holds the file-tailing agent's configuration
+type TailConfig struct {
+	Inputs       []TailInput `json:"inputs"`
+	Checkpoint   string      `json:"checkpoint"`    // file holding the read offset of every tailed file
+	PollInterval int         `json:"poll_interval"` // milliseconds between scans for new lines
+	BatchSize    int         `json:"batch_size"`    // max lines per packet
+}
+
+// TailInput is a set of files that are read the same way
+type TailInput struct {
+	Paths     []string        `json:"paths"`     // glob patterns
+	Source    string          `json:"source"`    // source of the messages; defaults to the file name
+	StartAt   string          `json:"start_at"`  // beginning or end, for files without a checkpoint
+	Parser    ParserConfig    `json:"parser"`    // how lines become log messages; raw by default
+	Multiline MultilineConfig `json:"multiline"` // joins stack traces and other multiline events
+}
+
+// ParserConfig selects how raw log lines are turned into log messages
+type ParserConfig struct {
+	Type            string `json:"type"`             // raw, json, logfmt, combined, regex or syslog
+	Pattern         string `json:"pattern"`          // regular expression with named groups, for the regex parser
+	TimestampField  string `json:"timestamp_field"`  // field holding the timestamp; timestamp, time, ts or @timestamp by default
+	TimestampFormat string `json:"timestamp_format"` // Go time layout; RFC 3339 and Unix times are recognized without it
+	LevelField      string `json:"level_field"`      // level, lvl or severity by default
+	SourceField     string `json:"source_field"`     // source, service or app by default
+	MessageField    string `json:"message_field"`    // message or msg by default
+}
+
+// MultilineConfig joins the lines of one event, such as a stack trace, into one message.
+// Without either pattern every line is its own message.
+type MultilineConfig struct {
+	StartPattern        string `json:"start_pattern"`        // lines not matching it continue the previous event
+	ContinuationPattern string `json:"continuation_pattern"` // lines matching it continue the previous event
+	MaxLines            int    `json:"max_lines"`            // lines per event before a new one is started (default 500)
+	FlushTimeout        int    `json:"flush_timeout"`        // milliseconds an event waits for more lines (default 1000)
+}
+
+// RawIngestConfig holds how the distributor parses plain-text lines posted to /logs
+type RawIngestConfig struct {
+	Parser    ParserConfig    `json:"parser"`
+	Multiline MultilineConfig `json:"multiline"`
+	Source    string          `json:"source"` // source of lines the parser finds none for; defaults to raw
 }
 
+// SyslogConfig holds the distributor's syslog listeners. Messages are parsed as RFC 5424
+// or RFC 3164 and distributed in packets like those posted to /logs.
+type SyslogConfig struct {
+	UDPPort        int    `json:"udp_port"`         // 0 disables the UDP listener
+	TCPPort        int    `json:"tcp_port"`         // 0 disables the TCP listener; frames are octet-counted or newline-terminated
+	MaxMessageSize int    `json:"max_message_size"` // bytes per message (default 65536)
+	BatchSize      int    `json:"batch_size"`       // messages per packet (default 100)
+	FlushInterval  int    `json:"flush_interval"`   // milliseconds before a partial packet is distributed (default 1000)
+	Source         string `json:"source"`           // source of messages without an app name; defaults to syslog
+}
+
+// StreamConfig holds how the distributor reads NDJSON streams of log messages. Lines are
+// distributed in batches, and reading pauses while a stream's batches wait for workers.
+type StreamConfig struct {
+	BatchSize     int `json:"batch_size"`     // messages per packet (default 100)
+	FlushInterval int `json:"flush_interval"` // milliseconds before a partial packet is distributed (default 1000)
+	MaxInFlight   int `json:"max_in_flight"`  // packets per stream being distributed before reading pauses (default 4)
+	MaxLineSize   int `json:"max_line_size"`  // bytes per line; longer lines are rejected (default 1048576)
+}
+
+// CompressionConfig holds how the distributor encodes requests to analyzers and how much
+// it decodes from compressed requests it receives
+type CompressionConfig struct {
+	Encoding       string `json:"encoding"`         // gzip (default), snappy, deflate or identity for requests to analyzers that advertise it
+	MaxDecodedSize int64  `json:"max_decoded_size"` // bytes a compressed /logs or /v1/logs body may decode to (default 67108864)
+}
+
+// Delivery modes deciding which distributors receive a packet
+const (
+	DeliveryBroadcast   = "broadcast"    // every distributor receives every packet
+	DeliveryRoundRobin  = "round_robin"  // distributors take turns
+	DeliveryLeastLoaded = "least_loaded" // the distributor with the fewest packets in flight
+	DeliveryFailover    = "failover"     // the first distributor, with the others as standbys
+)
+
+// SpoolConfig holds emitter-side spooling of packets the distributor could not take
+type SpoolConfig struct {
+	Dir           string `json:"dir"`            // spool directory; empty disables spooling
+	MaxBytes      int64  `json:"max_bytes"`      // disk space used before the oldest packets are evicted
+	RetryInterval int    `json:"retry_interval"` // milliseconds between drain attempts while the distributor is down
+	SyncPolicy    string `json:"sync_policy"`    // always, interval, none
+}
+
+// Overflow policies for a buffered emitter whose buffer is full
+const (
+	OverflowBlock      = "block"       // Log waits until there is room
+	OverflowDropOldest = "drop_oldest" // the oldest buffered message is discarded
+	OverflowDropNewest = "drop_newest" // the message being logged is discarded
+)
+
+// Wire formats for packets sent to a distributor's /logs endpoint
+const (
+	WireFormatJSON   = "json"   // a LogPacket JSON object
+	WireFormatBinary = "binary" // the compact binary format of the wire package
+)
+
 // // EmitterStatus tracks the health and performance of an emitter (agent)
 // type EmitterStatus struct {
 // 	ID             string    `json:"id"`
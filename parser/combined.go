@@ -0,0 +1,69 @@
+package parser
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"resolve/models"
+)
+
+// clfLayout is the time layout of the common log format, e.g. 10/Oct/2000:13:55:36 -0700
+const clfLayout = "02/Jan/2006:15:04:05 -0700"
+
+// combinedPattern matches the common log format, optionally followed by the referer and
+// user agent of the combined format
+var combinedPattern = regexp.MustCompile(
+	`^(\S+) (\S+) (\S+) \[([^\]]+)\] "((?:[^"\\]|\\.)*)" (\d{3}) (\S+)(?: "((?:[^"\\]|\\.)*)" "((?:[^"\\]|\\.)*)")?`)
+
+// combinedParser reads Apache and Nginx access logs. The request line becomes the
+// message and the level follows the status: ERROR for 5xx, WARN for 4xx, INFO otherwise.
+type combinedParser struct{}
+
+// Parse matches an access log line
+func (combinedParser) Parse(line string, msg *models.LogMessage) error {
+	m := combinedPattern.FindStringSubmatch(line)
+	if m == nil {
+		return fmt.Errorf("not a common or combined log format line")
+	}
+
+	ts, err := parseTimestamp(m[4], clfLayout)
+	if err != nil {
+		return fmt.Errorf("invalid access log time %q", m[4])
+	}
+	status, _ := strconv.Atoi(m[6])
+
+	msg.Timestamp = ts
+	msg.Message = m[5]
+	switch {
+	case status >= 500:
+		msg.Level = "ERROR"
+	case status >= 400:
+		msg.Level = "WARN"
+	default:
+		msg.Level = "INFO"
+	}
+
+	// "-" marks a field the server had no value for
+	optional := map[string]string{
+		"remote_addr": m[1],
+		"ident":       m[2],
+		"remote_user": m[3],
+		"bytes":       m[7],
+		"referer":     m[8],
+		"user_agent":  m[9],
+	}
+	for key, value := range optional {
+		if value != "" && value != "-" {
+			setMetadata(msg, key, value)
+		}
+	}
+	setMetadata(msg, "status", m[6])
+	if parts := strings.Fields(m[5]); len(parts) == 3 {
+		setMetadata(msg, "method", parts[0])
+		setMetadata(msg, "path", parts[1])
+		setMetadata(msg, "protocol", parts[2])
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"resolve/models"
+)
+
+// jsonParser reads lines holding one JSON object. Nested objects and arrays are kept
+// as compact JSON in Metadata.
+type jsonParser struct {
+	fields fieldMapping
+}
+
+// Parse decodes the object and maps its top-level keys
+func (p *jsonParser) Parse(line string, msg *models.LogMessage) error {
+	decoder := json.NewDecoder(strings.NewReader(line))
+	decoder.UseNumber()
+
+	var object map[string]interface{}
+	if err := decoder.Decode(&object); err != nil {
+		return fmt.Errorf("invalid JSON: %w", err)
+	}
+	if object == nil {
+		return fmt.Errorf("invalid JSON: not an object")
+	}
+
+	values := make(map[string]string, len(object))
+	for key, value := range object {
+		switch v := value.(type) {
+		case nil:
+			// null carries nothing worth keeping
+		case string:
+			values[key] = v
+		case json.Number:
+			values[key] = v.String()
+		case bool:
+			values[key] = fmt.Sprintf("%t", v)
+		default:
+			var buf bytes.Buffer
+			encoder := json.NewEncoder(&buf)
+			encoder.SetEscapeHTML(false)
+			if err := encoder.Encode(v); err != nil {
+				return fmt.Errorf("failed to encode field %s: %w", key, err)
+			}
+			values[key] = strings.TrimSuffix(buf.String(), "\n")
+		}
+	}
+
+	p.fields.apply(values, line, msg)
+	return nil
+}
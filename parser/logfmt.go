@@ -0,0 +1,82 @@
+package parser
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"resolve/models"
+)
+
+// logfmtParser reads key=value pairs separated by spaces. Values may be double-quoted
+// with Go escapes; a key without a value is recorded as true.
+type logfmtParser struct {
+	fields fieldMapping
+}
+
+// Parse splits the line into pairs and maps them
+func (p *logfmtParser) Parse(line string, msg *models.LogMessage) error {
+	values, err := parseLogfmt(line)
+	if err != nil {
+		return err
+	}
+	p.fields.apply(values, line, msg)
+	return nil
+}
+
+// parseLogfmt returns the pairs of a logfmt line. A line without a single key=value
+// pair is not logfmt.
+func parseLogfmt(line string) (map[string]string, error) {
+	values := make(map[string]string)
+	pairs := 0
+	rest := line
+	for {
+		rest = strings.TrimLeft(rest, " \t")
+		if rest == "" {
+			break
+		}
+
+		end := strings.IndexAny(rest, "= \t")
+		if end == -1 {
+			values[rest] = "true"
+			break
+		}
+		key := rest[:end]
+		if key == "" {
+			return nil, fmt.Errorf("invalid logfmt: value without a key at %q", rest)
+		}
+		if rest[end] != '=' {
+			values[key] = "true"
+			rest = rest[end:]
+			continue
+		}
+
+		rest = rest[end+1:]
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			quoted, err := strconv.QuotedPrefix(rest)
+			if err != nil {
+				return nil, fmt.Errorf("invalid logfmt: unterminated quote in %s", key)
+			}
+			value, err = strconv.Unquote(quoted)
+			if err != nil {
+				return nil, fmt.Errorf("invalid logfmt: bad quoting in %s: %w", key, err)
+			}
+			rest = rest[len(quoted):]
+		} else {
+			end = strings.IndexAny(rest, " \t")
+			if end == -1 {
+				end = len(rest)
+			}
+			value = rest[:end]
+			rest = rest[end:]
+		}
+		values[key] = value
+		pairs++
+	}
+
+	if pairs == 0 {
+		return nil, fmt.Errorf("invalid logfmt: no key=value pairs")
+	}
+	return values, nil
+}
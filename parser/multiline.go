@@ -0,0 +1,89 @@
+package parser
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"resolve/models"
+)
+
+// Multiline decides which lines continue the event before them, so a stack trace or
+// panic becomes one message instead of one message per line. A line continues the
+// current event when it matches the continuation pattern, or when a start pattern is
+// configured and the line does not match it.
+type Multiline struct {
+	start        *regexp.Regexp
+	continuation *regexp.Regexp
+
+	MaxLines     int           // lines per event before a new one is started
+	FlushTimeout time.Duration // how long an event waits for more lines
+}
+
+// NewMultiline compiles a multiline configuration. It returns nil when neither pattern
+// is configured, meaning every line is its own event.
+func NewMultiline(config models.MultilineConfig) (*Multiline, error) {
+	if config.StartPattern == "" && config.ContinuationPattern == "" {
+		return nil, nil
+	}
+
+	// Set default values if not provided
+	if config.MaxLines <= 0 {
+		config.MaxLines = 500
+	}
+	if config.FlushTimeout <= 0 {
+		config.FlushTimeout = 1000
+	}
+
+	m := &Multiline{
+		MaxLines:     config.MaxLines,
+		FlushTimeout: time.Duration(config.FlushTimeout) * time.Millisecond,
+	}
+	var err error
+	if config.StartPattern != "" {
+		if m.start, err = regexp.Compile(config.StartPattern); err != nil {
+			return nil, fmt.Errorf("invalid multiline start pattern: %w", err)
+		}
+	}
+	if config.ContinuationPattern != "" {
+		if m.continuation, err = regexp.Compile(config.ContinuationPattern); err != nil {
+			return nil, fmt.Errorf("invalid multiline continuation pattern: %w", err)
+		}
+	}
+	return m, nil
+}
+
+// Continues reports whether line belongs to the event before it
+func (m *Multiline) Continues(line string) bool {
+	if m.continuation != nil && m.continuation.MatchString(line) {
+		return true
+	}
+	return m.start != nil && !m.start.MatchString(line)
+}
+
+// Group joins complete lines into events separated by newlines. Lines continuing
+// nothing, such as continuation lines at the very start, are events of their own.
+// With a nil Multiline every line is an event.
+func (m *Multiline) Group(lines []string) []string {
+	if m == nil {
+		return lines
+	}
+
+	events := make([]string, 0, len(lines))
+	var event []string
+	for _, line := range lines {
+		if len(event) > 0 && len(event) < m.MaxLines && m.Continues(line) {
+			event = append(event, line)
+			continue
+		}
+		if len(event) > 0 {
+			events = append(events, strings.Join(event, "\n"))
+		}
+		event = []string{line}
+	}
+	if len(event) > 0 {
+		events = append(events, strings.Join(event, "\n"))
+	}
+	return events
+}
@@ -0,0 +1,220 @@
+package parser
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"resolve/models"
+)
+
+// Parser types
+const (
+	TypeRaw      = "raw"      // the whole line is the message
+	TypeJSON     = "json"     // one JSON object per line
+	TypeLogfmt   = "logfmt"   // key=value pairs
+	TypeCombined = "combined" // Apache/Nginx common or combined log format
+	TypeRegex    = "regex"    // named capture groups of a regular expression
+	TypeSyslog   = "syslog"   // RFC 5424 or RFC 3164 syslog messages
+)
+
+// Parser turns a raw log line into the fields of a log message
+type Parser interface {
+	// Parse fills msg from line. Message is set to the line's message, or to the whole
+	// line when it has none; other fields the line does not carry keep their values.
+	// Fields without a LogMessage counterpart are added to Metadata.
+	Parse(line string, msg *models.LogMessage) error
+}
+
+// New creates the parser described by config
+func New(config models.ParserConfig) (Parser, error) {
+	fields := newFieldMapping(config)
+	switch config.Type {
+	case "", TypeRaw:
+		return rawParser{}, nil
+	case TypeJSON:
+		return &jsonParser{fields: fields}, nil
+	case TypeLogfmt:
+		return &logfmtParser{fields: fields}, nil
+	case TypeCombined:
+		return &combinedParser{}, nil
+	case TypeRegex:
+		return newRegexParser(config.Pattern, fields)
+	case TypeSyslog:
+		return syslogParser{}, nil
+	default:
+		return nil, fmt.Errorf("unknown parser type %q: must be %s, %s, %s, %s, %s or %s",
+			config.Type, TypeRaw, TypeJSON, TypeLogfmt, TypeCombined, TypeRegex, TypeSyslog)
+	}
+}
+
+// Apply parses line into msg. An event of several lines is parsed by its first line and
+// the other lines, such as a stack trace, are appended to the message. When the line does
+// not parse, the whole event becomes the message and the error is recorded in the
+// parse_error metadata field.
+func Apply(p Parser, line string, msg *models.LogMessage) error {
+	first, rest, multiline := strings.Cut(line, "\n")
+	err := p.Parse(first, msg)
+	if err != nil {
+		msg.Message = line
+		setMetadata(msg, "parse_error", err.Error())
+		return err
+	}
+	if multiline {
+		msg.Message += "\n" + rest
+	}
+	return nil
+}
+
+// rawParser keeps the line as it is
+type rawParser struct{}
+
+// Parse makes the whole line the message
+func (rawParser) Parse(line string, msg *models.LogMessage) error {
+	msg.Message = line
+	return nil
+}
+
+// fieldMapping decides which keys of a structured line fill which LogMessage fields
+type fieldMapping struct {
+	timestamp []string
+	level     []string
+	source    []string
+	message   []string
+	layout    string // time layout of the timestamp field, if not a recognized one
+}
+
+// newFieldMapping uses the configured field names in place of the defaults
+func newFieldMapping(config models.ParserConfig) fieldMapping {
+	fields := fieldMapping{
+		timestamp: []string{"timestamp", "time", "ts", "@timestamp"},
+		level:     []string{"level", "lvl", "severity"},
+		source:    []string{"source", "service", "app"},
+		message:   []string{"message", "msg"},
+		layout:    config.TimestampFormat,
+	}
+	if config.TimestampField != "" {
+		fields.timestamp = []string{config.TimestampField}
+	}
+	if config.LevelField != "" {
+		fields.level = []string{config.LevelField}
+	}
+	if config.SourceField != "" {
+		fields.source = []string{config.SourceField}
+	}
+	if config.MessageField != "" {
+		fields.message = []string{config.MessageField}
+	}
+	return fields
+}
+
+// apply moves key/value pairs into msg. The first key of each list that is present wins;
+// everything else, including timestamps that do not parse, goes to Metadata.
+func (f fieldMapping) apply(values map[string]string, line string, msg *models.LogMessage) {
+	used := make(map[string]bool)
+	if key, value, ok := first(values, f.timestamp); ok {
+		if ts, err := parseTimestamp(value, f.layout); err == nil {
+			msg.Timestamp = ts
+			used[key] = true
+		}
+	}
+	if key, value, ok := first(values, f.level); ok {
+		msg.Level = NormalizeLevel(value)
+		used[key] = true
+	}
+	if key, value, ok := first(values, f.source); ok {
+		msg.Source = value
+		used[key] = true
+	}
+	msg.Message = line
+	if key, value, ok := first(values, f.message); ok {
+		msg.Message = value
+		used[key] = true
+	}
+
+	for key, value := range values {
+		if !used[key] {
+			setMetadata(msg, key, value)
+		}
+	}
+}
+
+// first returns the first of keys present in values with a non-empty value
+func first(values map[string]string, keys []string) (string, string, bool) {
+	for _, key := range keys {
+		if value, ok := values[key]; ok && value != "" {
+			return key, value, true
+		}
+	}
+	return "", "", false
+}
+
+// setMetadata adds a metadata field, creating the map if needed
+func setMetadata(msg *models.LogMessage, key, value string) {
+	if msg.Metadata == nil {
+		msg.Metadata = make(map[string]string)
+	}
+	msg.Metadata[key] = value
+}
+
+// NormalizeLevel maps common level spellings onto DEBUG, INFO, WARN, ERROR and FATAL.
+// Levels it does not recognize are upper-cased.
+func NormalizeLevel(level string) string {
+	level = strings.ToUpper(strings.TrimSpace(level))
+	switch level {
+	case "TRACE", "DEBUG", "DBG":
+		return "DEBUG"
+	case "INFO", "INF", "INFORMATION", "NOTICE":
+		return "INFO"
+	case "WARN", "WRN", "WARNING":
+		return "WARN"
+	case "ERROR", "ERR", "ERRO":
+		return "ERROR"
+	case "FATAL", "CRITICAL", "CRIT", "PANIC", "ALERT", "EMERG", "EMERGENCY":
+		return "FATAL"
+	}
+	return level
+}
+
+// timestampLayouts are tried in order when no layout is configured
+var timestampLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05.999999999",
+	clfLayout,
+	time.RFC1123Z,
+	time.RFC1123,
+}
+
+// parseTimestamp parses a timestamp with layout, or with the common layouts and as Unix
+// seconds, milliseconds, microseconds or nanoseconds when layout is empty
+func parseTimestamp(value, layout string) (time.Time, error) {
+	if layout != "" {
+		return time.Parse(layout, value)
+	}
+	for _, l := range timestampLayouts {
+		if ts, err := time.Parse(l, value); err == nil {
+			return ts, nil
+		}
+	}
+
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil || number <= 0 {
+		return time.Time{}, fmt.Errorf("unrecognized timestamp %q", value)
+	}
+	// Pick the unit that puts the timestamp after 2001
+	switch {
+	case number >= 1e18:
+		return time.Unix(0, int64(number)).UTC(), nil
+	case number >= 1e15:
+		return time.UnixMicro(int64(number)).UTC(), nil
+	case number >= 1e12:
+		return time.UnixMilli(int64(number)).UTC(), nil
+	default:
+		seconds, fraction := math.Modf(number)
+		return time.Unix(int64(seconds), int64(fraction*1e9)).UTC(), nil
+	}
+}
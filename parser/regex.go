@@ -0,0 +1,56 @@
+package parser
+
+import (
+	"fmt"
+	"regexp"
+
+	"resolve/models"
+)
+
+// regexParser maps the named capture groups of a regular expression like the keys of
+// a structured line, so a group named level fills Level and a group named msg fills Message
+type regexParser struct {
+	pattern *regexp.Regexp
+	names   []string
+	fields  fieldMapping
+}
+
+// newRegexParser compiles a pattern that must have at least one named group
+func newRegexParser(pattern string, fields fieldMapping) (*regexParser, error) {
+	if pattern == "" {
+		return nil, fmt.Errorf("regex parser needs a pattern")
+	}
+	compiled, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regex parser pattern: %w", err)
+	}
+
+	named := false
+	for _, name := range compiled.SubexpNames() {
+		if name != "" {
+			named = true
+		}
+	}
+	if !named {
+		return nil, fmt.Errorf("regex parser pattern %q has no named groups", pattern)
+	}
+	return &regexParser{pattern: compiled, names: compiled.SubexpNames(), fields: fields}, nil
+}
+
+// Parse matches the line and maps the named groups that took part in the match
+func (p *regexParser) Parse(line string, msg *models.LogMessage) error {
+	m := p.pattern.FindStringSubmatchIndex(line)
+	if m == nil {
+		return fmt.Errorf("line does not match the regex parser pattern")
+	}
+
+	values := make(map[string]string)
+	for i, name := range p.names {
+		if name == "" || m[2*i] < 0 {
+			continue
+		}
+		values[name] = line[m[2*i]:m[2*i+1]]
+	}
+	p.fields.apply(values, line, msg)
+	return nil
+}
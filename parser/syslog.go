@@ -0,0 +1,204 @@
+package parser
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"resolve/models"
+)
+
+// syslogLevels maps syslog severities 0 (emerg) to 7 (debug) onto log levels
+var syslogLevels = [8]string{"FATAL", "FATAL", "FATAL", "ERROR", "WARN", "INFO", "INFO", "DEBUG"}
+
+var syslogSeverities = [8]string{"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug"}
+
+var syslogFacilities = [24]string{
+	"kern", "user", "mail", "daemon", "auth", "syslog", "lpr", "news",
+	"uucp", "cron", "authpriv", "ftp", "ntp", "security", "console", "solaris-cron",
+	"local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7",
+}
+
+// rfc3164Layout is the timestamp of BSD syslog messages, which carry no year or zone
+const rfc3164Layout = "Jan _2 15:04:05"
+
+// syslogParser reads RFC 5424 messages and RFC 3164 (BSD) messages. The severity sets
+// Level, the app name or tag sets Source, and the facility, severity, hostname, process
+// ID, message ID and structured data go to Metadata. Structured data parameters are
+// named after their element, e.g. origin.ip.
+type syslogParser struct{}
+
+// Parse reads the priority and then the RFC 5424 or RFC 3164 header
+func (syslogParser) Parse(line string, msg *models.LogMessage) error {
+	priority, rest, err := parsePriority(line)
+	if err != nil {
+		return err
+	}
+	msg.Level = syslogLevels[priority%8]
+	setMetadata(msg, "facility", syslogFacilities[priority/8])
+	setMetadata(msg, "severity", syslogSeverities[priority%8])
+
+	if strings.HasPrefix(rest, "1 ") {
+		return parseRFC5424(rest[2:], msg)
+	}
+	parseRFC3164(rest, msg)
+	return nil
+}
+
+// parsePriority reads the <PRI> every syslog message starts with
+func parsePriority(line string) (int, string, error) {
+	end := strings.IndexByte(line, '>')
+	if !strings.HasPrefix(line, "<") || end < 2 || end > 4 {
+		return 0, "", fmt.Errorf("invalid syslog message: no priority")
+	}
+	priority, err := strconv.Atoi(line[1:end])
+	if err != nil || priority < 0 || priority > 191 {
+		return 0, "", fmt.Errorf("invalid syslog priority %q", line[1:end])
+	}
+	return priority, line[end+1:], nil
+}
+
+// parseRFC5424 reads TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA [MSG],
+// where "-" stands for a missing field
+func parseRFC5424(rest string, msg *models.LogMessage) error {
+	var header [5]string
+	for i := range header {
+		field, after, ok := strings.Cut(rest, " ")
+		if !ok {
+			return fmt.Errorf("invalid RFC 5424 message: truncated header")
+		}
+		header[i] = field
+		rest = after
+	}
+
+	if header[0] != "-" {
+		ts, err := time.Parse(time.RFC3339Nano, header[0])
+		if err != nil {
+			return fmt.Errorf("invalid RFC 5424 timestamp %q", header[0])
+		}
+		msg.Timestamp = ts
+	}
+	if header[2] != "-" {
+		msg.Source = header[2]
+	}
+	if header[1] != "-" {
+		setMetadata(msg, "hostname", header[1])
+	}
+	if header[3] != "-" {
+		setMetadata(msg, "procid", header[3])
+	}
+	if header[4] != "-" {
+		setMetadata(msg, "msgid", header[4])
+	}
+
+	var err error
+	if strings.HasPrefix(rest, "-") {
+		rest = rest[1:]
+	} else if rest, err = parseStructuredData(rest, msg); err != nil {
+		return err
+	}
+
+	msg.Message = ""
+	if strings.HasPrefix(rest, " ") {
+		msg.Message = strings.TrimPrefix(rest[1:], "\xef\xbb\xbf")
+	}
+	return nil
+}
+
+// parseStructuredData reads [SD-ID PARAM="VALUE" ...] elements into Metadata and returns
+// what follows them
+func parseStructuredData(s string, msg *models.LogMessage) (string, error) {
+	if !strings.HasPrefix(s, "[") {
+		return "", fmt.Errorf("invalid RFC 5424 structured data")
+	}
+	for strings.HasPrefix(s, "[") {
+		s = s[1:]
+		end := strings.IndexAny(s, " ]")
+		if end <= 0 {
+			return "", fmt.Errorf("invalid RFC 5424 structured data: no element ID")
+		}
+		id := s[:end]
+		s = s[end:]
+
+		for strings.HasPrefix(s, " ") {
+			s = s[1:]
+			eq := strings.Index(s, `="`)
+			if eq <= 0 {
+				return "", fmt.Errorf("invalid RFC 5424 structured data in %s", id)
+			}
+			name := s[:eq]
+			s = s[eq+2:]
+
+			// Values escape ", \ and ] with a backslash
+			var value strings.Builder
+			i := 0
+			for ; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' && i+1 < len(s) && strings.IndexByte(`"\]`, s[i+1]) >= 0 {
+					i++
+				}
+				value.WriteByte(s[i])
+			}
+			if i == len(s) {
+				return "", fmt.Errorf("invalid RFC 5424 structured data: unterminated value of %s.%s", id, name)
+			}
+			setMetadata(msg, id+"."+name, value.String())
+			s = s[i+1:]
+		}
+
+		if !strings.HasPrefix(s, "]") {
+			return "", fmt.Errorf("invalid RFC 5424 structured data: unterminated element %s", id)
+		}
+		s = s[1:]
+	}
+	return s, nil
+}
+
+// parseRFC3164 reads the loosely specified BSD format, TIMESTAMP HOSTNAME TAG[PID]: MSG.
+// Every part of the header is optional; whatever cannot be recognized is the message.
+func parseRFC3164(rest string, msg *models.LogMessage) {
+	if len(rest) >= len(rfc3164Layout) {
+		if ts, err := time.ParseInLocation(rfc3164Layout, rest[:len(rfc3164Layout)], time.Local); err == nil {
+			// The year is missing, so take the one that does not put the message in the future
+			now := time.Now()
+			ts = ts.AddDate(now.Year(), 0, 0)
+			if ts.After(now.Add(24 * time.Hour)) {
+				ts = ts.AddDate(-1, 0, 0)
+			}
+			msg.Timestamp = ts
+			rest = strings.TrimPrefix(rest[len(rfc3164Layout):], " ")
+
+			// A hostname follows the timestamp unless the next word is already the tag
+			if _, _, _, ok := parseTag(rest); !ok {
+				if host, after, found := strings.Cut(rest, " "); found && host != "" {
+					setMetadata(msg, "hostname", host)
+					rest = after
+				}
+			}
+		}
+	}
+
+	if tag, pid, after, ok := parseTag(rest); ok {
+		msg.Source = tag
+		if pid != "" {
+			setMetadata(msg, "procid", pid)
+		}
+		rest = after
+	}
+	msg.Message = rest
+}
+
+// parseTag reads a TAG: or TAG[PID]: word
+func parseTag(s string) (string, string, string, bool) {
+	word, after, _ := strings.Cut(s, " ")
+	if !strings.HasSuffix(word, ":") || len(word) < 2 {
+		return "", "", s, false
+	}
+	tag := strings.TrimSuffix(word, ":")
+	pid := ""
+	if open := strings.IndexByte(tag, '['); open > 0 && strings.HasSuffix(tag, "]") {
+		pid = tag[open+1 : len(tag)-1]
+		tag = tag[:open]
+	}
+	return tag, pid, after, true
+}
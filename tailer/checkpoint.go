@@ -0,0 +1,91 @@
+package tailer
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// fileID identifies a file independently of its path
+type fileID struct {
+	Dev   uint64 `json:"dev"`
+	Inode uint64 `json:"inode"`
+}
+
+// pathID derives an identity from a path where the platform has no inodes
+func pathID(path string) fileID {
+	h := fnv.New64a()
+	h.Write([]byte(path))
+	return fileID{Inode: h.Sum64()}
+}
+
+// checkpointEntry is the read position in one file
+type checkpointEntry struct {
+	fileID
+	Path   string `json:"path"`
+	Offset int64  `json:"offset"`
+}
+
+// checkpoint is the content of the checkpoint file
+type checkpoint struct {
+	Files   []checkpointEntry `json:"files"`
+	Updated time.Time         `json:"updated"`
+}
+
+// loadCheckpoint reads the saved read positions, keyed by file identity.
+// A missing checkpoint file means nothing has been read yet.
+func loadCheckpoint(path string) (map[fileID]checkpointEntry, error) {
+	entries := make(map[fileID]checkpointEntry)
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return entries, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read checkpoint %s: %w", path, err)
+	}
+
+	var cp checkpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return nil, fmt.Errorf("failed to parse checkpoint %s: %w", path, err)
+	}
+	for _, entry := range cp.Files {
+		entries[entry.fileID] = entry
+	}
+	return entries, nil
+}
+
+// saveCheckpoint writes the read positions to a temporary file and renames it over the
+// checkpoint, so a crash leaves either the old or the new checkpoint in place
+func saveCheckpoint(path string, entries []checkpointEntry) error {
+	data, err := json.MarshalIndent(checkpoint{Files: entries, Updated: time.Now()}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal checkpoint: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create checkpoint directory: %w", err)
+	}
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create checkpoint: %w", err)
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to sync checkpoint: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to close checkpoint: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to replace checkpoint: %w", err)
+	}
+	return nil
+}
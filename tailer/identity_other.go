@@ -0,0 +1,11 @@
+//go:build !unix
+
+package tailer
+
+import "os"
+
+// identify falls back to the path where inodes are not available, so renamed files
+// are read again from the start
+func identify(path string, info os.FileInfo) fileID {
+	return pathID(path)
+}
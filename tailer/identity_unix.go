@@ -0,0 +1,16 @@
+//go:build unix
+
+package tailer
+
+import (
+	"os"
+	"syscall"
+)
+
+// identify returns the device and inode of a file, which survive renames
+func identify(path string, info os.FileInfo) fileID {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return fileID{Dev: uint64(stat.Dev), Inode: uint64(stat.Ino)}
+	}
+	return pathID(path)
+}
@@ -0,0 +1,554 @@
+package tailer
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"resolve/emitters"
+	"resolve/models"
+	"resolve/parser"
+)
+
+// Positions a file without a checkpoint starts reading from
+const (
+	StartAtBeginning = "beginning"
+	StartAtEnd       = "end"
+)
+
+const (
+	// maxReadPerPoll bounds how much of one file is read per scan, so a large backlog
+	// in one file does not hold up the others
+	maxReadPerPoll = 4 * 1024 * 1024
+	readBufferSize = 64 * 1024
+)
+
+// FileStats describes one tailed file
+type FileStats struct {
+	Path   string `json:"path"`
+	Inode  uint64 `json:"inode"`
+	Offset int64  `json:"offset"`
+	Size   int64  `json:"size"`
+}
+
+// Stats describes the files being tailed and what has been read from them
+type Stats struct {
+	Files          []FileStats `json:"files"`
+	LinesRead      int64       `json:"lines_read"`
+	PacketsSent    int64       `json:"packets_sent"`
+	SendFailures   int64       `json:"send_failures"`
+	ParseErrors    int64       `json:"parse_errors"`
+	Rotations      int64       `json:"rotations"`
+	Truncations    int64       `json:"truncations"`
+	LastCheckpoint time.Time   `json:"last_checkpoint"`
+}
+
+// trackedFile is an open file being followed
+type trackedFile struct {
+	id     fileID
+	path   string
+	input  *tailInput
+	file   *os.File
+	offset int64 // bytes read and handed to the emitter
+	size   int64
+	final  bool // the path no longer leads to this file; read it to the end, then close it
+	held   heldEvent
+}
+
+// tailInput is a configured input with its parser and multiline settings
+type tailInput struct {
+	*models.TailInput
+	parser    parser.Parser
+	multiline *parser.Multiline // nil when every line is its own message
+}
+
+// heldEvent is a multiline event at the end of a file that was left unsent because more
+// of its lines may follow
+type heldEvent struct {
+	start int64
+	end   int64
+	since time.Time
+}
+
+// Tailer follows the files matching its inputs' glob patterns and sends every new line
+// through an emitter. Files are tracked by inode, so a rotated file is read to the end
+// before its replacement is picked up, and a truncated file is read again from the start.
+// Read offsets only move once the emitter accepted a packet and are saved in the
+// checkpoint file, so a restart resumes where the last delivered line ended.
+type Tailer struct {
+	config  models.TailConfig
+	inputs  []tailInput
+	emitter models.Emitter
+	agentID string
+
+	mu             sync.Mutex
+	files          map[fileID]*trackedFile
+	saved          map[fileID]checkpointEntry // checkpointed offsets of files not opened yet
+	dirty          bool
+	lastCheckpoint time.Time
+
+	linesRead    atomic.Int64
+	packetsSent  atomic.Int64
+	sendFailures atomic.Int64
+	parseErrors  atomic.Int64
+	rotations    atomic.Int64
+	truncations  atomic.Int64
+}
+
+// New creates a tailer that sends lines through emitter, resuming from the checkpoint
+func New(agentID string, config models.TailConfig, emitter models.Emitter) (*Tailer, error) {
+	// Set default values if not provided
+	if config.PollInterval <= 0 {
+		config.PollInterval = 1000
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = 100
+	}
+	if config.Checkpoint == "" {
+		return nil, fmt.Errorf("no checkpoint file configured")
+	}
+	if len(config.Inputs) == 0 {
+		return nil, fmt.Errorf("no inputs configured")
+	}
+	inputs := make([]tailInput, len(config.Inputs))
+	for i := range config.Inputs {
+		input := &config.Inputs[i]
+		if len(input.Paths) == 0 {
+			return nil, fmt.Errorf("input %d has no paths", i+1)
+		}
+		for _, pattern := range input.Paths {
+			if _, err := filepath.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("invalid path pattern %q: %w", pattern, err)
+			}
+		}
+		switch input.StartAt {
+		case "":
+			input.StartAt = StartAtBeginning
+		case StartAtBeginning, StartAtEnd:
+		default:
+			return nil, fmt.Errorf("invalid start_at %q: must be %s or %s", input.StartAt, StartAtBeginning, StartAtEnd)
+		}
+		p, err := parser.New(input.Parser)
+		if err != nil {
+			return nil, fmt.Errorf("input %d: %w", i+1, err)
+		}
+		multiline, err := parser.NewMultiline(input.Multiline)
+		if err != nil {
+			return nil, fmt.Errorf("input %d: %w", i+1, err)
+		}
+		inputs[i] = tailInput{TailInput: input, parser: p, multiline: multiline}
+	}
+
+	saved, err := loadCheckpoint(config.Checkpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Tailer{
+		config:  config,
+		inputs:  inputs,
+		emitter: emitter,
+		agentID: agentID,
+		files:   make(map[fileID]*trackedFile),
+		saved:   saved,
+	}, nil
+}
+
+// Run scans the inputs every poll interval until ctx is done, then saves the
+// checkpoint and closes the files
+func (t *Tailer) Run(ctx context.Context) error {
+	ticker := time.NewTicker(time.Duration(t.config.PollInterval) * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		t.poll(ctx)
+
+		select {
+		case <-ctx.Done():
+			return t.close()
+		case <-ticker.C:
+		}
+	}
+}
+
+// poll picks up new, rotated and removed files and reads new lines from all of them
+func (t *Tailer) poll(ctx context.Context) {
+	t.mu.Lock()
+	matched := t.scan()
+
+	// Files whose path now leads elsewhere, or nowhere, are read to the end and closed
+	for id, tf := range t.files {
+		if match, ok := matched[id]; ok {
+			tf.path = match.path
+			tf.final = false
+			continue
+		}
+		if !tf.final {
+			tf.final = true
+			t.rotations.Add(1)
+			log.Printf("[TAIL] %s was rotated or removed, reading it to the end", tf.path)
+		}
+	}
+	for id, match := range matched {
+		if _, ok := t.files[id]; !ok {
+			t.open(id, match)
+		}
+	}
+	t.saved = nil
+
+	// Rotated files go first so their lines are sent before those of their replacements
+	files := make([]*trackedFile, 0, len(t.files))
+	for _, tf := range t.files {
+		files = append(files, tf)
+	}
+	t.mu.Unlock()
+
+	sort.Slice(files, func(i, j int) bool {
+		if files[i].final != files[j].final {
+			return files[i].final
+		}
+		return files[i].path < files[j].path
+	})
+
+	for _, tf := range files {
+		if ctx.Err() != nil {
+			break
+		}
+		err := t.read(ctx, tf)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("[TAIL] Failed to send lines from %s, retrying on the next scan: %v", tf.path, err)
+			continue
+		}
+		if err == nil && tf.final {
+			t.mu.Lock()
+			tf.file.Close()
+			delete(t.files, tf.id)
+			t.dirty = true
+			t.mu.Unlock()
+		}
+	}
+
+	if err := t.saveIfDirty(); err != nil {
+		log.Printf("[TAIL] Failed to save checkpoint: %v", err)
+	}
+}
+
+// fileMatch is a path matched by an input's glob patterns
+type fileMatch struct {
+	path  string
+	input *tailInput
+	info  os.FileInfo
+}
+
+// scan expands every input's glob patterns into the files they currently match.
+// The caller must hold mu.
+func (t *Tailer) scan() map[fileID]fileMatch {
+	matched := make(map[fileID]fileMatch)
+	for i := range t.inputs {
+		input := &t.inputs[i]
+		for _, pattern := range input.Paths {
+			paths, _ := filepath.Glob(pattern)
+			for _, path := range paths {
+				info, err := os.Stat(path)
+				if err != nil || !info.Mode().IsRegular() {
+					continue
+				}
+				id := identify(path, info)
+				if _, ok := matched[id]; !ok {
+					matched[id] = fileMatch{path: path, input: input, info: info}
+				}
+			}
+		}
+	}
+	return matched
+}
+
+// open starts following a file, at its checkpointed offset when there is one.
+// The caller must hold mu.
+func (t *Tailer) open(id fileID, match fileMatch) {
+	f, err := os.Open(match.path)
+	if err != nil {
+		log.Printf("[TAIL] Failed to open %s: %v", match.path, err)
+		return
+	}
+
+	size := match.info.Size()
+	offset := int64(0)
+	if entry, ok := t.saved[id]; ok {
+		offset = entry.Offset
+		if offset > size {
+			log.Printf("[TAIL] %s is shorter than its checkpoint, reading it from the start", match.path)
+			offset = 0
+		}
+	} else if match.input.StartAt == StartAtEnd && t.saved != nil {
+		// Only files present at startup skip their existing content; files that appear
+		// later are new and read in full
+		offset = size
+	}
+
+	t.files[id] = &trackedFile{
+		id:     id,
+		path:   match.path,
+		input:  match.input,
+		file:   f,
+		offset: offset,
+		size:   size,
+	}
+	t.dirty = true
+	log.Printf("[TAIL] Following %s from offset %d", match.path, offset)
+}
+
+// read sends the lines appended to a file since its offset. Lines are sent in packets of
+// up to BatchSize, and the offset moves past a packet's lines once the emitter accepted it.
+// An unterminated last line is left for the next scan unless the file was rotated away.
+// With multiline settings, consecutive lines of one event become one message, and an
+// event at the end of the file is held back until a new event starts, the file is rotated
+// away or no line was added to it for the flush timeout.
+func (t *Tailer) read(ctx context.Context, tf *trackedFile) error {
+	info, err := tf.file.Stat()
+	if err != nil {
+		return err
+	}
+	size := info.Size()
+	t.mu.Lock()
+	tf.size = size
+	t.mu.Unlock()
+	if size < tf.offset {
+		log.Printf("[TAIL] %s was truncated, reading it from the start", tf.path)
+		t.truncations.Add(1)
+		t.advance(tf, 0)
+	}
+	if size == tf.offset {
+		return nil
+	}
+
+	if _, err := tf.file.Seek(tf.offset, io.SeekStart); err != nil {
+		return err
+	}
+	reader := bufio.NewReaderSize(tf.file, readBufferSize)
+
+	var batch []models.LogMessage
+	var event lineEvent
+	multiline := tf.input.multiline
+	pos := tf.offset
+	eof := false
+	for pos-tf.offset < maxReadPerPoll {
+		line, err := reader.ReadString('\n')
+		if err == io.EOF && (line == "" || !tf.final) {
+			eof = true
+			break
+		}
+		if err != nil && err != io.EOF {
+			return err
+		}
+
+		lineOffset := pos
+		pos += int64(len(line))
+		text := strings.TrimRight(line, "\r\n")
+		if multiline != nil && event.lines > 0 && event.lines < multiline.MaxLines && multiline.Continues(text) {
+			event.add(text, pos)
+			continue
+		}
+
+		// The line starts a new event, so the one before it is complete
+		if event.lines > 0 {
+			if batch, err = t.queue(ctx, tf, batch, event); err != nil {
+				return err
+			}
+			event = lineEvent{}
+		}
+		if text == "" {
+			continue
+		}
+		event = lineEvent{text: text, start: lineOffset, end: pos, lines: 1}
+		if multiline == nil {
+			if batch, err = t.queue(ctx, tf, batch, event); err != nil {
+				return err
+			}
+			event = lineEvent{}
+		}
+	}
+
+	end := pos
+	if event.lines > 0 {
+		if eof && !tf.final && !t.flushDue(tf, event) {
+			end = event.start
+		} else if batch, err = t.queue(ctx, tf, batch, event); err != nil {
+			return err
+		}
+	}
+
+	if len(batch) > 0 {
+		return t.send(ctx, tf, batch, end)
+	}
+	if end != tf.offset {
+		t.advance(tf, end)
+	}
+	return nil
+}
+
+// lineEvent is one line, or the lines of one multiline event, read from a file
+type lineEvent struct {
+	text  string
+	start int64 // offset of the first line
+	end   int64 // offset after the last line
+	lines int
+}
+
+// add appends a continuation line ending at end
+func (e *lineEvent) add(text string, end int64) {
+	e.text += "\n" + text
+	e.end = end
+	e.lines++
+}
+
+// queue adds an event to the batch and sends the batch once it is full
+func (t *Tailer) queue(ctx context.Context, tf *trackedFile, batch []models.LogMessage, event lineEvent) ([]models.LogMessage, error) {
+	batch = append(batch, t.newMessage(tf, event.text, event.start))
+	if len(batch) < t.config.BatchSize {
+		return batch, nil
+	}
+	if err := t.send(ctx, tf, batch, event.end); err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// flushDue reports whether a multiline event at the end of a file has waited the flush
+// timeout without growing, so no more lines are expected for it
+func (t *Tailer) flushDue(tf *trackedFile, event lineEvent) bool {
+	if tf.held.start != event.start || tf.held.end != event.end {
+		tf.held = heldEvent{start: event.start, end: event.end, since: time.Now()}
+		return false
+	}
+	return time.Since(tf.held.since) >= tf.input.multiline.FlushTimeout
+}
+
+// send emits a packet of lines and moves the file's offset to end once the emitter took
+// it. A packet the emitter spooled counts as taken.
+func (t *Tailer) send(ctx context.Context, tf *trackedFile, batch []models.LogMessage, end int64) error {
+	packet := models.LogPacket{
+		PacketID:  fmt.Sprintf("%s-%d-%d", t.agentID, tf.id.Inode, tf.offset),
+		AgentID:   t.agentID,
+		Timestamp: time.Now(),
+		Messages:  batch,
+	}
+
+	err := t.emitter.EmitContext(ctx, packet)
+	if err != nil && !errors.Is(err, emitters.ErrSpooled) {
+		t.sendFailures.Add(1)
+		return err
+	}
+
+	t.linesRead.Add(int64(len(batch)))
+	t.packetsSent.Add(1)
+	t.advance(tf, end)
+	return nil
+}
+
+// newMessage turns a line into a log message with the input's parser. The ID is derived
+// from the file and the line's offset, so a line sent again after a crash keeps its ID.
+// A line the parser rejects is sent as it is, with a parse_error metadata field.
+func (t *Tailer) newMessage(tf *trackedFile, line string, offset int64) models.LogMessage {
+	source := tf.input.Source
+	if source == "" {
+		source = filepath.Base(tf.path)
+	}
+	msg := models.LogMessage{
+		ID:        fmt.Sprintf("%s-%d-%d", t.agentID, tf.id.Inode, offset),
+		Timestamp: time.Now(),
+		Level:     "INFO",
+		Source:    source,
+	}
+	if err := parser.Apply(tf.input.parser, line, &msg); err != nil {
+		t.parseErrors.Add(1)
+	}
+
+	// Where the line came from wins over fields of the same name in the line
+	if msg.Metadata == nil {
+		msg.Metadata = make(map[string]string)
+	}
+	msg.Metadata["file"] = tf.path
+	msg.Metadata["offset"] = fmt.Sprintf("%d", offset)
+	return msg
+}
+
+// advance moves a file's read offset. Only the polling goroutine changes offsets, so it
+// may read them without the lock.
+func (t *Tailer) advance(tf *trackedFile, offset int64) {
+	t.mu.Lock()
+	tf.offset = offset
+	t.dirty = true
+	t.mu.Unlock()
+}
+
+// saveIfDirty writes the checkpoint when an offset changed since the last save
+func (t *Tailer) saveIfDirty() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !t.dirty {
+		return nil
+	}
+	entries := make([]checkpointEntry, 0, len(t.files))
+	for _, tf := range t.files {
+		entries = append(entries, checkpointEntry{fileID: tf.id, Path: tf.path, Offset: tf.offset})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Path < entries[j].Path
+	})
+	if err := saveCheckpoint(t.config.Checkpoint, entries); err != nil {
+		return err
+	}
+	t.dirty = false
+	t.lastCheckpoint = time.Now()
+	return nil
+}
+
+// close saves the checkpoint and closes every file
+func (t *Tailer) close() error {
+	err := t.saveIfDirty()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for id, tf := range t.files {
+		tf.file.Close()
+		delete(t.files, id)
+	}
+	return err
+}
+
+// Stats returns the tailed files and counters
+func (t *Tailer) Stats() Stats {
+	t.mu.Lock()
+	files := make([]FileStats, 0, len(t.files))
+	for _, tf := range t.files {
+		files = append(files, FileStats{Path: tf.path, Inode: tf.id.Inode, Offset: tf.offset, Size: tf.size})
+	}
+	lastCheckpoint := t.lastCheckpoint
+	t.mu.Unlock()
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Path < files[j].Path
+	})
+	return Stats{
+		Files:          files,
+		LinesRead:      t.linesRead.Load(),
+		PacketsSent:    t.packetsSent.Load(),
+		SendFailures:   t.sendFailures.Load(),
+		ParseErrors:    t.parseErrors.Load(),
+		Rotations:      t.rotations.Load(),
+		Truncations:    t.truncations.Load(),
+		LastCheckpoint: lastCheckpoint,
+	}
+}
@@ -0,0 +1,544 @@
+package wal
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SyncPolicy controls when appended records are fsynced to disk
+type SyncPolicy string
+
+const (
+	SyncAlways   SyncPolicy = "always"   // fsync after every append
+	SyncInterval SyncPolicy = "interval" // fsync periodically in the background
+	SyncNone     SyncPolicy = "none"     // leave flushing to the operating system
+)
+
+const (
+	segmentExt         = ".wal"
+	recordHeaderSize   = 8 // 4 byte length + 4 byte CRC32
+	defaultSegmentSize = 64 * 1024 * 1024
+	maxRecordSize      = 256 * 1024 * 1024
+	defaultSyncEvery   = time.Second
+)
+
+// ErrClosed is returned when operating on a closed log
+var ErrClosed = errors.New("wal: log is closed")
+
+// Options holds write-ahead log configuration
+type Options struct {
+	SegmentSize  int64         // bytes per segment before rolling to a new one
+	SyncPolicy   SyncPolicy    // defaults to SyncAlways
+	SyncInterval time.Duration // used with SyncInterval
+}
+
+// segment describes one segment file on disk
+type segment struct {
+	first uint64 // index of the first record in the segment
+	count uint64 // number of records in the segment
+	size  int64  // bytes on disk
+	path  string
+}
+
+// Log is a segment-based append-only write-ahead log.
+// Records are addressed by a monotonically increasing index starting at 1.
+type Log struct {
+	dir      string
+	opts     Options
+	mu       sync.Mutex
+	segments []*segment
+	active   *os.File
+	dirty    bool
+	closed   bool
+	done     chan struct{}
+}
+
+// Open opens the log in dir, creating the directory if needed.
+// A torn record at the tail of the last segment is truncated away.
+func Open(dir string, opts Options) (*Log, error) {
+	if opts.SegmentSize <= 0 {
+		opts.SegmentSize = defaultSegmentSize
+	}
+	if opts.SyncPolicy == "" {
+		opts.SyncPolicy = SyncAlways
+	}
+	if opts.SyncInterval <= 0 {
+		opts.SyncInterval = defaultSyncEvery
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create wal directory %s: %w", dir, err)
+	}
+
+	l := &Log{
+		dir:  dir,
+		opts: opts,
+		done: make(chan struct{}),
+	}
+
+	if err := l.loadSegments(); err != nil {
+		return nil, err
+	}
+
+	if l.opts.SyncPolicy == SyncInterval {
+		go l.syncLoop()
+	}
+
+	return l, nil
+}
+
+// loadSegments scans the directory, validates every segment and opens the last one for appending
+func (l *Log) loadSegments() error {
+	entries, err := os.ReadDir(l.dir)
+	if err != nil {
+		return fmt.Errorf("failed to read wal directory %s: %w", l.dir, err)
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+		first, err := strconv.ParseUint(strings.TrimSuffix(name, segmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		l.segments = append(l.segments, &segment{
+			first: first,
+			path:  filepath.Join(l.dir, name),
+		})
+	}
+
+	sort.Slice(l.segments, func(i, j int) bool {
+		return l.segments[i].first < l.segments[j].first
+	})
+
+	for i, seg := range l.segments {
+		count, validSize, err := scanSegment(seg.path, nil)
+		if err != nil {
+			return err
+		}
+		seg.count = count
+		seg.size = validSize
+
+		info, err := os.Stat(seg.path)
+		if err != nil {
+			return fmt.Errorf("failed to stat segment %s: %w", seg.path, err)
+		}
+		if info.Size() != validSize {
+			if i != len(l.segments)-1 {
+				return fmt.Errorf("corrupt record in segment %s at offset %d", seg.path, validSize)
+			}
+			log.Printf("[WAL] Truncating torn tail of segment %s from %d to %d bytes", seg.path, info.Size(), validSize)
+			if err := os.Truncate(seg.path, validSize); err != nil {
+				return fmt.Errorf("failed to truncate segment %s: %w", seg.path, err)
+			}
+		}
+	}
+
+	if len(l.segments) == 0 {
+		return l.createSegment(1)
+	}
+
+	last := l.segments[len(l.segments)-1]
+	f, err := os.OpenFile(last.path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open segment %s: %w", last.path, err)
+	}
+	l.active = f
+	return nil
+}
+
+// createSegment starts a new active segment whose first record will have the given index
+func (l *Log) createSegment(first uint64) error {
+	path := filepath.Join(l.dir, fmt.Sprintf("%020d%s", first, segmentExt))
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create segment %s: %w", path, err)
+	}
+	if l.active != nil {
+		if err := l.active.Sync(); err != nil {
+			f.Close()
+			return fmt.Errorf("failed to sync segment: %w", err)
+		}
+		l.active.Close()
+	}
+	l.active = f
+	l.segments = append(l.segments, &segment{first: first, path: path})
+	return syncDir(l.dir)
+}
+
+// Append writes a record and returns its index
+func (l *Log) Append(data []byte) (uint64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return 0, ErrClosed
+	}
+
+	last := l.segments[len(l.segments)-1]
+	if last.count > 0 && last.size+int64(recordHeaderSize+len(data)) > l.opts.SegmentSize {
+		if err := l.createSegment(last.first + last.count); err != nil {
+			return 0, err
+		}
+		last = l.segments[len(l.segments)-1]
+	}
+
+	buf := make([]byte, recordHeaderSize+len(data))
+	binary.BigEndian.PutUint32(buf[0:4], uint32(len(data)))
+	binary.BigEndian.PutUint32(buf[4:8], crc32.ChecksumIEEE(data))
+	copy(buf[recordHeaderSize:], data)
+
+	if _, err := l.active.Write(buf); err != nil {
+		return 0, fmt.Errorf("failed to write record: %w", err)
+	}
+
+	switch l.opts.SyncPolicy {
+	case SyncAlways:
+		if err := l.active.Sync(); err != nil {
+			return 0, fmt.Errorf("failed to sync record: %w", err)
+		}
+	case SyncInterval:
+		l.dirty = true
+	}
+
+	index := last.first + last.count
+	last.count++
+	last.size += int64(len(buf))
+	return index, nil
+}
+
+// Replay calls fn for every record in the log in index order.
+// Iteration stops at the first error returned by fn.
+func (l *Log) Replay(fn func(index uint64, data []byte) error) error {
+	return l.ReplayFrom(0, fn)
+}
+
+// ReplayFrom calls fn for every record whose index is at least from, in index order
+func (l *Log) ReplayFrom(from uint64, fn func(index uint64, data []byte) error) error {
+	l.mu.Lock()
+	segments := make([]segment, 0, len(l.segments))
+	for _, seg := range l.segments {
+		if seg.first+seg.count > from {
+			segments = append(segments, *seg)
+		}
+	}
+	l.mu.Unlock()
+
+	for _, seg := range segments {
+		index := seg.first
+		limit := seg.count
+		_, _, err := scanSegment(seg.path, func(data []byte) error {
+			if limit == 0 {
+				return errStopScan
+			}
+			limit--
+			current := index
+			index++
+			if current < from {
+				return nil
+			}
+			return fn(current, data)
+		})
+		if err == errStopScan {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Reader reads records in index order, keeping its place in the open segment between
+// calls so that reading through the log takes one pass over it
+type Reader struct {
+	log   *Log
+	index uint64 // index of the next record to return
+	path  string // segment the file is open on
+	file  *os.File
+	buf   *bufio.Reader
+	pos   uint64 // index of the next record in the file
+}
+
+// NewReader returns a reader starting at record from. Records appended later are read
+// as they arrive.
+func (l *Log) NewReader(from uint64) *Reader {
+	return &Reader{log: l, index: from}
+}
+
+// Next returns the next record, skipping records truncated away since the last call.
+// It returns io.EOF when the reader has caught up with the end of the log.
+func (r *Reader) Next() (uint64, []byte, error) {
+	r.log.mu.Lock()
+	if r.log.closed {
+		r.log.mu.Unlock()
+		return 0, nil, ErrClosed
+	}
+	var current *segment
+	for _, seg := range r.log.segments {
+		if seg.first+seg.count > r.index {
+			current = seg
+			break
+		}
+	}
+	if current == nil {
+		r.log.mu.Unlock()
+		return 0, nil, io.EOF
+	}
+	if r.index < current.first {
+		r.index = current.first
+	}
+	path := current.path
+	if r.file == nil || r.path != path {
+		// Open the segment under the lock so that it cannot be truncated away first
+		r.Close()
+		f, err := os.Open(path)
+		if err != nil {
+			r.log.mu.Unlock()
+			return 0, nil, fmt.Errorf("failed to open segment %s: %w", path, err)
+		}
+		r.file, r.buf, r.path, r.pos = f, bufio.NewReader(f), path, current.first
+	}
+	r.log.mu.Unlock()
+
+	// Counted records are fully written, so only a damaged segment ends early
+	header := make([]byte, recordHeaderSize)
+	for {
+		data, ok := readRecord(r.buf, header)
+		if !ok {
+			r.Close()
+			return 0, nil, fmt.Errorf("failed to read record %d from %s", r.pos, path)
+		}
+		index := r.pos
+		r.pos++
+		if index == r.index {
+			r.index++
+			return index, data, nil
+		}
+	}
+}
+
+// Close releases the segment the reader has open. A closed reader reopens it on the
+// next call to Next.
+func (r *Reader) Close() error {
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file, r.buf, r.path = nil, nil, ""
+	return err
+}
+
+// TruncateFront removes whole segments whose records all have an index lower than index.
+// The active segment is never removed.
+func (l *Log) TruncateFront(index uint64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return ErrClosed
+	}
+
+	removed := 0
+	for removed < len(l.segments)-1 {
+		seg := l.segments[removed]
+		if seg.first+seg.count > index {
+			break
+		}
+		if err := os.Remove(seg.path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove segment %s: %w", seg.path, err)
+		}
+		removed++
+	}
+
+	if removed == 0 {
+		return nil
+	}
+	l.segments = append([]*segment(nil), l.segments[removed:]...)
+	return syncDir(l.dir)
+}
+
+// DropOldest removes the oldest segment whatever it holds and returns the index of the
+// oldest record left. The active segment is never removed.
+func (l *Log) DropOldest() (uint64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return 0, ErrClosed
+	}
+	if len(l.segments) < 2 {
+		return l.segments[0].first, nil
+	}
+
+	seg := l.segments[0]
+	if err := os.Remove(seg.path); err != nil && !os.IsNotExist(err) {
+		return 0, fmt.Errorf("failed to remove segment %s: %w", seg.path, err)
+	}
+	l.segments = append([]*segment(nil), l.segments[1:]...)
+	return l.segments[0].first, syncDir(l.dir)
+}
+
+// FirstIndex returns the index of the oldest record still on disk
+func (l *Log) FirstIndex() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.segments[0].first
+}
+
+// NextIndex returns the index the next appended record will receive
+func (l *Log) NextIndex() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	last := l.segments[len(l.segments)-1]
+	return last.first + last.count
+}
+
+// Size returns the total number of bytes held in all segments
+func (l *Log) Size() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var total int64
+	for _, seg := range l.segments {
+		total += seg.size
+	}
+	return total
+}
+
+// SegmentCount returns the number of segment files
+func (l *Log) SegmentCount() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.segments)
+}
+
+// Sync flushes the active segment to stable storage
+func (l *Log) Sync() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return ErrClosed
+	}
+	l.dirty = false
+	return l.active.Sync()
+}
+
+// Close syncs and closes the log
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return nil
+	}
+	l.closed = true
+	close(l.done)
+	if err := l.active.Sync(); err != nil {
+		l.active.Close()
+		return fmt.Errorf("failed to sync segment: %w", err)
+	}
+	return l.active.Close()
+}
+
+// syncLoop periodically fsyncs the active segment when the interval policy is used
+func (l *Log) syncLoop() {
+	ticker := time.NewTicker(l.opts.SyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.done:
+			return
+		case <-ticker.C:
+			l.mu.Lock()
+			if !l.closed && l.dirty {
+				if err := l.active.Sync(); err != nil {
+					log.Printf("[WAL] Background sync failed for %s: %v", l.dir, err)
+				}
+				l.dirty = false
+			}
+			l.mu.Unlock()
+		}
+	}
+}
+
+var errStopScan = errors.New("stop scan")
+
+// scanSegment reads every valid record in a segment, returning the record count
+// and the byte offset just past the last valid record
+func scanSegment(path string, fn func(data []byte) error) (uint64, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to open segment %s: %w", path, err)
+	}
+	defer f.Close()
+
+	reader := bufio.NewReader(f)
+	header := make([]byte, recordHeaderSize)
+	var count uint64
+	var offset int64
+
+	for {
+		data, ok := readRecord(reader, header)
+		if !ok {
+			return count, offset, nil
+		}
+
+		if fn != nil {
+			if err := fn(data); err != nil {
+				return count, offset, err
+			}
+		}
+
+		count++
+		offset += int64(recordHeaderSize) + int64(len(data))
+	}
+}
+
+// readRecord reads one record into a new buffer. It returns false at the end of the
+// valid portion of a segment: EOF, a torn record or a checksum mismatch.
+func readRecord(reader *bufio.Reader, header []byte) ([]byte, bool) {
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, false
+	}
+	length := binary.BigEndian.Uint32(header[0:4])
+	checksum := binary.BigEndian.Uint32(header[4:8])
+	if length > maxRecordSize {
+		return nil, false
+	}
+
+	data := make([]byte, length)
+	if _, err := io.ReadFull(reader, data); err != nil {
+		return nil, false
+	}
+	if crc32.ChecksumIEEE(data) != checksum {
+		return nil, false
+	}
+	return data, true
+}
+
+// syncDir fsyncs a directory so segment creation and removal are durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("failed to open wal directory %s: %w", dir, err)
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("failed to sync wal directory %s: %w", dir, err)
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+// Command benchmark compares the binary wire format with JSON on packets like the
+// emitter server's:
+//
+//	go run ./wire/benchmark -sizes 50,500
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"text/tabwriter"
+	"time"
+
+	"resolve/compression"
+	"resolve/models"
+	"resolve/wire"
+)
+
+var (
+	levels  = []string{"DEBUG", "INFO", "INFO", "INFO", "WARN", "ERROR", "FATAL"}
+	sources = []string{"web-server", "database", "auth-service", "payment-service", "user-service", "api-gateway", "cache-service", "notification-service"}
+	texts   = []string{
+		"Request processed successfully in %dms",
+		"Connection pool exhausted, waiting %dms for a connection",
+		"User session validated for request %d",
+		"Cache miss for key user:%d, loading from database",
+		"Payment gateway returned an invalid response after %dms",
+	}
+)
+
+// newPacket creates a packet of n messages with random levels, sources and metadata
+func newPacket(n int) models.LogPacket {
+	now := time.Now()
+	packet := models.LogPacket{
+		PacketID:  "packet-1",
+		AgentID:   "emitter-1",
+		Timestamp: now,
+		Messages:  make([]models.LogMessage, n),
+	}
+	for i := range packet.Messages {
+		packet.Messages[i] = models.LogMessage{
+			ID:        fmt.Sprintf("log-packet-1-%d", i),
+			Timestamp: now.Add(time.Duration(i) * time.Millisecond),
+			Level:     levels[rand.Intn(len(levels))],
+			Source:    sources[rand.Intn(len(sources))],
+			Message:   fmt.Sprintf(texts[rand.Intn(len(texts))], rand.Intn(1000)),
+			Metadata: map[string]string{
+				"user_id":    fmt.Sprintf("user-%d", rand.Intn(1000)),
+				"session_id": fmt.Sprintf("session-%d", rand.Intn(10000)),
+				"ip":         fmt.Sprintf("192.168.1.%d", rand.Intn(255)),
+			},
+		}
+	}
+	return packet
+}
+
+// codec is one packet encoding under benchmark
+type codec struct {
+	name      string
+	marshal   func(models.LogPacket) ([]byte, error)
+	unmarshal func([]byte, *models.LogPacket) error
+}
+
+var codecs = []codec{
+	{
+		name:      "json",
+		marshal:   func(p models.LogPacket) ([]byte, error) { return json.Marshal(p) },
+		unmarshal: func(data []byte, p *models.LogPacket) error { return json.Unmarshal(data, p) },
+	},
+	{
+		name:      "binary",
+		marshal:   func(p models.LogPacket) ([]byte, error) { return wire.Marshal(p), nil },
+		unmarshal: wire.Unmarshal,
+	},
+}
+
+func main() {
+	var sizes []int
+	flag.Func("sizes", "comma-separated messages per packet (default 50,500)", func(s string) error {
+		sizes = nil
+		for _, field := range strings.Split(s, ",") {
+			n, err := strconv.Atoi(strings.TrimSpace(field))
+			if err != nil || n <= 0 {
+				return fmt.Errorf("invalid size %q", field)
+			}
+			sizes = append(sizes, n)
+		}
+		return nil
+	})
+	flag.Parse()
+	if len(sizes) == 0 {
+		sizes = []int{50, 500}
+	}
+
+	out := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(out, "messages\tformat\tbytes\tgzip bytes\tmarshal ns/op\tallocs/op\tunmarshal ns/op\tallocs/op\t")
+	for _, n := range sizes {
+		packet := newPacket(n)
+		want, _ := json.Marshal(packet)
+		for _, c := range codecs {
+			data, err := c.marshal(packet)
+			if err != nil {
+				log.Fatalf("%s: %v", c.name, err)
+			}
+			// Both formats must decode to the packet that was encoded
+			var check models.LogPacket
+			if err := c.unmarshal(data, &check); err != nil {
+				log.Fatalf("%s: %v", c.name, err)
+			}
+			if got, _ := json.Marshal(check); string(got) != string(want) {
+				log.Fatalf("%s: packet does not round-trip", c.name)
+			}
+			gzipped, _ := compression.Encode(compression.Gzip, data)
+
+			marshal := testing.Benchmark(func(b *testing.B) {
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					c.marshal(packet)
+				}
+			})
+			unmarshal := testing.Benchmark(func(b *testing.B) {
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					var p models.LogPacket
+					c.unmarshal(data, &p)
+				}
+			})
+
+			fmt.Fprintf(out, "%d\t%s\t%d\t%d\t%d\t%d\t%d\t%d\t\n",
+				n, c.name, len(data), len(gzipped),
+				marshal.NsPerOp(), marshal.AllocsPerOp(),
+				unmarshal.NsPerOp(), unmarshal.AllocsPerOp())
+		}
+	}
+	out.Flush()
+}
@@ -0,0 +1,302 @@
+package wire
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"mime"
+	"time"
+
+	"resolve/models"
+)
+
+// The binary packet format is a header followed by the packet's fields in a fixed order.
+// Strings and lists are prefixed with their length as a uvarint. Levels, sources and
+// metadata keys repeat across the messages of a packet, so they are interned: the first
+// occurrence is written out and later ones refer back to it.
+//
+//	packet:  magic "LPK", version byte, string packet_id, string agent_id, time timestamp,
+//	         uvarint count, message...
+//	message: string id, time timestamp, ref level, ref source, string message,
+//	         uvarint count, (ref key, string value)...
+//	string:  uvarint length, UTF-8 bytes
+//	ref:     uvarint 0 followed by a string, which is added to the table, or uvarint n
+//	         for the nth string added to the table
+//	time:    varint Unix seconds, uvarint nanoseconds, varint zone offset in seconds
+
+// ContentType marks a request body in the binary packet format
+const ContentType = "application/x-logpacket"
+
+// Version is the schema version written after the magic bytes. A decoder only reads the
+// versions it knows.
+const Version = 1
+
+// magic starts every encoded packet
+const magic = "LPK"
+
+// maxInterned bounds the strings a packet interns, and so the decoder's table; strings
+// past it are written out every time
+const maxInterned = 4096
+
+// minMessageSize is the fewest bytes an encoded message takes
+const minMessageSize = 8
+
+var (
+	// ErrVersion is returned for a packet written with a schema version this decoder
+	// does not know
+	ErrVersion = errors.New("unsupported packet version")
+
+	errTruncated = errors.New("truncated packet")
+)
+
+// IsContentType reports whether a Content-Type header names the binary packet format
+func IsContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && mediaType == ContentType
+}
+
+// encoder appends a packet to buf, interning repeated strings
+type encoder struct {
+	buf      []byte
+	interned map[string]uint64
+}
+
+// Marshal encodes a packet in the binary format
+func Marshal(packet models.LogPacket) []byte {
+	e := encoder{
+		buf:      make([]byte, 0, 64+len(packet.Messages)*160),
+		interned: make(map[string]uint64),
+	}
+	e.buf = append(e.buf, magic...)
+	e.buf = append(e.buf, Version)
+	e.string(packet.PacketID)
+	e.string(packet.AgentID)
+	e.time(packet.Timestamp)
+	e.buf = binary.AppendUvarint(e.buf, uint64(len(packet.Messages)))
+	for i := range packet.Messages {
+		e.message(&packet.Messages[i])
+	}
+	return e.buf
+}
+
+// message appends one log message
+func (e *encoder) message(msg *models.LogMessage) {
+	e.string(msg.ID)
+	e.time(msg.Timestamp)
+	e.ref(msg.Level)
+	e.ref(msg.Source)
+	e.string(msg.Message)
+	e.buf = binary.AppendUvarint(e.buf, uint64(len(msg.Metadata)))
+	for key, value := range msg.Metadata {
+		e.ref(key)
+		e.string(value)
+	}
+}
+
+// string appends a length-prefixed string
+func (e *encoder) string(s string) {
+	e.buf = binary.AppendUvarint(e.buf, uint64(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+// ref appends a reference to an interned string, interning it on first use
+func (e *encoder) ref(s string) {
+	if n, ok := e.interned[s]; ok {
+		e.buf = binary.AppendUvarint(e.buf, n)
+		return
+	}
+	e.buf = append(e.buf, 0)
+	e.string(s)
+	if len(e.interned) < maxInterned {
+		e.interned[s] = uint64(len(e.interned) + 1)
+	}
+}
+
+// time appends a timestamp, keeping its zone offset as JSON does
+func (e *encoder) time(t time.Time) {
+	_, offset := t.Zone()
+	e.buf = binary.AppendVarint(e.buf, t.Unix())
+	e.buf = binary.AppendUvarint(e.buf, uint64(t.Nanosecond()))
+	e.buf = binary.AppendVarint(e.buf, int64(offset))
+}
+
+// decoder reads a packet. Every string is a substring of one copy of the body, which
+// saves an allocation per field.
+type decoder struct {
+	data     string
+	pos      int
+	interned []string
+
+	// The zone of the last non-UTC timestamp, which the next ones most likely share
+	zone       *time.Location
+	zoneOffset int64
+}
+
+// Unmarshal decodes a packet in the binary format
+func Unmarshal(data []byte, packet *models.LogPacket) error {
+	if len(data) < len(magic)+1 || string(data[:len(magic)]) != magic {
+		return errors.New("not a binary log packet")
+	}
+	if data[len(magic)] != Version {
+		return fmt.Errorf("%w %d", ErrVersion, data[len(magic)])
+	}
+
+	d := decoder{data: string(data), pos: len(magic) + 1}
+	var err error
+	if packet.PacketID, err = d.string(); err != nil {
+		return err
+	}
+	if packet.AgentID, err = d.string(); err != nil {
+		return err
+	}
+	if packet.Timestamp, err = d.time(); err != nil {
+		return err
+	}
+	count, err := d.count(minMessageSize)
+	if err != nil {
+		return err
+	}
+	packet.Messages = make([]models.LogMessage, count)
+	for i := range packet.Messages {
+		if err := d.message(&packet.Messages[i]); err != nil {
+			return fmt.Errorf("message %d: %w", i, err)
+		}
+	}
+	if d.pos != len(d.data) {
+		return fmt.Errorf("%d bytes after the last message", len(d.data)-d.pos)
+	}
+	return nil
+}
+
+// message reads one log message
+func (d *decoder) message(msg *models.LogMessage) error {
+	var err error
+	if msg.ID, err = d.string(); err != nil {
+		return err
+	}
+	if msg.Timestamp, err = d.time(); err != nil {
+		return err
+	}
+	if msg.Level, err = d.ref(); err != nil {
+		return err
+	}
+	if msg.Source, err = d.ref(); err != nil {
+		return err
+	}
+	if msg.Message, err = d.string(); err != nil {
+		return err
+	}
+
+	// A metadata entry takes at least a key reference and a value length
+	count, err := d.count(2)
+	if err != nil || count == 0 {
+		return err
+	}
+	msg.Metadata = make(map[string]string, count)
+	for i := 0; i < count; i++ {
+		key, err := d.ref()
+		if err != nil {
+			return err
+		}
+		if msg.Metadata[key], err = d.string(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// uvarint reads an unsigned varint
+func (d *decoder) uvarint() (uint64, error) {
+	var v uint64
+	for shift := 0; shift < 64; shift += 7 {
+		if d.pos >= len(d.data) {
+			return 0, errTruncated
+		}
+		b := d.data[d.pos]
+		d.pos++
+		v |= uint64(b&0x7f) << shift
+		if b < 0x80 {
+			return v, nil
+		}
+	}
+	return 0, errors.New("varint overflows 64 bits")
+}
+
+// varint reads a zig-zag encoded signed varint
+func (d *decoder) varint() (int64, error) {
+	u, err := d.uvarint()
+	return int64(u>>1) ^ -int64(u&1), err
+}
+
+// count reads a list length, rejecting lengths the rest of the body cannot hold when
+// every element takes at least minSize bytes
+func (d *decoder) count(minSize int) (int, error) {
+	n, err := d.uvarint()
+	if err != nil {
+		return 0, err
+	}
+	if n > uint64((len(d.data)-d.pos)/minSize) {
+		return 0, errTruncated
+	}
+	return int(n), nil
+}
+
+// string reads a length-prefixed string
+func (d *decoder) string() (string, error) {
+	n, err := d.count(1)
+	if err != nil {
+		return "", err
+	}
+	s := d.data[d.pos : d.pos+n]
+	d.pos += n
+	return s, nil
+}
+
+// ref reads a reference to an interned string, or a new string to intern
+func (d *decoder) ref() (string, error) {
+	n, err := d.uvarint()
+	if err != nil {
+		return "", err
+	}
+	if n > 0 {
+		if n > uint64(len(d.interned)) {
+			return "", fmt.Errorf("reference to unknown string %d", n)
+		}
+		return d.interned[n-1], nil
+	}
+
+	s, err := d.string()
+	if err == nil && len(d.interned) < maxInterned {
+		d.interned = append(d.interned, s)
+	}
+	return s, err
+}
+
+// time reads a timestamp. UTC times come back in UTC and others in a fixed zone with
+// their offset.
+func (d *decoder) time() (time.Time, error) {
+	seconds, err := d.varint()
+	if err != nil {
+		return time.Time{}, err
+	}
+	nanos, err := d.uvarint()
+	if err != nil {
+		return time.Time{}, err
+	}
+	offset, err := d.varint()
+	if err != nil {
+		return time.Time{}, err
+	}
+	if nanos >= uint64(time.Second) {
+		return time.Time{}, fmt.Errorf("invalid nanoseconds %d", nanos)
+	}
+
+	t := time.Unix(seconds, int64(nanos))
+	if offset == 0 {
+		return t.UTC(), nil
+	}
+	if d.zone == nil || d.zoneOffset != offset {
+		d.zone, d.zoneOffset = time.FixedZone("", int(offset)), offset
+	}
+	return t.In(d.zone), nil
+}